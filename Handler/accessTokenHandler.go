@@ -0,0 +1,131 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"redditBack/service"
+	"redditBack/utility"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type AccessTokenHandler struct {
+	accessTokenService service.PersonalAccessTokenService
+}
+
+func NewAccessTokenHandler(accessTokenService service.PersonalAccessTokenService) AccessTokenHandler {
+	return AccessTokenHandler{accessTokenService: accessTokenService}
+}
+
+// CreateToken godoc
+// @Summary Create a personal access token
+// @Description Create a long-lived token for scripts and bots, limited to the given scopes (read, posts:write, votes:write). The token is only returned once
+// @Tags tokens
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param token body handler.AccessTokenHandler.CreateToken.true.req true "Token name, scopes and optional lifetime"
+// @Success 201 {object} map[string]interface{} "Token created"
+// @Failure 400 {object} map[string]string "Invalid request or scope"
+// @Failure 500 {object} map[string]string "Internal server error"
+// @Router /tokens [post]
+func (h *AccessTokenHandler) CreateToken(c *gin.Context) {
+	var req struct {
+		Name          string   `json:"name" binding:"required,max=100"`
+		Scopes        []string `json:"scopes" binding:"required"`
+		ExpiresInDays int      `json:"expires_in_days" binding:"min=0"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	principal, ok := utility.CurrentPrincipal(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user passed from context"})
+		return
+	}
+
+	ttl := time.Duration(req.ExpiresInDays) * 24 * time.Hour
+	raw, token, err := h.accessTokenService.Create(c.Request.Context(), principal, req.Name, req.Scopes, ttl)
+	if err != nil {
+		if errors.Is(err, service.ErrInvalidScope) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create token"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"token":   raw,
+		"details": token,
+	})
+}
+
+// ListTokens godoc
+// @Summary List personal access tokens
+// @Description List the current user's active personal access tokens. The tokens themselves are not included
+// @Tags tokens
+// @Security BearerAuth
+// @Produce json
+// @Success 200 {array} model.PersonalAccessToken
+// @Failure 500 {object} map[string]string "Internal server error"
+// @Router /tokens [get]
+func (h *AccessTokenHandler) ListTokens(c *gin.Context) {
+	principal, ok := utility.CurrentPrincipal(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user passed from context"})
+		return
+	}
+
+	tokens, err := h.accessTokenService.List(c.Request.Context(), principal)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list tokens"})
+		return
+	}
+
+	c.JSON(http.StatusOK, tokens)
+}
+
+// RevokeToken godoc
+// @Summary Revoke a personal access token
+// @Description Revoke one of the current user's personal access tokens
+// @Tags tokens
+// @Security BearerAuth
+// @Produce json
+// @Param id path int true "Token ID"
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} map[string]string "Invalid token ID"
+// @Failure 404 {object} map[string]string "Token not found"
+// @Failure 500 {object} map[string]string "Internal server error"
+// @Router /tokens/{id} [delete]
+func (h *AccessTokenHandler) RevokeToken(c *gin.Context) {
+	principal, ok := utility.CurrentPrincipal(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user passed from context"})
+		return
+	}
+
+	tokenID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid token ID"})
+		return
+	}
+
+	err = h.accessTokenService.Revoke(c.Request.Context(), principal, uint(tokenID))
+	if err != nil {
+		switch err.Error() {
+		case "token not found":
+			c.JSON(http.StatusNotFound, gin.H{"error": "token not found"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to revoke token"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "token revoked"})
+}
@@ -0,0 +1,215 @@
+package handler
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"redditBack/service"
+	"redditBack/utility"
+
+	"github.com/gin-gonic/gin"
+)
+
+type AccountHandler struct {
+	accountService service.AccountService
+}
+
+func NewAccountHandler(accountService service.AccountService) AccountHandler {
+	return AccountHandler{accountService: accountService}
+}
+
+// reauthRequest holds the factor confirming a sensitive account change. The
+// passkey fields are the base64url encoded response to the options from
+// /passkeys/reauth/begin.
+type reauthRequest struct {
+	Password string `json:"password"`
+	TOTPCode string `json:"totp_code"`
+	Passkey  *struct {
+		CredentialID      string `json:"credential_id" binding:"required"`
+		ClientDataJSON    string `json:"client_data_json" binding:"required"`
+		AuthenticatorData string `json:"authenticator_data" binding:"required"`
+		Signature         string `json:"signature" binding:"required"`
+	} `json:"passkey"`
+}
+
+func (r *reauthRequest) decode(clientIP string) (service.Reauthentication, error) {
+	reauth := service.Reauthentication{Password: r.Password, TOTPCode: r.TOTPCode, ClientIP: clientIP}
+	if r.Passkey == nil {
+		return reauth, nil
+	}
+
+	var decoded [4][]byte
+	for i, value := range []string{r.Passkey.CredentialID, r.Passkey.ClientDataJSON, r.Passkey.AuthenticatorData, r.Passkey.Signature} {
+		raw, err := utility.DecodeWebAuthnBinary(value)
+		if err != nil {
+			return reauth, err
+		}
+		decoded[i] = raw
+	}
+	reauth.Passkey = &service.PasskeyAssertion{
+		CredentialID:      decoded[0],
+		ClientDataJSON:    decoded[1],
+		AuthenticatorData: decoded[2],
+		Signature:         decoded[3],
+	}
+	return reauth, nil
+}
+
+// writeReauthError answers a failed reauthentication and reports whether err
+// was one.
+func writeReauthError(c *gin.Context, err error) bool {
+	if writeLoginBlocked(c, err) {
+		return true
+	}
+	switch {
+	case errors.Is(err, service.ErrIncorrectPassword), errors.Is(err, service.ErrInvalidMFACode),
+		errors.Is(err, service.ErrInvalidPasskey), errors.Is(err, service.ErrReauthenticationRequired):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrMFANotEnabled), errors.Is(err, service.ErrInvalidPasskeyChallenge),
+		errors.Is(err, utility.ErrInvalidWebAuthnResponse):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		return false
+	}
+	return true
+}
+
+// GetProfile godoc
+// @Summary Get a user's public profile
+// @Description Public profile of a user with post count and karma
+// @Tags account
+// @Produce json
+// @Param username path string true "Username"
+// @Success 200 {object} service.PublicProfile
+// @Failure 404 {object} map[string]string "User not found"
+// @Failure 500 {object} map[string]string "Internal server error"
+// @Router /users/{username} [get]
+func (h *AccountHandler) GetProfile(c *gin.Context) {
+	profile, err := h.accountService.GetPublicProfile(c.Request.Context(), c.Param("username"))
+	if err != nil {
+		if errors.Is(err, service.ErrUserNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load profile"})
+		return
+	}
+
+	c.JSON(http.StatusOK, profile)
+}
+
+// UpdateProfile godoc
+// @Summary Update own profile
+// @Description Change display name, bio, avatar, email or password. Omitted fields stay unchanged. A new email has to be verified again. A new password signs out other sessions and revokes personal access tokens. Email or password changes need the current password, a TOTP code or a passkey assertion
+// @Tags account
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param request body handler.AccountHandler.UpdateProfile.true.req true "Fields to change"
+// @Success 200 {object} model.User
+// @Failure 400 {object} map[string]string "Invalid request format"
+// @Failure 401 {object} map[string]string "Reauthentication failed or required"
+// @Failure 409 {object} map[string]string "Email address already in use"
+// @Failure 423 {object} map[string]string "Account temporarily locked"
+// @Failure 429 {object} map[string]string "Too many failed attempts"
+// @Failure 500 {object} map[string]string "Internal server error"
+// @Router /me [patch]
+func (h *AccountHandler) UpdateProfile(c *gin.Context) {
+	principal, ok := utility.CurrentPrincipal(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user passed from context"})
+		return
+	}
+	var req struct {
+		DisplayName     *string `json:"display_name" binding:"omitempty,max=50"`
+		Bio             *string `json:"bio" binding:"omitempty,max=500"`
+		AvatarURL       *string `json:"avatar_url" binding:"omitempty,max=500"`
+		Email           *string `json:"email" binding:"omitempty,email"`
+		NewPassword     *string `json:"new_password" binding:"omitempty,min=6,max=72"`
+		CurrentPassword string  `json:"current_password"`
+		reauthRequest
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Password == "" {
+		req.Password = req.CurrentPassword
+	}
+	reauth, err := req.decode(c.ClientIP())
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err := h.accountService.UpdateProfile(c.Request.Context(), principal, service.ProfileUpdate{
+		DisplayName: req.DisplayName,
+		Bio:         req.Bio,
+		AvatarURL:   req.AvatarURL,
+		Email:       req.Email,
+		NewPassword: req.NewPassword,
+		Reauth:      reauth,
+	})
+	if err != nil {
+		if writeReauthError(c, err) {
+			return
+		}
+		switch {
+		case errors.Is(err, service.ErrEmailTaken):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		case errors.Is(err, service.ErrInvalidAvatarURL), errors.Is(err, service.ErrReservedEmail):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update profile"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, user)
+}
+
+// DeleteAccount godoc
+// @Summary Delete own account
+// @Description Delete the current user's account after confirming it with the password, a TOTP code or a passkey assertion, unless the session signed in within the last few minutes. Personal data is removed at once, posts remain under "[deleted]" and every session is signed out
+// @Tags account
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param request body handler.AccountHandler.DeleteAccount.true.req false "Password, TOTP code or passkey assertion"
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} map[string]string "Invalid request format"
+// @Failure 401 {object} map[string]string "Reauthentication failed or required"
+// @Failure 423 {object} map[string]string "Account temporarily locked"
+// @Failure 429 {object} map[string]string "Too many failed attempts"
+// @Failure 500 {object} map[string]string "Internal server error"
+// @Router /me [delete]
+func (h *AccountHandler) DeleteAccount(c *gin.Context) {
+	principal, ok := utility.CurrentPrincipal(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user passed from context"})
+		return
+	}
+	var req struct {
+		reauthRequest
+	}
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	reauth, err := req.decode(c.ClientIP())
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err = h.accountService.DeleteAccount(c.Request.Context(), principal, reauth)
+	if err != nil {
+		if writeReauthError(c, err) {
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete account"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "account deleted"})
+}
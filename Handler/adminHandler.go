@@ -0,0 +1,110 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"redditBack/service"
+	"redditBack/utility"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type AdminHandler struct {
+	loginGuardService service.LoginGuardService
+	roleService       service.RoleService
+}
+
+func NewAdminHandler(loginGuardService service.LoginGuardService, roleService service.RoleService) AdminHandler {
+	return AdminHandler{loginGuardService: loginGuardService, roleService: roleService}
+}
+
+// UnlockUser godoc
+// @Summary Unlock an account
+// @Description Lift a login lockout and back-off before it expires on its own
+// @Tags admin
+// @Security BearerAuth
+// @Produce json
+// @Param id path int true "User ID"
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} map[string]string "Invalid user ID"
+// @Failure 403 {object} map[string]string "Not an administrator"
+// @Failure 404 {object} map[string]string "User not found"
+// @Failure 500 {object} map[string]string "Internal server error"
+// @Router /admin/users/{id}/unlock [post]
+func (h *AdminHandler) UnlockUser(c *gin.Context) {
+	userID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user ID"})
+		return
+	}
+
+	if err := h.loginGuardService.Unlock(c.Request.Context(), uint(userID)); err != nil {
+		if errors.Is(err, service.ErrUserNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to unlock account"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "account unlocked"})
+}
+
+// SetRole godoc
+// @Summary Change a user's role
+// @Description Make a user a regular user, moderator or administrator. Moderators and administrators must have two-factor authentication enabled
+// @Tags admin
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param id path int true "User ID"
+// @Param role body handler.AdminHandler.SetRole.true.req true "New role"
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} map[string]string "Invalid user ID or role"
+// @Failure 403 {object} map[string]string "Not an administrator"
+// @Failure 404 {object} map[string]string "User not found"
+// @Failure 409 {object} map[string]string "User has no two-factor authentication"
+// @Failure 500 {object} map[string]string "Internal server error"
+// @Router /admin/users/{id}/role [put]
+func (h *AdminHandler) SetRole(c *gin.Context) {
+	var req struct {
+		Role string `json:"role" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	userID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user ID"})
+		return
+	}
+
+	principal, ok := utility.CurrentPrincipal(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user passed from context"})
+		return
+	}
+
+	err = h.roleService.SetRole(c.Request.Context(), principal, uint(userID), req.Role)
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrInvalidRole), errors.Is(err, service.ErrCannotChangeOwnRole):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case errors.Is(err, service.ErrForbidden):
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		case errors.Is(err, service.ErrRoleRequiresMFA):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		case errors.Is(err, service.ErrUserNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to change role"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "role updated"})
+}
@@ -1,39 +1,55 @@
 package handler
 
 import (
+	"errors"
+	"io"
+	"log"
 	"net/http"
 	"redditBack/model"
 	"redditBack/service"
 	"redditBack/utility"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
 type AuthHandler struct {
-	authService service.AuthService
+	authService         service.AuthService
+	tokenService        service.TokenService
+	verificationService service.VerificationService
+	cookies             utility.SessionCookieConfig
 }
 
-func NewAuthHandler(authService service.AuthService) AuthHandler {
-	return AuthHandler{authService: authService}
+func NewAuthHandler(authService service.AuthService, tokenService service.TokenService, verificationService service.VerificationService,
+	cookies utility.SessionCookieConfig) AuthHandler {
+	return AuthHandler{
+		authService:         authService,
+		tokenService:        tokenService,
+		verificationService: verificationService,
+		cookies:             cookies,
+	}
 }
 
 
 // SignUp godoc
 // @Summary Register a new user
-// @Description Create a new user account
+// @Description Create a new user account. While registration is invite only an invite_code is required. With use_cookies the tokens are set as HttpOnly cookies for browser clients
 // @Tags authentication
 // @Accept json
 // @Produce json
 // @Param credentials body handler.AuthHandler.SignUp.true.req true "User registration data"
 // @Success 201 {object} map[string]interface{} "Successfully created user"
 // @Failure 400 {object} map[string]string "Invalid request format"
+// @Failure 403 {object} map[string]string "Missing or invalid invite code"
 // @Failure 500 {object} map[string]string "Internal server error"
 // @Router /signup [post]
 func (h *AuthHandler) SignUp(c *gin.Context) {
 	var req struct {
-		Username string `json:"username" binding:"required"`
-		Email    string `json:"email" binding:"required,email"`
-		Password string `json:"password" binding:"required,min=6"`
+		Username   string `json:"username" binding:"required"`
+		Email      string `json:"email" binding:"required,email"`
+		Password   string `json:"password" binding:"required,min=6,max=72"`
+		InviteCode string `json:"invite_code"`
+		UseCookies bool   `json:"use_cookies"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -41,33 +57,53 @@ func (h *AuthHandler) SignUp(c *gin.Context) {
 		return
 	}
 	tempUser := &model.User{
-		Username:     req.Username,
-		PasswordHash: req.Password,
-		Email:        req.Email,
+		Username: req.Username,
+		Email:    req.Email,
 	}
 
-	err := h.authService.Register(c.Request.Context(), tempUser)
+	err := h.authService.Register(c.Request.Context(), tempUser, req.Password, req.InviteCode)
 	if err != nil {
+		if errors.Is(err, service.ErrInviteRequired) || errors.Is(err, service.ErrInvalidInvite) {
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return
+		}
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	token, err := utility.GenerateToken(req.Username)
+	if err := h.verificationService.SendVerification(c.Request.Context(), tempUser); err != nil {
+		log.Printf("failed to send verification email to user %d: %v", tempUser.ID, err)
+	}
+
+	tokens, err := h.tokenService.IssueTokens(c.Request.Context(), tempUser, sessionMeta(c))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate token"})
 		return
 	}
 
-	c.JSON(http.StatusCreated, gin.H{
-		"token": token,
-		"user":  req.Username,
-	})
+	writeTokens(c, h.cookies, http.StatusCreated, tokens, req.Username, req.UseCookies)
 }
 
 
+// writeLoginBlocked answers an attempt refused by the login guard and
+// reports whether err was one.
+func writeLoginBlocked(c *gin.Context, err error) bool {
+	var blocked *service.LoginBlockedError
+	if !errors.As(err, &blocked) {
+		return false
+	}
+	c.Header("Retry-After", blocked.RetryAfterSeconds())
+	if blocked.Locked {
+		c.JSON(http.StatusLocked, gin.H{"error": blocked.Error()})
+	} else {
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": blocked.Error()})
+	}
+	return true
+}
+
 // Login godoc
 // @Summary Authenticate user
-// @Description Login with username and password to get JWT token
+// @Description Login with username and password to get JWT token. Users with two-factor authentication get an mfa_token to complete at /login/mfa instead. With use_cookies the tokens are set as HttpOnly cookies and state-changing requests must send the csrf_token in the X-CSRF-Token header
 // @Tags authentication
 // @Accept json
 // @Produce json
@@ -75,12 +111,15 @@ func (h *AuthHandler) SignUp(c *gin.Context) {
 // @Success 200 {object} map[string]interface{} "Successfully logged in"
 // @Failure 400 {object} map[string]string "Invalid request format"
 // @Failure 401 {object} map[string]string "Invalid credentials"
+// @Failure 423 {object} map[string]string "Account temporarily locked"
+// @Failure 429 {object} map[string]string "Too many failed attempts"
 // @Failure 500 {object} map[string]string "Internal server error"
 // @Router /login [post]
 func (h *AuthHandler) Login(c *gin.Context) {
 	var req struct {
-		Username string `json:"username" binding:"required"`
-		Password string `json:"password" binding:"required"`
+		Username   string `json:"username" binding:"required"`
+		Password   string `json:"password" binding:"required"`
+		UseCookies bool   `json:"use_cookies"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -88,28 +127,165 @@ func (h *AuthHandler) Login(c *gin.Context) {
 		return
 	}
 
-	user, err := h.authService.Login(c.Request.Context(), req.Username, req.Password)
+	result, err := h.authService.Login(c.Request.Context(), req.Username, req.Password, c.ClientIP())
+	if writeLoginBlocked(c, err) {
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid credentials"})
 		return
 	}
 
-	token, err := utility.GenerateToken(req.Username)
+	if result.MFARequired {
+		c.JSON(http.StatusOK, gin.H{
+			"mfa_required": true,
+			"mfa_token":    result.MFAChallenge,
+			"mfa_methods":  result.MFAMethods,
+		})
+		return
+	}
+
+	user := result.User
+	tokens, err := h.tokenService.IssueTokens(c.Request.Context(), user, sessionMeta(c))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate token"})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"token": token,
-		"user":  user,
-	})
+	writeTokens(c, h.cookies, http.StatusOK, tokens, user, req.UseCookies)
 }
 
 
+// RefreshToken godoc
+// @Summary Refresh access token
+// @Description Exchange a refresh token for a new access and refresh token pair. Reusing an already exchanged refresh token revokes every token issued from the same login. Browser clients may omit the body and send the refresh cookie with the X-CSRF-Token header instead
+// @Tags authentication
+// @Accept json
+// @Produce json
+// @Param token body handler.AuthHandler.RefreshToken.true.req true "Refresh token"
+// @Success 200 {object} service.TokenPair "New token pair"
+// @Failure 400 {object} map[string]string "Invalid request format"
+// @Failure 401 {object} map[string]string "Invalid, expired or reused refresh token"
+// @Failure 403 {object} map[string]string "Missing or invalid CSRF token"
+// @Failure 500 {object} map[string]string "Internal server error"
+// @Router /token/refresh [post]
+func (h *AuthHandler) RefreshToken(c *gin.Context) {
+	var req struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	useCookies := false
+	if req.RefreshToken == "" {
+		refreshCookie, err := c.Cookie(utility.RefreshTokenCookie)
+		if err != nil || refreshCookie == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "missing refresh token"})
+			return
+		}
+		csrfCookie, _ := c.Cookie(utility.CSRFCookie)
+		if !utility.ValidCSRFToken(c.GetHeader(utility.CSRFHeader), csrfCookie) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "missing or invalid CSRF token"})
+			return
+		}
+		req.RefreshToken = refreshCookie
+		useCookies = true
+	}
+
+	tokens, err := h.tokenService.Refresh(c.Request.Context(), req.RefreshToken, sessionMeta(c))
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrInvalidRefreshToken), errors.Is(err, service.ErrRefreshTokenReused):
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to refresh token"})
+		}
+		return
+	}
+
+	if useCookies {
+		utility.SetSessionCookies(c, h.cookies, tokens.AccessToken, tokens.RefreshToken, tokens.CSRFToken,
+			time.Duration(tokens.ExpiresIn)*time.Second, time.Duration(tokens.RefreshExpiresIn)*time.Second)
+		c.JSON(http.StatusOK, gin.H{
+			"csrf_token": tokens.CSRFToken,
+			"expires_in": tokens.ExpiresIn,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, tokens)
+}
+
+// VerifyEmail godoc
+// @Summary Verify email address
+// @Description Confirm an email address with the single use token from the verification email
+// @Tags authentication
+// @Produce json
+// @Param token query string true "Verification token"
+// @Success 200 {object} map[string]string "Email verified"
+// @Failure 400 {object} map[string]string "Invalid, expired or already used token"
+// @Failure 500 {object} map[string]string "Internal server error"
+// @Router /verify-email [get]
+func (h *AuthHandler) VerifyEmail(c *gin.Context) {
+	token := c.Query("token")
+	if token == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "missing token"})
+		return
+	}
+
+	err := h.verificationService.VerifyEmail(c.Request.Context(), token)
+	if err != nil {
+		if errors.Is(err, utility.ErrInvalidActionToken) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to verify email"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "email verified successfully"})
+}
+
+// ResendVerification godoc
+// @Summary Resend verification email
+// @Description Send a new verification link to the current user's email address
+// @Tags authentication
+// @Security BearerAuth
+// @Produce json
+// @Success 200 {object} map[string]string "Verification email sent"
+// @Failure 400 {object} map[string]string "Email already verified"
+// @Failure 429 {object} map[string]string "Sent too recently"
+// @Failure 500 {object} map[string]string "Internal server error"
+// @Router /verify-email/resend [post]
+func (h *AuthHandler) ResendVerification(c *gin.Context) {
+	principal, ok := utility.CurrentPrincipal(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user passed from context"})
+		return
+	}
+
+	err := h.verificationService.ResendVerification(c.Request.Context(), principal)
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrEmailAlreadyVerified):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case errors.Is(err, service.ErrResendThrottled):
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to send verification email"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "verification email sent"})
+}
+
 // SignOut godoc
 // @Summary Logout user
-// @Description Invalidate user's JWT token
+// @Description End the current session. Its access and refresh tokens stop working
 // @Tags authentication
 // @Security BearerAuth
 // @Produce json
@@ -119,18 +295,18 @@ func (h *AuthHandler) Login(c *gin.Context) {
 // @Router /signout [post]
 func (h *AuthHandler) SignOut(c *gin.Context) {
 
-	tokenString := c.GetHeader("Authorization")
-	if tokenString == "" {
+	principal, ok := utility.CurrentPrincipal(c)
+	if !ok || principal.SessionID == "" {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "No authorization token provided"})
 		return
 	}
 
-
-	err := h.authService.InvalidateToken(c.Request.Context(), tokenString)
+	err := h.tokenService.RevokeSession(c.Request.Context(), principal, principal.SessionID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to invalidate token"})
 		return
 	}
+	utility.ClearSessionCookies(c, h.cookies)
 
 	c.JSON(http.StatusOK, gin.H{"message": "Successfully signed out"})
 }
@@ -0,0 +1,219 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"redditBack/service"
+	"redditBack/utility"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type CommentHandler struct {
+	commentService service.CommentService
+}
+
+func NewCommentHandler(commentService service.CommentService) CommentHandler {
+	return CommentHandler{commentService: commentService}
+}
+
+// writeCommentError maps the errors shared by the comment endpoints.
+func writeCommentError(c *gin.Context, err error, fallback string) {
+	switch {
+	case errors.Is(err, service.ErrEmailNotVerified), errors.Is(err, service.ErrForbidden):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrInvalidParentComment), errors.Is(err, service.ErrCommentTooDeep):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrParentCommentRemoved):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case err.Error() == "post not found", err.Error() == "comment not found":
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fallback})
+	}
+}
+
+// ListComments godoc
+// @Summary Get the comments of a post
+// @Description Get the comment tree of a post. Replies past the depth limit are left out and counted in more_replies; load them from /comments/{id}. When next is set, pass it as after to load the rest of a long thread
+// @Tags comments
+// @Security BearerAuth
+// @Produce json
+// @Param id path int true "Post ID"
+// @Param after query string false "Continuation from a previous page"
+// @Success 200 {object} service.CommentThread
+// @Failure 400 {object} map[string]string "Invalid post ID"
+// @Failure 404 {object} map[string]string "Post not found"
+// @Failure 500 {object} map[string]string "Internal server error"
+// @Router /posts/{id}/comments [get]
+func (h *CommentHandler) ListComments(c *gin.Context) {
+	postID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid post ID"})
+		return
+	}
+
+	thread, err := h.commentService.GetPostComments(c.Request.Context(), uint(postID), c.Query("after"))
+	if err != nil {
+		writeCommentError(c, err, "failed to load comments")
+		return
+	}
+
+	c.JSON(http.StatusOK, thread)
+}
+
+// GetComment godoc
+// @Summary Get a comment and its replies
+// @Description Get a comment with the replies below it, to load more of a thread past the depth limit
+// @Tags comments
+// @Security BearerAuth
+// @Produce json
+// @Param id path int true "Comment ID"
+// @Param after query string false "Continuation from a previous page"
+// @Success 200 {object} service.CommentThread
+// @Failure 400 {object} map[string]string "Invalid comment ID"
+// @Failure 404 {object} map[string]string "Comment not found"
+// @Failure 500 {object} map[string]string "Internal server error"
+// @Router /comments/{id} [get]
+func (h *CommentHandler) GetComment(c *gin.Context) {
+	commentID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid comment ID"})
+		return
+	}
+
+	thread, err := h.commentService.GetCommentReplies(c.Request.Context(), uint(commentID), c.Query("after"))
+	if err != nil {
+		writeCommentError(c, err, "failed to load comments")
+		return
+	}
+
+	c.JSON(http.StatusOK, thread)
+}
+
+// CreateComment godoc
+// @Summary Comment on a post
+// @Description Add a comment to a post, or a reply to another comment of the post when parent_id is set
+// @Tags comments
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param id path int true "Post ID"
+// @Param comment body handler.CommentHandler.CreateComment.true.req true "Comment data"
+// @Success 201 {object} model.Comment
+// @Failure 400 {object} map[string]string "Invalid request format, parent or depth"
+// @Failure 403 {object} map[string]string "Email address not verified"
+// @Failure 404 {object} map[string]string "Post not found"
+// @Failure 409 {object} map[string]string "Parent comment was removed"
+// @Failure 500 {object} map[string]string "Internal server error"
+// @Router /posts/{id}/comments [post]
+func (h *CommentHandler) CreateComment(c *gin.Context) {
+	principal, ok := utility.CurrentPrincipal(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user passed from context"})
+		return
+	}
+
+	postID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid post ID"})
+		return
+	}
+
+	var req struct {
+		Content  string `json:"content" binding:"required,max=10000"`
+		ParentID *uint  `json:"parent_id"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	comment, err := h.commentService.CreateComment(c.Request.Context(), principal, uint(postID), req.ParentID, req.Content)
+	if err != nil {
+		writeCommentError(c, err, "failed to create comment")
+		return
+	}
+
+	c.JSON(http.StatusCreated, comment)
+}
+
+// EditComment godoc
+// @Summary Edit a comment
+// @Description Change the content of one of the current user's comments
+// @Tags comments
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param id path int true "Comment ID"
+// @Param comment body handler.CommentHandler.EditComment.true.req true "New content"
+// @Success 200 {object} model.Comment
+// @Failure 400 {object} map[string]string "Invalid request format"
+// @Failure 403 {object} map[string]string "Not allowed to edit this comment"
+// @Failure 404 {object} map[string]string "Comment not found"
+// @Failure 500 {object} map[string]string "Internal server error"
+// @Router /comments/{id} [put]
+func (h *CommentHandler) EditComment(c *gin.Context) {
+	principal, ok := utility.CurrentPrincipal(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user passed from context"})
+		return
+	}
+
+	commentID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid comment ID"})
+		return
+	}
+
+	var req struct {
+		Content string `json:"content" binding:"required,max=10000"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	comment, err := h.commentService.EditComment(c.Request.Context(), principal, uint(commentID), req.Content)
+	if err != nil {
+		writeCommentError(c, err, "failed to update comment")
+		return
+	}
+
+	c.JSON(http.StatusOK, comment)
+}
+
+// DeleteComment godoc
+// @Summary Delete a comment
+// @Description Delete a comment. Comments with replies are blanked out so the replies stay in place. Moderators and administrators may delete any comment
+// @Tags comments
+// @Security BearerAuth
+// @Produce json
+// @Param id path int true "Comment ID"
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} map[string]string "Invalid comment ID"
+// @Failure 403 {object} map[string]string "Not allowed to delete this comment"
+// @Failure 404 {object} map[string]string "Comment not found"
+// @Failure 500 {object} map[string]string "Internal server error"
+// @Router /comments/{id} [delete]
+func (h *CommentHandler) DeleteComment(c *gin.Context) {
+	principal, ok := utility.CurrentPrincipal(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user passed from context"})
+		return
+	}
+
+	commentID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid comment ID"})
+		return
+	}
+
+	if err := h.commentService.DeleteComment(c.Request.Context(), principal, uint(commentID)); err != nil {
+		writeCommentError(c, err, "failed to delete comment")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "comment deleted successfully"})
+}
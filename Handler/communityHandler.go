@@ -0,0 +1,236 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"redditBack/model"
+	"redditBack/service"
+	"redditBack/utility"
+
+	"github.com/gin-gonic/gin"
+)
+
+type CommunityHandler struct {
+	communityService service.CommunityService
+}
+
+func NewCommunityHandler(communityService service.CommunityService) CommunityHandler {
+	return CommunityHandler{communityService: communityService}
+}
+
+// writeCommunityError maps the errors shared by the community endpoints.
+func writeCommunityError(c *gin.Context, err error, fallback string) {
+	switch {
+	case errors.Is(err, service.ErrCommunityNotFound), errors.Is(err, service.ErrSubscriptionNotFound),
+		errors.Is(err, service.ErrCommunityUserNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrEmailNotVerified), errors.Is(err, service.ErrForbidden):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrCommunityExists):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrInvalidCommunityName), errors.Is(err, service.ErrInvalidVisibility),
+		errors.Is(err, service.ErrInvalidRequirement):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fallback})
+	}
+}
+
+// CreateCommunity godoc
+// @Summary Create a community
+// @Description Create a community with the current user as its creator and first member. Restricted communities only take posts from approved members
+// @Tags communities
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param community body handler.CommunityHandler.CreateCommunity.true.req true "Community data"
+// @Success 201 {object} model.Community
+// @Failure 400 {object} map[string]string "Invalid request format"
+// @Failure 403 {object} map[string]string "Email address not verified"
+// @Failure 409 {object} map[string]string "Community name taken"
+// @Failure 500 {object} map[string]string "Internal server error"
+// @Router /communities [post]
+func (h *CommunityHandler) CreateCommunity(c *gin.Context) {
+	principal, ok := utility.CurrentPrincipal(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user passed from context"})
+		return
+	}
+
+	var req struct {
+		Name              string `json:"name" binding:"required"`
+		Description       string `json:"description" binding:"max=10000"`
+		Rules             string `json:"rules" binding:"max=10000"`
+		Visibility        string `json:"visibility"`
+		MinAccountAgeDays int    `json:"min_account_age_days"`
+		MinKarma          int    `json:"min_karma"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	community := &model.Community{
+		Name:              req.Name,
+		Description:       req.Description,
+		Rules:             req.Rules,
+		Visibility:        req.Visibility,
+		MinAccountAgeDays: req.MinAccountAgeDays,
+		MinKarma:          req.MinKarma,
+	}
+	if err := h.communityService.CreateCommunity(c.Request.Context(), principal, community); err != nil {
+		writeCommunityError(c, err, "failed to create community")
+		return
+	}
+
+	c.JSON(http.StatusCreated, community)
+}
+
+// GetCommunity godoc
+// @Summary Get a community
+// @Description Get a community's description, rules and posting requirements
+// @Tags communities
+// @Security BearerAuth
+// @Produce json
+// @Param name path string true "Community name"
+// @Success 200 {object} model.Community
+// @Failure 404 {object} map[string]string "Community not found"
+// @Failure 500 {object} map[string]string "Internal server error"
+// @Router /c/{name} [get]
+func (h *CommunityHandler) GetCommunity(c *gin.Context) {
+	community, err := h.communityService.GetCommunity(c.Request.Context(), c.Param("name"))
+	if err != nil {
+		writeCommunityError(c, err, "failed to get community")
+		return
+	}
+
+	c.JSON(http.StatusOK, community)
+}
+
+// UpdateCommunity godoc
+// @Summary Update a community
+// @Description Change a community's description, rules, visibility or posting requirements. Only the creator and administrators may do so
+// @Tags communities
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param name path string true "Community name"
+// @Param community body handler.CommunityHandler.UpdateCommunity.true.req true "Fields to change"
+// @Success 200 {object} model.Community
+// @Failure 400 {object} map[string]string "Invalid request format"
+// @Failure 403 {object} map[string]string "Not allowed to manage this community"
+// @Failure 404 {object} map[string]string "Community not found"
+// @Failure 500 {object} map[string]string "Internal server error"
+// @Router /c/{name} [patch]
+func (h *CommunityHandler) UpdateCommunity(c *gin.Context) {
+	principal, ok := utility.CurrentPrincipal(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user passed from context"})
+		return
+	}
+
+	var req struct {
+		Description       *string `json:"description" binding:"omitempty,max=10000"`
+		Rules             *string `json:"rules" binding:"omitempty,max=10000"`
+		Visibility        *string `json:"visibility"`
+		MinAccountAgeDays *int    `json:"min_account_age_days"`
+		MinKarma          *int    `json:"min_karma"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	community, err := h.communityService.UpdateCommunity(c.Request.Context(), principal, c.Param("name"), service.CommunityUpdate{
+		Description:       req.Description,
+		Rules:             req.Rules,
+		Visibility:        req.Visibility,
+		MinAccountAgeDays: req.MinAccountAgeDays,
+		MinKarma:          req.MinKarma,
+	})
+	if err != nil {
+		writeCommunityError(c, err, "failed to update community")
+		return
+	}
+
+	c.JSON(http.StatusOK, community)
+}
+
+// Subscribe godoc
+// @Summary Subscribe to a community
+// @Tags communities
+// @Security BearerAuth
+// @Produce json
+// @Param name path string true "Community name"
+// @Success 200 {object} map[string]string
+// @Failure 404 {object} map[string]string "Community not found"
+// @Failure 500 {object} map[string]string "Internal server error"
+// @Router /c/{name}/subscription [post]
+func (h *CommunityHandler) Subscribe(c *gin.Context) {
+	principal, ok := utility.CurrentPrincipal(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user passed from context"})
+		return
+	}
+
+	if err := h.communityService.Subscribe(c.Request.Context(), principal, c.Param("name")); err != nil {
+		writeCommunityError(c, err, "failed to subscribe")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "subscribed"})
+}
+
+// Unsubscribe godoc
+// @Summary Unsubscribe from a community
+// @Tags communities
+// @Security BearerAuth
+// @Produce json
+// @Param name path string true "Community name"
+// @Success 200 {object} map[string]string
+// @Failure 404 {object} map[string]string "Community not found or not subscribed"
+// @Failure 500 {object} map[string]string "Internal server error"
+// @Router /c/{name}/subscription [delete]
+func (h *CommunityHandler) Unsubscribe(c *gin.Context) {
+	principal, ok := utility.CurrentPrincipal(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user passed from context"})
+		return
+	}
+
+	if err := h.communityService.Unsubscribe(c.Request.Context(), principal, c.Param("name")); err != nil {
+		writeCommunityError(c, err, "failed to unsubscribe")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "unsubscribed"})
+}
+
+// ApproveMember godoc
+// @Summary Approve a community member
+// @Description Let a user post in a restricted community, subscribing them if needed. Only the creator and administrators may approve members
+// @Tags communities
+// @Security BearerAuth
+// @Produce json
+// @Param name path string true "Community name"
+// @Param username path string true "Username to approve"
+// @Success 200 {object} map[string]string
+// @Failure 403 {object} map[string]string "Not allowed to manage this community"
+// @Failure 404 {object} map[string]string "Community or user not found"
+// @Failure 500 {object} map[string]string "Internal server error"
+// @Router /c/{name}/members/{username} [put]
+func (h *CommunityHandler) ApproveMember(c *gin.Context) {
+	principal, ok := utility.CurrentPrincipal(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user passed from context"})
+		return
+	}
+
+	err := h.communityService.ApproveMember(c.Request.Context(), principal, c.Param("name"), c.Param("username"))
+	if err != nil {
+		writeCommunityError(c, err, "failed to approve member")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "member approved"})
+}
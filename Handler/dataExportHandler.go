@@ -0,0 +1,108 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"redditBack/service"
+	"redditBack/utility"
+
+	"github.com/gin-gonic/gin"
+)
+
+type DataExportHandler struct {
+	dataExportService service.DataExportService
+}
+
+func NewDataExportHandler(dataExportService service.DataExportService) DataExportHandler {
+	return DataExportHandler{dataExportService: dataExportService}
+}
+
+// RequestExport godoc
+// @Summary Request a data export
+// @Description Start building an archive of the current user's profile, posts and votes. Poll the returned export for its download link
+// @Tags account
+// @Security BearerAuth
+// @Produce json
+// @Success 202 {object} model.DataExport
+// @Failure 429 {object} map[string]string "Requested too recently"
+// @Failure 500 {object} map[string]string "Internal server error"
+// @Router /me/exports [post]
+func (h *DataExportHandler) RequestExport(c *gin.Context) {
+	principal, ok := utility.CurrentPrincipal(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user passed from context"})
+		return
+	}
+
+	export, err := h.dataExportService.RequestExport(c.Request.Context(), principal)
+	if err != nil {
+		if errors.Is(err, service.ErrExportThrottled) {
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to start export"})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, export)
+}
+
+// GetExport godoc
+// @Summary Get data export status
+// @Description Poll a data export. Once it is ready the response carries an expiring download link
+// @Tags account
+// @Security BearerAuth
+// @Produce json
+// @Param id path string true "Export ID"
+// @Success 200 {object} service.DataExportStatus
+// @Failure 404 {object} map[string]string "Export not found or expired"
+// @Failure 500 {object} map[string]string "Internal server error"
+// @Router /me/exports/{id} [get]
+func (h *DataExportHandler) GetExport(c *gin.Context) {
+	principal, ok := utility.CurrentPrincipal(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user passed from context"})
+		return
+	}
+
+	status, err := h.dataExportService.Status(c.Request.Context(), principal, c.Param("id"))
+	if err != nil {
+		if errors.Is(err, service.ErrExportNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load export"})
+		return
+	}
+
+	c.JSON(http.StatusOK, status)
+}
+
+// DownloadExport godoc
+// @Summary Download a data export
+// @Description Download a finished export archive with the signed link from the export status
+// @Tags account
+// @Produce application/zip
+// @Param id path string true "Export ID"
+// @Param token query string true "Download token"
+// @Success 200 {file} file "ZIP archive"
+// @Failure 403 {object} map[string]string "Invalid or expired link"
+// @Failure 404 {object} map[string]string "Export not found or expired"
+// @Failure 500 {object} map[string]string "Internal server error"
+// @Router /exports/{id}/download [get]
+func (h *DataExportHandler) DownloadExport(c *gin.Context) {
+	export, err := h.dataExportService.OpenDownload(c.Request.Context(), c.Param("id"), c.Query("token"))
+	if err != nil {
+		switch {
+		case errors.Is(err, utility.ErrInvalidActionToken):
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		case errors.Is(err, service.ErrExportNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load export"})
+		}
+		return
+	}
+
+	c.FileAttachment(export.FilePath, "data-export.zip")
+}
@@ -0,0 +1,135 @@
+package handler
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"redditBack/service"
+	"redditBack/utility"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type InviteHandler struct {
+	inviteService service.InviteService
+}
+
+func NewInviteHandler(inviteService service.InviteService) InviteHandler {
+	return InviteHandler{inviteService: inviteService}
+}
+
+// CreateInvite godoc
+// @Summary Create an invite code
+// @Description Create an invite code for signing up. Users get single use codes up to their quota; max_uses and expires_in_days are only honoured for administrators
+// @Tags invites
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param invite body handler.InviteHandler.CreateInvite.true.req false "Uses and lifetime, administrators only"
+// @Success 201 {object} model.InviteCode
+// @Failure 400 {object} map[string]string "Invalid request format"
+// @Failure 403 {object} map[string]string "Invite quota exceeded"
+// @Failure 500 {object} map[string]string "Internal server error"
+// @Router /invites [post]
+func (h *InviteHandler) CreateInvite(c *gin.Context) {
+	var req struct {
+		MaxUses       int `json:"max_uses" binding:"min=0"`
+		ExpiresInDays int `json:"expires_in_days" binding:"min=0"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	principal, ok := utility.CurrentPrincipal(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user passed from context"})
+		return
+	}
+
+	ttl := time.Duration(req.ExpiresInDays) * 24 * time.Hour
+	invite, err := h.inviteService.Create(c.Request.Context(), principal, req.MaxUses, ttl)
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrInviteQuotaExceeded):
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		case errors.Is(err, service.ErrInvalidInviteUses):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create invite"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusCreated, invite)
+}
+
+// ListInvites godoc
+// @Summary List invite codes
+// @Description List the invite codes created by the current user with how often they were used
+// @Tags invites
+// @Security BearerAuth
+// @Produce json
+// @Success 200 {array} model.InviteCode
+// @Failure 500 {object} map[string]string "Internal server error"
+// @Router /invites [get]
+func (h *InviteHandler) ListInvites(c *gin.Context) {
+	principal, ok := utility.CurrentPrincipal(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user passed from context"})
+		return
+	}
+
+	invites, err := h.inviteService.List(c.Request.Context(), principal)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list invites"})
+		return
+	}
+
+	c.JSON(http.StatusOK, invites)
+}
+
+// RevokeInvite godoc
+// @Summary Revoke an invite code
+// @Description Revoke one of the current user's invite codes. Administrators may revoke any code
+// @Tags invites
+// @Security BearerAuth
+// @Produce json
+// @Param id path int true "Invite ID"
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} map[string]string "Invalid invite ID"
+// @Failure 403 {object} map[string]string "Not allowed to revoke this invite"
+// @Failure 404 {object} map[string]string "Invite not found"
+// @Failure 500 {object} map[string]string "Internal server error"
+// @Router /invites/{id} [delete]
+func (h *InviteHandler) RevokeInvite(c *gin.Context) {
+	principal, ok := utility.CurrentPrincipal(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user passed from context"})
+		return
+	}
+
+	inviteID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid invite ID"})
+		return
+	}
+
+	err = h.inviteService.Revoke(c.Request.Context(), principal, uint(inviteID))
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrForbidden):
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		case err.Error() == "invite not found":
+			c.JSON(http.StatusNotFound, gin.H{"error": "invite not found"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to revoke invite"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "invite revoked"})
+}
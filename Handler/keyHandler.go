@@ -0,0 +1,28 @@
+package handler
+
+import (
+	"net/http"
+	"redditBack/utility"
+
+	"github.com/gin-gonic/gin"
+)
+
+type KeyHandler struct {
+	keys *utility.KeyRing
+}
+
+func NewKeyHandler(keys *utility.KeyRing) KeyHandler {
+	return KeyHandler{keys: keys}
+}
+
+// JWKS godoc
+// @Summary Public signing keys
+// @Description JSON Web Key Set with the public keys that verify tokens issued by this API. Empty when tokens are signed with a shared HMAC secret
+// @Tags authentication
+// @Produce json
+// @Success 200 {object} map[string]interface{}
+// @Router /.well-known/jwks.json [get]
+func (h *KeyHandler) JWKS(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, gin.H{"keys": h.keys.JWKS()})
+}
@@ -0,0 +1,158 @@
+package handler
+
+import (
+	"errors"
+	"html/template"
+	"log"
+	"net/http"
+	"redditBack/service"
+	"redditBack/utility"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/render"
+)
+
+// confirmLoginPage is what the emailed login link opens when no front-end
+// page is configured. Mail scanners and link previews fetch links but do not
+// submit forms, so they cannot use up the token.
+var confirmLoginPage = template.Must(template.New("confirm").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><meta name="referrer" content="no-referrer"><title>Log in</title></head>
+<body>
+<form method="post" action="/login/magic/verify">
+<input type="hidden" name="token" value="{{.}}">
+<input type="hidden" name="use_cookies" value="true">
+<button type="submit">Log in</button>
+</form>
+</body>
+</html>
+`))
+
+type MagicLinkHandler struct {
+	magicLinkService service.MagicLinkService
+	authService      service.AuthService
+	tokenService     service.TokenService
+	cookies          utility.SessionCookieConfig
+}
+
+func NewMagicLinkHandler(magicLinkService service.MagicLinkService, authService service.AuthService,
+	tokenService service.TokenService, cookies utility.SessionCookieConfig) MagicLinkHandler {
+	return MagicLinkHandler{
+		magicLinkService: magicLinkService,
+		authService:      authService,
+		tokenService:     tokenService,
+		cookies:          cookies,
+	}
+}
+
+// RequestLink godoc
+// @Summary Request a login link
+// @Description Email a single use, short lived login link. The response is the same whether or not the address is registered
+// @Tags authentication
+// @Accept json
+// @Produce json
+// @Param request body handler.MagicLinkHandler.RequestLink.true.req true "Account email"
+// @Success 200 {object} map[string]string "Link requested"
+// @Failure 400 {object} map[string]string "Invalid request format"
+// @Failure 404 {object} map[string]string "Magic link login is disabled"
+// @Router /login/magic [post]
+func (h *MagicLinkHandler) RequestLink(c *gin.Context) {
+	var req struct {
+		Email string `json:"email" binding:"required,email"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err := h.magicLinkService.RequestLink(c.Request.Context(), req.Email)
+	if errors.Is(err, service.ErrMagicLinkDisabled) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		log.Printf("failed to process magic link request: %v", err)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "if the address is registered, a login link has been sent"})
+}
+
+// ConfirmLink godoc
+// @Summary Login link confirm page
+// @Description Page opened by the login link when no front-end page is configured. Submitting it posts to /login/magic/verify and signs in with session cookies
+// @Tags authentication
+// @Produce html
+// @Param token query string true "Token from the login link"
+// @Success 200 {string} string "Confirm page"
+// @Failure 400 {object} map[string]string "Missing token"
+// @Router /login/magic/verify [get]
+func (h *MagicLinkHandler) ConfirmLink(c *gin.Context) {
+	token := c.Query("token")
+	if token == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "missing token"})
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.Render(http.StatusOK, render.HTML{Template: confirmLoginPage, Data: token})
+}
+
+// ExchangeLink godoc
+// @Summary Log in with a login link
+// @Description Exchange the token from a login link for a token pair, or a two-factor challenge for users with two-factor authentication. Also accepts the form fields of the confirm page
+// @Tags authentication
+// @Accept json,x-www-form-urlencoded
+// @Produce json
+// @Param request body handler.MagicLinkHandler.ExchangeLink.true.req true "Token from the login link"
+// @Success 200 {object} map[string]interface{} "Successfully logged in"
+// @Failure 400 {object} map[string]string "Invalid, expired or already used token"
+// @Failure 404 {object} map[string]string "Magic link login is disabled"
+// @Failure 500 {object} map[string]string "Internal server error"
+// @Router /login/magic/verify [post]
+func (h *MagicLinkHandler) ExchangeLink(c *gin.Context) {
+	var req struct {
+		Token      string `json:"token" form:"token" binding:"required"`
+		UseCookies bool   `json:"use_cookies" form:"use_cookies"`
+	}
+
+	if err := c.ShouldBind(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err := h.magicLinkService.ExchangeLink(c.Request.Context(), req.Token)
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrMagicLinkDisabled):
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		case errors.Is(err, utility.ErrInvalidActionToken):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to complete login"})
+		}
+		return
+	}
+
+	result, err := h.authService.CompleteFirstFactor(c.Request.Context(), user)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to complete login"})
+		return
+	}
+	if result.MFARequired {
+		c.JSON(http.StatusOK, gin.H{
+			"mfa_required": true,
+			"mfa_token":    result.MFAChallenge,
+			"mfa_methods":  result.MFAMethods,
+		})
+		return
+	}
+
+	tokens, err := h.tokenService.IssueTokens(c.Request.Context(), user, sessionMeta(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate token"})
+		return
+	}
+
+	writeTokens(c, h.cookies, http.StatusOK, tokens, user, req.UseCookies)
+}
@@ -0,0 +1,218 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"redditBack/service"
+	"redditBack/utility"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type MFAHandler struct {
+	mfaService   service.MFAService
+	tokenService service.TokenService
+	cookies      utility.SessionCookieConfig
+}
+
+func NewMFAHandler(mfaService service.MFAService, tokenService service.TokenService, cookies utility.SessionCookieConfig) MFAHandler {
+	return MFAHandler{mfaService: mfaService, tokenService: tokenService, cookies: cookies}
+}
+
+// EnrollTOTP godoc
+// @Summary Start TOTP enrolment
+// @Description Generate a TOTP secret for the current user. It becomes active once confirmed with a code
+// @Tags mfa
+// @Security BearerAuth
+// @Produce json
+// @Success 200 {object} map[string]string "Secret and otpauth URI"
+// @Failure 409 {object} map[string]string "Already enabled"
+// @Failure 500 {object} map[string]string "Internal server error"
+// @Router /mfa/totp/enroll [post]
+func (h *MFAHandler) EnrollTOTP(c *gin.Context) {
+	principal, ok := utility.CurrentPrincipal(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user passed from context"})
+		return
+	}
+
+	secret, uri, err := h.mfaService.BeginTOTPEnrollment(c.Request.Context(), principal)
+	if err != nil {
+		if errors.Is(err, service.ErrMFAAlreadyEnabled) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to start enrolment"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"secret":      secret,
+		"otpauth_uri": uri,
+	})
+}
+
+// ConfirmTOTP godoc
+// @Summary Confirm TOTP enrolment
+// @Description Enable TOTP with a code from the authenticator app and receive one-time recovery codes
+// @Tags mfa
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param request body handler.MFAHandler.ConfirmTOTP.true.req true "Code from the authenticator"
+// @Success 200 {object} map[string]interface{} "Recovery codes"
+// @Failure 400 {object} map[string]string "Invalid code or no enrolment started"
+// @Failure 409 {object} map[string]string "Already enabled"
+// @Failure 500 {object} map[string]string "Internal server error"
+// @Router /mfa/totp/confirm [post]
+func (h *MFAHandler) ConfirmTOTP(c *gin.Context) {
+	principal, ok := utility.CurrentPrincipal(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user passed from context"})
+		return
+	}
+	var req struct {
+		Code string `json:"code" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	codes, err := h.mfaService.ConfirmTOTPEnrollment(c.Request.Context(), principal, req.Code)
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrInvalidMFACode), errors.Is(err, service.ErrMFANotEnabled):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case errors.Is(err, service.ErrMFAAlreadyEnabled):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to confirm enrolment"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":        "two-factor authentication enabled",
+		"recovery_codes": codes,
+	})
+}
+
+// DisableTOTP godoc
+// @Summary Disable TOTP
+// @Description Turn two-factor authentication off with a TOTP or recovery code
+// @Tags mfa
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param request body handler.MFAHandler.DisableTOTP.true.req true "TOTP or recovery code"
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} map[string]string "Invalid code or not enabled"
+// @Failure 500 {object} map[string]string "Internal server error"
+// @Router /mfa/totp [delete]
+func (h *MFAHandler) DisableTOTP(c *gin.Context) {
+	principal, ok := utility.CurrentPrincipal(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user passed from context"})
+		return
+	}
+	var req struct {
+		Code string `json:"code" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err := h.mfaService.DisableTOTP(c.Request.Context(), principal, req.Code)
+	if err != nil {
+		if errors.Is(err, service.ErrInvalidMFACode) || errors.Is(err, service.ErrMFANotEnabled) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to disable two-factor authentication"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "two-factor authentication disabled"})
+}
+
+// CompleteLogin godoc
+// @Summary Complete two-factor login
+// @Description Exchange the mfa_token from /login and a TOTP or recovery code for a token pair
+// @Tags authentication
+// @Accept json
+// @Produce json
+// @Param request body handler.MFAHandler.CompleteLogin.true.req true "Challenge and code"
+// @Success 200 {object} map[string]interface{} "Successfully logged in"
+// @Failure 400 {object} map[string]string "Invalid request format"
+// @Failure 401 {object} map[string]string "Invalid code or challenge"
+// @Failure 423 {object} map[string]string "Account temporarily locked"
+// @Failure 429 {object} map[string]string "Too many failed attempts"
+// @Failure 500 {object} map[string]string "Internal server error"
+// @Router /login/mfa [post]
+func (h *MFAHandler) CompleteLogin(c *gin.Context) {
+	var req struct {
+		MFAToken   string `json:"mfa_token" binding:"required"`
+		Code       string `json:"code" binding:"required"`
+		UseCookies bool   `json:"use_cookies"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err := h.mfaService.CompleteLogin(c.Request.Context(), req.MFAToken, req.Code, c.ClientIP())
+	if err != nil {
+		if writeLoginBlocked(c, err) {
+			return
+		}
+		if errors.Is(err, service.ErrInvalidMFACode) || errors.Is(err, service.ErrInvalidMFAChallenge) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to complete login"})
+		return
+	}
+
+	tokens, err := h.tokenService.IssueTokens(c.Request.Context(), user, sessionMeta(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate token"})
+		return
+	}
+
+	writeTokens(c, h.cookies, http.StatusOK, tokens, user, req.UseCookies)
+}
+
+// ResetTOTP godoc
+// @Summary Reset a user's two-factor authentication
+// @Description Administrative reset for users locked out of their second factor. Signs the user out everywhere
+// @Tags admin
+// @Security BearerAuth
+// @Produce json
+// @Param id path int true "User ID"
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} map[string]string "Invalid user ID"
+// @Failure 403 {object} map[string]string "Not an administrator"
+// @Failure 404 {object} map[string]string "User not found"
+// @Failure 500 {object} map[string]string "Internal server error"
+// @Router /admin/users/{id}/mfa/reset [post]
+func (h *MFAHandler) ResetTOTP(c *gin.Context) {
+	userID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user ID"})
+		return
+	}
+
+	if err := h.mfaService.ResetTOTP(c.Request.Context(), uint(userID)); err != nil {
+		if errors.Is(err, service.ErrUserNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to reset two-factor authentication"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "two-factor authentication reset"})
+}
@@ -0,0 +1,157 @@
+package handler
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"redditBack/service"
+	"redditBack/utility"
+
+	"github.com/gin-gonic/gin"
+)
+
+type OIDCHandler struct {
+	oidcService  service.OIDCService
+	authService  service.AuthService
+	tokenService service.TokenService
+	cookies      utility.SessionCookieConfig
+}
+
+func NewOIDCHandler(oidcService service.OIDCService, authService service.AuthService, tokenService service.TokenService,
+	cookies utility.SessionCookieConfig) OIDCHandler {
+	return OIDCHandler{oidcService: oidcService, authService: authService, tokenService: tokenService, cookies: cookies}
+}
+
+// Login godoc
+// @Summary Sign in with an external provider
+// @Description Redirect to the OpenID Connect provider to start an authorization code flow with PKCE
+// @Tags authentication
+// @Param provider path string true "Configured provider name"
+// @Param use_cookies query bool false "Finish the login with session cookies"
+// @Success 302 "Redirect to the provider"
+// @Failure 404 {object} map[string]string "Unknown provider"
+// @Failure 502 {object} map[string]string "Provider unavailable"
+// @Router /oidc/{provider}/login [get]
+func (h *OIDCHandler) Login(c *gin.Context) {
+	useCookies := c.Query("use_cookies") == "true"
+	redirect, err := h.oidcService.StartLogin(c.Request.Context(), c.Param("provider"), useCookies)
+	if err != nil {
+		if errors.Is(err, service.ErrUnknownProvider) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		log.Printf("failed to start oidc login: %v", err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "identity provider unavailable"})
+		return
+	}
+
+	utility.SetOIDCBindingCookie(c, h.cookies, redirect.Binding)
+	c.Redirect(http.StatusFound, redirect.URL)
+}
+
+// Connect godoc
+// @Summary Connect an external provider
+// @Description Start an OpenID Connect flow that links the provider account to the current user. Returns the provider URL to open in the same browser, which also gets the cookie the callback checks; the flow ends at the callback like a login
+// @Tags authentication
+// @Security BearerAuth
+// @Produce json
+// @Param provider path string true "Configured provider name"
+// @Param use_cookies query bool false "Finish the flow with session cookies"
+// @Success 200 {object} map[string]string "Provider URL"
+// @Failure 401 {object} map[string]string "Unauthorized"
+// @Failure 404 {object} map[string]string "Unknown provider"
+// @Failure 502 {object} map[string]string "Provider unavailable"
+// @Router /oidc/{provider}/connect [post]
+func (h *OIDCHandler) Connect(c *gin.Context) {
+	principal, ok := utility.CurrentPrincipal(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user passed from context"})
+		return
+	}
+
+	useCookies := c.Query("use_cookies") == "true"
+	redirect, err := h.oidcService.StartConnect(c.Request.Context(), principal, c.Param("provider"), useCookies)
+	if err != nil {
+		if errors.Is(err, service.ErrUnknownProvider) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		log.Printf("failed to start oidc connect: %v", err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "identity provider unavailable"})
+		return
+	}
+
+	utility.SetOIDCBindingCookie(c, h.cookies, redirect.Binding)
+	c.JSON(http.StatusOK, gin.H{"url": redirect.URL})
+}
+
+// Callback godoc
+// @Summary External provider callback
+// @Description Complete the OpenID Connect flow, creating the local account or connecting the provider to the signed in user, and return a token pair or a two-factor challenge
+// @Tags authentication
+// @Produce json
+// @Param provider path string true "Configured provider name"
+// @Param code query string true "Authorization code"
+// @Param state query string true "State from the login redirect"
+// @Success 200 {object} map[string]interface{} "Successfully logged in"
+// @Failure 400 {object} map[string]string "Invalid callback"
+// @Failure 401 {object} map[string]string "Provider login failed"
+// @Failure 403 {object} map[string]string "Registration is invite only"
+// @Failure 409 {object} map[string]string "Email or provider account belongs to another user"
+// @Failure 500 {object} map[string]string "Internal server error"
+// @Router /oidc/{provider}/callback [get]
+func (h *OIDCHandler) Callback(c *gin.Context) {
+	if providerErr := c.Query("error"); providerErr != "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": providerErr})
+		return
+	}
+	code, state := c.Query("code"), c.Query("state")
+	if code == "" || state == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "missing code or state"})
+		return
+	}
+
+	binding, _ := c.Cookie(utility.OIDCBindingCookie)
+	utility.ClearOIDCBindingCookie(c, h.cookies)
+	result, err := h.oidcService.HandleCallback(c.Request.Context(), c.Param("provider"), state, binding, code)
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrUnknownProvider):
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		case errors.Is(err, service.ErrInvalidOIDCState), errors.Is(err, service.ErrOIDCEmailMissing),
+			errors.Is(err, service.ErrReservedEmail):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case errors.Is(err, service.ErrOIDCEmailTaken), errors.Is(err, service.ErrOIDCIdentityTaken):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		case errors.Is(err, service.ErrInviteRequired):
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		default:
+			log.Printf("oidc callback failed: %v", err)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "login with identity provider failed"})
+		}
+		return
+	}
+
+	user := result.User
+	firstFactor, err := h.authService.CompleteFirstFactor(c.Request.Context(), user)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to complete login"})
+		return
+	}
+	if firstFactor.MFARequired {
+		c.JSON(http.StatusOK, gin.H{
+			"mfa_required": true,
+			"mfa_token":    firstFactor.MFAChallenge,
+			"mfa_methods":  firstFactor.MFAMethods,
+		})
+		return
+	}
+
+	tokens, err := h.tokenService.IssueTokens(c.Request.Context(), user, sessionMeta(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate token"})
+		return
+	}
+
+	writeTokens(c, h.cookies, http.StatusOK, tokens, user, result.UseCookies)
+}
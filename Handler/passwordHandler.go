@@ -0,0 +1,117 @@
+package handler
+
+import (
+	"errors"
+	"html/template"
+	"log"
+	"net/http"
+	"redditBack/service"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/render"
+)
+
+// resetPasswordPage is what the emailed reset link opens when no front-end
+// page is configured. It posts the token back to ResetPassword.
+var resetPasswordPage = template.Must(template.New("reset").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><meta name="referrer" content="no-referrer"><title>Reset your password</title></head>
+<body>
+<form method="post" action="/password/reset">
+<input type="hidden" name="token" value="{{.}}">
+<label>New password <input type="password" name="new_password" minlength="6" maxlength="72" required autocomplete="new-password"></label>
+<button type="submit">Change password</button>
+</form>
+</body>
+</html>
+`))
+
+type PasswordHandler struct {
+	resetService service.PasswordResetService
+}
+
+func NewPasswordHandler(resetService service.PasswordResetService) PasswordHandler {
+	return PasswordHandler{resetService: resetService}
+}
+
+// ForgotPassword godoc
+// @Summary Request a password reset
+// @Description Email a single use password reset link. The response is the same whether or not the address is registered
+// @Tags authentication
+// @Accept json
+// @Produce json
+// @Param request body handler.PasswordHandler.ForgotPassword.true.req true "Account email"
+// @Success 200 {object} map[string]string "Reset requested"
+// @Failure 400 {object} map[string]string "Invalid request format"
+// @Router /password/forgot [post]
+func (h *PasswordHandler) ForgotPassword(c *gin.Context) {
+	var req struct {
+		Email string `json:"email" binding:"required,email"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.resetService.RequestReset(c.Request.Context(), req.Email); err != nil {
+		log.Printf("failed to process password reset request: %v", err)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "if the address is registered, a reset link has been sent"})
+}
+
+// ResetPasswordForm godoc
+// @Summary Password reset form
+// @Description Page opened by the reset link when no front-end page is configured. Submitting it posts to /password/reset
+// @Tags authentication
+// @Produce html
+// @Param token query string true "Token from the reset email"
+// @Success 200 {string} string "Reset form"
+// @Failure 400 {object} map[string]string "Missing token"
+// @Router /password/reset [get]
+func (h *PasswordHandler) ResetPasswordForm(c *gin.Context) {
+	token := c.Query("token")
+	if token == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "missing token"})
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.Render(http.StatusOK, render.HTML{Template: resetPasswordPage, Data: token})
+}
+
+// ResetPassword godoc
+// @Summary Reset password
+// @Description Set a new password using the token from the reset email. Signs the user out of all sessions and revokes their personal access tokens. Also accepts the form fields of the reset page
+// @Tags authentication
+// @Accept json,x-www-form-urlencoded
+// @Produce json
+// @Param request body handler.PasswordHandler.ResetPassword.true.req true "Reset token and new password"
+// @Success 200 {object} map[string]string "Password changed"
+// @Failure 400 {object} map[string]string "Invalid request or token"
+// @Failure 500 {object} map[string]string "Internal server error"
+// @Router /password/reset [post]
+func (h *PasswordHandler) ResetPassword(c *gin.Context) {
+	var req struct {
+		Token       string `json:"token" form:"token" binding:"required"`
+		NewPassword string `json:"new_password" form:"new_password" binding:"required,min=6,max=72"`
+	}
+
+	if err := c.ShouldBind(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err := h.resetService.ResetPassword(c.Request.Context(), req.Token, req.NewPassword)
+	if err != nil {
+		if errors.Is(err, service.ErrInvalidResetToken) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to reset password"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "password changed, please log in again"})
+}
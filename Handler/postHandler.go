@@ -1,10 +1,12 @@
 package handler
 
 import (
-	"fmt"
+	"errors"
 	"net/http"
 	"redditBack/model"
 	"redditBack/service"
+	"redditBack/utility"
+	"strconv"
 
 	"github.com/gin-gonic/gin"
 )
@@ -19,7 +21,7 @@ func NewPostHandler(postService service.PostService) PostHandler {
 
 // CreatePost godoc
 // @Summary Create a new post
-// @Description Create a new post with title and content
+// @Description Create a new text post with title and content, or a link post with title and URL, optionally in a community whose posting requirements the caller meets. Link previews are fetched in the background
 // @Tags posts
 // @Security BearerAuth
 // @Accept json
@@ -28,19 +30,22 @@ func NewPostHandler(postService service.PostService) PostHandler {
 // @Success 200 {object} map[string]interface{}
 // @Failure 400 {object} map[string]string "Invalid request format"
 // @Failure 401 {object} map[string]string "Unauthorized"
+// @Failure 403 {object} map[string]string "Email address not verified or community requirements not met"
+// @Failure 404 {object} map[string]string "Community not found"
 // @Failure 500 {object} map[string]string "Internal server error"
 // @Router /posts [post]
 func (h *PostHandler) CreatePost(c *gin.Context) {
-	usernameVal := c.Value("user_id")
-	username, ok := usernameVal.(string)
+	principal, ok := utility.CurrentPrincipal(c)
 
 	if !ok {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid username passed from context"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user passed from context"})
 		return
 	}
 	var req struct {
-		Title   string `json:"Title" binding:"required,min=6"`
-		Context string `json:"Context" binding:"required,min=12"`
+		Title     string `json:"Title" binding:"required,min=6"`
+		Context   string `json:"Context" binding:"required_without=URL,omitempty,min=12"`
+		URL       string `json:"URL" binding:"omitempty,max=2048"`
+		Community string `json:"Community"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -51,11 +56,20 @@ func (h *PostHandler) CreatePost(c *gin.Context) {
 	post := &model.Post{
 		Title:   req.Title,
 		Content: req.Title,
-		UserID:  0,
+		URL:     req.URL,
 	}
 
-	err := h.postService.CreateNewPost(c.Request.Context(), post, username)
+	err := h.postService.CreateNewPost(c.Request.Context(), post, req.Community, principal)
 
+	if errors.Is(err, service.ErrEmailNotVerified) || errors.Is(err, service.ErrNotApprovedMember) ||
+		errors.Is(err, service.ErrAccountTooNew) || errors.Is(err, service.ErrNotEnoughKarma) {
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+	if errors.Is(err, service.ErrCommunityNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
@@ -80,11 +94,9 @@ func (h *PostHandler) CreatePost(c *gin.Context) {
 // @Failure 500 {object} map[string]string "Internal server error"
 // @Router /posts [put]
 func (h *PostHandler) EditPost(c *gin.Context) {
-	usernameVal := c.Value("user_id")
-	username, ok := usernameVal.(string)
-	fmt.Printf("username in post :%s", username)
+	principal, ok := utility.CurrentPrincipal(c)
 	if !ok {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid username passed from context"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user passed from context"})
 		return
 	}
 	var req struct {
@@ -103,7 +115,7 @@ func (h *PostHandler) EditPost(c *gin.Context) {
 		Content: req.Content,
 	}
 
-	nextErr := h.postService.EditPost(c.Request.Context(), updatedPost, username)
+	nextErr := h.postService.EditPost(c.Request.Context(), updatedPost, principal)
 	if nextErr != nil {
 		switch nextErr.Error() {
 		case "post not found":
@@ -125,7 +137,7 @@ func (h *PostHandler) EditPost(c *gin.Context) {
 
 // RemovePost godoc
 // @Summary Delete a post
-// @Description Delete an existing post
+// @Description Delete an existing post. Moderators and administrators may delete any post
 // @Tags posts
 // @Security BearerAuth
 // @Accept json
@@ -138,11 +150,9 @@ func (h *PostHandler) EditPost(c *gin.Context) {
 // @Failure 500 {object} map[string]string "Internal server error"
 // @Router /posts [delete]
 func (h *PostHandler) RemovePost(c *gin.Context) {
-	usernameVal := c.Value("user_id")
-	username, ok := usernameVal.(string)
-	fmt.Printf("username in post :%s", username)
+	principal, ok := utility.CurrentPrincipal(c)
 	if !ok {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid username passed from context"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user passed from context"})
 		return
 	}
 	var req struct {
@@ -157,7 +167,7 @@ func (h *PostHandler) RemovePost(c *gin.Context) {
 		ID: req.ID,
 	}
 
-	nextErr := h.postService.RemovePost(c.Request.Context(), updatedPost, username)
+	nextErr := h.postService.RemovePost(c.Request.Context(), updatedPost, principal)
 	if nextErr != nil {
 		switch nextErr.Error() {
 		case "post not found":
@@ -176,6 +186,45 @@ func (h *PostHandler) RemovePost(c *gin.Context) {
 
 }
 
+// GetPost godoc
+// @Summary Get a post
+// @Description Get a single post with its author, score and the caller's own vote (1, -1, or 0 when not voted)
+// @Tags posts
+// @Security BearerAuth
+// @Produce json
+// @Param id path int true "Post ID"
+// @Success 200 {object} service.PostDetail
+// @Failure 400 {object} map[string]string "Invalid post ID"
+// @Failure 404 {object} map[string]string "Post not found"
+// @Failure 500 {object} map[string]string "Internal server error"
+// @Router /posts/{id} [get]
+func (h *PostHandler) GetPost(c *gin.Context) {
+	principal, ok := utility.CurrentPrincipal(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user passed from context"})
+		return
+	}
+
+	postID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid post ID"})
+		return
+	}
+
+	post, err := h.postService.GetPost(c.Request.Context(), uint(postID), principal)
+	if err != nil {
+		switch err.Error() {
+		case "post not found":
+			c.JSON(http.StatusNotFound, gin.H{"error": "post not found"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get post"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, post)
+}
+
 // @Summary Get top posts
 // @Description Get top posts filtered by time range
 // @Tags posts
@@ -188,7 +237,7 @@ func (h *PostHandler) RemovePost(c *gin.Context) {
 func (c *PostHandler) GetTopPosts(ctx *gin.Context) {
 	timeRange := ctx.DefaultQuery("time", "day")
 
-	posts, err := c.postService.GetTopPosts(ctx.Request.Context(), timeRange)
+	posts, err := c.postService.GetTopPosts(ctx.Request.Context(), "", timeRange)
 	if err != nil {
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -196,3 +245,81 @@ func (c *PostHandler) GetTopPosts(ctx *gin.Context) {
 
 	ctx.JSON(http.StatusOK, posts)
 }
+
+// @Summary Get new posts
+// @Description Get the newest posts of all communities, 50 at a time
+// @Tags posts
+// @Produce json
+// @Param before query int false "Continue below this post ID"
+// @Success 200 {array} model.Post
+// @Failure 400 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /new [get]
+func (h *PostHandler) GetNewPosts(c *gin.Context) {
+	h.writeNewPosts(c, "")
+}
+
+// @Summary Get top posts of a community
+// @Description Get top posts of a community filtered by time range
+// @Tags communities
+// @Produce json
+// @Param name path string true "Community name"
+// @Param time query string false "Time range filter" Enums(day, week, month, all) default(day)
+// @Success 200 {array} model.Post
+// @Failure 404 {object} map[string]string "Community not found"
+// @Failure 500 {object} map[string]string
+// @Router /c/{name}/top [get]
+func (h *PostHandler) GetCommunityTopPosts(c *gin.Context) {
+	timeRange := c.DefaultQuery("time", "day")
+
+	posts, err := h.postService.GetTopPosts(c.Request.Context(), c.Param("name"), timeRange)
+	if err != nil {
+		if errors.Is(err, service.ErrCommunityNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, posts)
+}
+
+// @Summary Get new posts of a community
+// @Description Get the newest posts of a community, 50 at a time
+// @Tags communities
+// @Produce json
+// @Param name path string true "Community name"
+// @Param before query int false "Continue below this post ID"
+// @Success 200 {array} model.Post
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string "Community not found"
+// @Failure 500 {object} map[string]string
+// @Router /c/{name}/new [get]
+func (h *PostHandler) GetCommunityNewPosts(c *gin.Context) {
+	h.writeNewPosts(c, c.Param("name"))
+}
+
+func (h *PostHandler) writeNewPosts(c *gin.Context, communityName string) {
+	var beforeID uint64
+	if before := c.Query("before"); before != "" {
+		var err error
+		beforeID, err = strconv.ParseUint(before, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid post ID"})
+			return
+		}
+	}
+
+	posts, err := h.postService.GetNewPosts(c.Request.Context(), communityName, uint(beforeID))
+	if err != nil {
+		if errors.Is(err, service.ErrCommunityNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list posts"})
+		return
+	}
+
+	c.JSON(http.StatusOK, posts)
+}
@@ -0,0 +1,68 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"redditBack/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Headers carrying a solved challenge on the guarded requests.
+const (
+	ProofOfWorkChallengeHeader = "X-PoW-Challenge"
+	ProofOfWorkSolutionHeader  = "X-PoW-Solution"
+)
+
+type ProofOfWorkHandler struct {
+	powService service.ProofOfWorkService
+}
+
+func NewProofOfWorkHandler(powService service.ProofOfWorkService) ProofOfWorkHandler {
+	return ProofOfWorkHandler{powService: powService}
+}
+
+// IssueChallenge godoc
+// @Summary Get a proof-of-work challenge
+// @Description Issue a signed challenge for an action. Find a solution so that the SHA-256 hash of "challenge:solution" starts with difficulty zero bits, and send both in the X-PoW-Challenge and X-PoW-Solution headers. Difficulty rises under bursts from the same IP range, and challenges issued before it rose are refused
+// @Tags authentication
+// @Produce json
+// @Param action query string false "Action to solve the challenge for" default(signup)
+// @Success 200 {object} service.ProofOfWorkChallenge
+// @Failure 400 {object} map[string]string "No proof of work required for this action"
+// @Failure 500 {object} map[string]string "Internal server error"
+// @Router /pow/challenge [get]
+func (h *ProofOfWorkHandler) IssueChallenge(c *gin.Context) {
+	challenge, err := h.powService.IssueChallenge(c.Request.Context(), c.DefaultQuery("action", "signup"), c.ClientIP())
+	if err != nil {
+		if errors.Is(err, service.ErrUnknownProofOfWorkAction) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to issue challenge"})
+		return
+	}
+
+	c.JSON(http.StatusOK, challenge)
+}
+
+// Require guards a route with a proof-of-work challenge for action. Routes
+// pass through untouched while the action does not require one.
+func (h *ProofOfWorkHandler) Require(action string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		err := h.powService.Verify(c.Request.Context(), action, c.ClientIP(),
+			c.GetHeader(ProofOfWorkChallengeHeader), c.GetHeader(ProofOfWorkSolutionHeader))
+		if err != nil {
+			switch {
+			case errors.Is(err, service.ErrProofOfWorkRequired), errors.Is(err, service.ErrInvalidProofOfWork),
+				errors.Is(err, service.ErrProofOfWorkOutdated):
+				c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			default:
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to verify proof of work"})
+			}
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
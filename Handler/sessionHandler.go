@@ -0,0 +1,142 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"redditBack/service"
+	"redditBack/utility"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type SessionHandler struct {
+	tokenService service.TokenService
+}
+
+func NewSessionHandler(tokenService service.TokenService) SessionHandler {
+	return SessionHandler{tokenService: tokenService}
+}
+
+// sessionMeta collects what we record about the client starting a session.
+// Clients may name themselves with X-Device-Name, otherwise the User-Agent
+// is summarised.
+func sessionMeta(c *gin.Context) service.SessionMeta {
+	userAgent := c.Request.UserAgent()
+	device := c.GetHeader("X-Device-Name")
+	if device == "" {
+		device = utility.DescribeDevice(userAgent)
+	}
+	if len(device) > 100 {
+		device = device[:100]
+	}
+	return service.SessionMeta{
+		Device:    device,
+		IPAddress: c.ClientIP(),
+		UserAgent: userAgent,
+	}
+}
+
+// writeTokens answers a successful login. Browser clients that asked for
+// cookies get the tokens as HttpOnly cookies and only the CSRF token in the
+// body, everyone else gets the tokens themselves.
+func writeTokens(c *gin.Context, cookies utility.SessionCookieConfig, status int, tokens *service.TokenPair,
+	user interface{}, useCookies bool) {
+	if useCookies {
+		utility.SetSessionCookies(c, cookies, tokens.AccessToken, tokens.RefreshToken, tokens.CSRFToken,
+			time.Duration(tokens.ExpiresIn)*time.Second, time.Duration(tokens.RefreshExpiresIn)*time.Second)
+		c.JSON(status, gin.H{
+			"csrf_token": tokens.CSRFToken,
+			"expires_in": tokens.ExpiresIn,
+			"user":       user,
+		})
+		return
+	}
+
+	c.JSON(status, gin.H{
+		"token":         tokens.AccessToken,
+		"refresh_token": tokens.RefreshToken,
+		"expires_in":    tokens.ExpiresIn,
+		"user":          user,
+	})
+}
+
+// ListSessions godoc
+// @Summary List sessions
+// @Description List the current user's active sessions with device, IP address and activity times
+// @Tags sessions
+// @Security BearerAuth
+// @Produce json
+// @Success 200 {array} service.SessionInfo
+// @Failure 401 {object} map[string]string "Unauthorized"
+// @Failure 500 {object} map[string]string "Internal server error"
+// @Router /sessions [get]
+func (h *SessionHandler) ListSessions(c *gin.Context) {
+	principal, ok := utility.CurrentPrincipal(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user passed from context"})
+		return
+	}
+	sessions, err := h.tokenService.ListSessions(c.Request.Context(), principal)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list sessions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, sessions)
+}
+
+// RevokeSession godoc
+// @Summary Sign out a session
+// @Description Sign out one of the current user's sessions, e.g. a lost device
+// @Tags sessions
+// @Security BearerAuth
+// @Produce json
+// @Param id path string true "Session ID"
+// @Success 200 {object} map[string]string
+// @Failure 404 {object} map[string]string "Session not found"
+// @Failure 500 {object} map[string]string "Internal server error"
+// @Router /sessions/{id} [delete]
+func (h *SessionHandler) RevokeSession(c *gin.Context) {
+	principal, ok := utility.CurrentPrincipal(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user passed from context"})
+		return
+	}
+
+	err := h.tokenService.RevokeSession(c.Request.Context(), principal, c.Param("id"))
+	if err != nil {
+		if errors.Is(err, service.ErrSessionNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to revoke session"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "session revoked"})
+}
+
+// RevokeAllSessions godoc
+// @Summary Sign out everywhere
+// @Description Sign out every session of the current user, including this one
+// @Tags sessions
+// @Security BearerAuth
+// @Produce json
+// @Success 200 {object} map[string]string
+// @Failure 500 {object} map[string]string "Internal server error"
+// @Router /sessions [delete]
+func (h *SessionHandler) RevokeAllSessions(c *gin.Context) {
+	principal, ok := utility.CurrentPrincipal(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user passed from context"})
+		return
+	}
+
+	if err := h.tokenService.SignOutEverywhere(c.Request.Context(), principal); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to revoke sessions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "signed out everywhere"})
+}
@@ -1,9 +1,12 @@
 package handler
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
 	"redditBack/service"
+	"redditBack/utility"
+	"strconv"
 
 	"github.com/gin-gonic/gin"
 )
@@ -19,7 +22,7 @@ func NewVoteHandler(voteService service.VoteService) VoteHandler {
 
 // VotePost godoc
 // @Summary Vote on a post
-// @Description Vote (+1/-1) on a post
+// @Description Vote (+1/-1) on a post, or clear the vote with 0
 // @Tags votes
 // @Security BearerAuth
 // @Accept json
@@ -27,36 +30,91 @@ func NewVoteHandler(voteService service.VoteService) VoteHandler {
 // @Param vote body handler.VoteHandler.VotePost.true.req true "Vote data"
 // @Success 200 {object} map[string]interface{}
 // @Failure 400 {object} map[string]string "Invalid request format"
-// @Failure 403 {object} map[string]string "Cannot vote on own post"
+// @Failure 403 {object} map[string]string "Cannot vote on own post or email address not verified"
 // @Failure 404 {object} map[string]string "Post not found"
 // @Failure 500 {object} map[string]string "Internal server error"
 // @Router /votes [post]
 func (h *VoteHandler) VotePost(c *gin.Context) {
 
-	usernameVal := c.Value("user_id")
-	username, ok := usernameVal.(string)
+	principal, ok := utility.CurrentPrincipal(c)
 	if !ok {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid username passed from context"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user passed from context"})
 		return
 	}
 
 	var req struct {
 		PostID    uint `json:"postID" binding:"required"`
-		VoteValue int  `json:"voteValue" binding:"required"`
+		VoteValue *int `json:"voteValue" binding:"required"`
 	}
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	err := h.voteService.VotePost(c.Request.Context(), uint(req.PostID), username, req.VoteValue)
+	err := h.voteService.VotePost(c.Request.Context(), uint(req.PostID), principal, *req.VoteValue)
 	if err != nil {
 		fmt.Print(err.Error())
-		switch err.Error() {
-		case "post not found":
+		switch {
+		case err.Error() == "post not found":
 			c.JSON(http.StatusNotFound, gin.H{"error": "post not found"})
-		case "cannot vote on your own post":
+		case errors.Is(err, service.ErrSelfVote), errors.Is(err, service.ErrEmailNotVerified):
 			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		case errors.Is(err, service.ErrInvalidVoteValue):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to process vote"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "vote processed successfully"})
+}
+
+// VoteComment godoc
+// @Summary Vote on a comment
+// @Description Vote (+1/-1) on a comment, or clear the vote with 0. Comment scores count towards the author's karma
+// @Tags votes
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param id path int true "Comment ID"
+// @Param vote body handler.VoteHandler.VoteComment.true.req true "Vote data"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} map[string]string "Invalid request format"
+// @Failure 403 {object} map[string]string "Cannot vote on own comment or email address not verified"
+// @Failure 404 {object} map[string]string "Comment not found"
+// @Failure 500 {object} map[string]string "Internal server error"
+// @Router /comments/{id}/vote [post]
+func (h *VoteHandler) VoteComment(c *gin.Context) {
+	principal, ok := utility.CurrentPrincipal(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user passed from context"})
+		return
+	}
+
+	commentID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid comment ID"})
+		return
+	}
+
+	var req struct {
+		VoteValue *int `json:"voteValue" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err = h.voteService.VoteComment(c.Request.Context(), uint(commentID), principal, *req.VoteValue)
+	if err != nil {
+		switch {
+		case err.Error() == "comment not found":
+			c.JSON(http.StatusNotFound, gin.H{"error": "comment not found"})
+		case errors.Is(err, service.ErrSelfVote), errors.Is(err, service.ErrEmailNotVerified):
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		case errors.Is(err, service.ErrInvalidVoteValue):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		default:
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to process vote"})
 		}
@@ -0,0 +1,332 @@
+package handler
+
+import (
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"redditBack/repository"
+	"redditBack/service"
+	"redditBack/utility"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type WebAuthnHandler struct {
+	webAuthnService service.WebAuthnService
+	authService     service.AuthService
+	tokenService    service.TokenService
+	cookies         utility.SessionCookieConfig
+}
+
+func NewWebAuthnHandler(webAuthnService service.WebAuthnService, authService service.AuthService,
+	tokenService service.TokenService, cookies utility.SessionCookieConfig) WebAuthnHandler {
+	return WebAuthnHandler{
+		webAuthnService: webAuthnService,
+		authService:     authService,
+		tokenService:    tokenService,
+		cookies:         cookies,
+	}
+}
+
+// BeginRegistration godoc
+// @Summary Start passkey registration
+// @Description Return the options to pass to navigator.credentials.create(). Binary values are base64url encoded
+// @Tags passkeys
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param request body handler.WebAuthnHandler.BeginRegistration.true.req false "Name for the passkey"
+// @Success 200 {object} utility.WebAuthnCreationOptions
+// @Failure 400 {object} map[string]string "Invalid request format"
+// @Failure 404 {object} map[string]string "User not found"
+// @Failure 500 {object} map[string]string "Internal server error"
+// @Router /passkeys/register/begin [post]
+func (h *WebAuthnHandler) BeginRegistration(c *gin.Context) {
+	var req struct {
+		Name string `json:"name" binding:"max=100"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	principal, ok := utility.CurrentPrincipal(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user passed from context"})
+		return
+	}
+
+	options, err := h.webAuthnService.BeginRegistration(c.Request.Context(), principal, req.Name)
+	if err != nil {
+		if errors.Is(err, service.ErrUserNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		log.Printf("failed to start passkey registration: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to start passkey registration"})
+		return
+	}
+
+	c.JSON(http.StatusOK, options)
+}
+
+// FinishRegistration godoc
+// @Summary Finish passkey registration
+// @Description Verify the authenticator's response to the registration options and store the passkey. Only "none" attestation is accepted
+// @Tags passkeys
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param request body handler.WebAuthnHandler.FinishRegistration.true.req true "Base64url encoded clientDataJSON and attestationObject"
+// @Success 201 {object} model.WebAuthnCredential
+// @Failure 400 {object} map[string]string "Invalid response or expired challenge"
+// @Failure 409 {object} map[string]string "Passkey already registered"
+// @Failure 500 {object} map[string]string "Internal server error"
+// @Router /passkeys/register/finish [post]
+func (h *WebAuthnHandler) FinishRegistration(c *gin.Context) {
+	var req struct {
+		ClientDataJSON    string `json:"client_data_json" binding:"required"`
+		AttestationObject string `json:"attestation_object" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	principal, ok := utility.CurrentPrincipal(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user passed from context"})
+		return
+	}
+
+	clientDataJSON, err := utility.DecodeWebAuthnBinary(req.ClientDataJSON)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	attestationObject, err := utility.DecodeWebAuthnBinary(req.AttestationObject)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	credential, err := h.webAuthnService.FinishRegistration(c.Request.Context(), principal, clientDataJSON, attestationObject)
+	if err != nil {
+		switch {
+		case errors.Is(err, utility.ErrInvalidWebAuthnResponse), errors.Is(err, service.ErrInvalidPasskeyChallenge):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case errors.Is(err, service.ErrPasskeyExists):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to register passkey"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusCreated, credential)
+}
+
+// ListCredentials godoc
+// @Summary List passkeys
+// @Description List the passkeys registered by the current user
+// @Tags passkeys
+// @Security BearerAuth
+// @Produce json
+// @Success 200 {array} model.WebAuthnCredential
+// @Failure 500 {object} map[string]string "Internal server error"
+// @Router /passkeys [get]
+func (h *WebAuthnHandler) ListCredentials(c *gin.Context) {
+	principal, ok := utility.CurrentPrincipal(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user passed from context"})
+		return
+	}
+
+	credentials, err := h.webAuthnService.ListCredentials(c.Request.Context(), principal)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list passkeys"})
+		return
+	}
+
+	c.JSON(http.StatusOK, credentials)
+}
+
+// DeleteCredential godoc
+// @Summary Remove a passkey
+// @Description Remove one of the current user's passkeys
+// @Tags passkeys
+// @Security BearerAuth
+// @Produce json
+// @Param id path int true "Passkey ID"
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} map[string]string "Invalid passkey ID"
+// @Failure 404 {object} map[string]string "Passkey not found"
+// @Failure 500 {object} map[string]string "Internal server error"
+// @Router /passkeys/{id} [delete]
+func (h *WebAuthnHandler) DeleteCredential(c *gin.Context) {
+	principal, ok := utility.CurrentPrincipal(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user passed from context"})
+		return
+	}
+
+	credentialID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid passkey ID"})
+		return
+	}
+
+	err = h.webAuthnService.DeleteCredential(c.Request.Context(), principal, uint(credentialID))
+	if err != nil {
+		if errors.Is(err, repository.ErrCredentialNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "passkey not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to remove passkey"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "passkey removed"})
+}
+
+// BeginReauthentication godoc
+// @Summary Confirm a change with a passkey
+// @Description Return the options to pass to navigator.credentials.get() when confirming an account change. Send the assertion as "passkey" with the change
+// @Tags passkeys
+// @Security BearerAuth
+// @Produce json
+// @Success 200 {object} utility.WebAuthnRequestOptions
+// @Failure 404 {object} map[string]string "No passkeys registered"
+// @Failure 500 {object} map[string]string "Internal server error"
+// @Router /passkeys/reauth/begin [post]
+func (h *WebAuthnHandler) BeginReauthentication(c *gin.Context) {
+	principal, ok := utility.CurrentPrincipal(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user passed from context"})
+		return
+	}
+
+	options, err := h.webAuthnService.BeginReauthentication(c.Request.Context(), principal)
+	if err != nil {
+		if errors.Is(err, service.ErrInvalidPasskey) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "no passkeys registered"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to start passkey confirmation"})
+		return
+	}
+
+	c.JSON(http.StatusOK, options)
+}
+
+// BeginLogin godoc
+// @Summary Start a passkey login
+// @Description Return the options to pass to navigator.credentials.get(). Send the mfa_token of a password login to use a passkey as its second factor
+// @Tags authentication
+// @Accept json
+// @Produce json
+// @Param request body handler.WebAuthnHandler.BeginLogin.true.req false "Optional two-factor challenge"
+// @Success 200 {object} utility.WebAuthnRequestOptions
+// @Failure 400 {object} map[string]string "Invalid or expired two-factor challenge"
+// @Failure 500 {object} map[string]string "Internal server error"
+// @Router /login/passkey/begin [post]
+func (h *WebAuthnHandler) BeginLogin(c *gin.Context) {
+	var req struct {
+		MFAToken string `json:"mfa_token"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	options, err := h.webAuthnService.BeginLogin(c.Request.Context(), req.MFAToken)
+	if err != nil {
+		if errors.Is(err, service.ErrInvalidMFAChallenge) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to start passkey login"})
+		return
+	}
+
+	c.JSON(http.StatusOK, options)
+}
+
+// FinishLogin godoc
+// @Summary Log in with a passkey
+// @Description Verify the authenticator's assertion and return a token pair. Passkeys that did not verify the user still need the TOTP code of users who enabled it
+// @Tags authentication
+// @Accept json
+// @Produce json
+// @Param request body handler.WebAuthnHandler.FinishLogin.true.req true "Base64url encoded assertion"
+// @Success 200 {object} map[string]interface{} "Successfully logged in"
+// @Failure 400 {object} map[string]string "Invalid response or expired challenge"
+// @Failure 401 {object} map[string]string "Unknown passkey"
+// @Failure 500 {object} map[string]string "Internal server error"
+// @Router /login/passkey/finish [post]
+func (h *WebAuthnHandler) FinishLogin(c *gin.Context) {
+	var req struct {
+		CredentialID      string `json:"credential_id" binding:"required"`
+		ClientDataJSON    string `json:"client_data_json" binding:"required"`
+		AuthenticatorData string `json:"authenticator_data" binding:"required"`
+		Signature         string `json:"signature" binding:"required"`
+		UseCookies        bool   `json:"use_cookies"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var decoded [4][]byte
+	for i, value := range []string{req.CredentialID, req.ClientDataJSON, req.AuthenticatorData, req.Signature} {
+		raw, err := utility.DecodeWebAuthnBinary(value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		decoded[i] = raw
+	}
+
+	login, err := h.webAuthnService.FinishLogin(c.Request.Context(), decoded[0], decoded[1], decoded[2], decoded[3])
+	if err != nil {
+		switch {
+		case errors.Is(err, utility.ErrInvalidWebAuthnResponse), errors.Is(err, service.ErrInvalidPasskeyChallenge):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case errors.Is(err, service.ErrInvalidPasskey):
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		default:
+			log.Printf("passkey login failed: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to complete login"})
+		}
+		return
+	}
+
+	user := login.User
+	if !login.MultiFactor && user.TOTPEnabled {
+		result, err := h.authService.CompletePasskeyFirstFactor(c.Request.Context(), user)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to complete login"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"mfa_required": true,
+			"mfa_token":    result.MFAChallenge,
+			"mfa_methods":  result.MFAMethods,
+		})
+		return
+	}
+
+	tokens, err := h.tokenService.IssueTokens(c.Request.Context(), user, sessionMeta(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate token"})
+		return
+	}
+
+	writeTokens(c, h.cookies, http.StatusOK, tokens, user, req.UseCookies)
+}
@@ -0,0 +1,34 @@
+package model
+
+import "time"
+
+// DeletedComment replaces the content of removed comments that still have
+// replies, so the thread below them stays in place. Comments without replies
+// are deleted outright.
+const DeletedComment = "[deleted]"
+
+// Comment is one node of a post's comment tree. Path is the materialized
+// path of zero padded ids from the top level comment down to this one, so a
+// whole thread loads with one range query ordered by path.
+type Comment struct {
+	ID          uint       `gorm:"primaryKey"`
+	PostID      uint       `gorm:"not null;index"`
+	ParentID    *uint      `gorm:"index"`
+	Path        string     `gorm:"not null;index" json:"-"`
+	Depth       int        `gorm:"not null;default:0"`
+	Content     string     `gorm:"not null;type:text"`
+	UserID      *uint      `gorm:"index"`
+	Author      string     `gorm:"->;-:migration" json:"author"`
+	ReplyCount  int        `gorm:"not null;default:0"`
+	CachedScore int        `gorm:"default:0"`
+	RemovedAt   *time.Time `json:",omitempty"`
+	CreatedAt   time.Time  `gorm:"autoCreateTime"`
+	UpdatedAt   time.Time  `gorm:"autoUpdateTime"`
+	User        User       `gorm:"foreignKey:UserID;constraint:OnDelete:SET NULL" json:"-"`
+	Post        Post       `gorm:"foreignKey:PostID;constraint:OnDelete:CASCADE" json:"-"`
+	Parent      *Comment   `gorm:"foreignKey:ParentID;constraint:OnDelete:CASCADE" json:"-"`
+	// Replies and MoreReplies are filled in when a tree is assembled.
+	// MoreReplies counts direct replies that were not loaded.
+	Replies     []*Comment `gorm:"-" json:"replies,omitempty"`
+	MoreReplies int        `gorm:"-" json:"more_replies,omitempty"`
+}
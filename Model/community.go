@@ -0,0 +1,38 @@
+package model
+
+import "time"
+
+// Community visibilities. Anyone may read either kind, but only the creator
+// and approved members may post in a restricted community.
+const (
+	CommunityPublic     = "public"
+	CommunityRestricted = "restricted"
+)
+
+// Community groups posts under a name, like a subreddit. Posters must meet
+// MinAccountAgeDays and MinKarma on top of the visibility rules.
+type Community struct {
+	ID                uint      `gorm:"primaryKey"`
+	Name              string    `gorm:"uniqueIndex;not null"`
+	Description       string    `gorm:"type:text"`
+	Rules             string    `gorm:"type:text"`
+	CreatorID         *uint     `gorm:"index" json:"-"`
+	Visibility        string    `gorm:"not null;default:public"`
+	MinAccountAgeDays int       `gorm:"not null;default:0"`
+	MinKarma          int       `gorm:"not null;default:0"`
+	SubscriberCount   int       `gorm:"not null;default:0"`
+	CreatedAt         time.Time `gorm:"autoCreateTime"`
+	UpdatedAt         time.Time `gorm:"autoUpdateTime"`
+	Creator           *User     `gorm:"foreignKey:CreatorID;constraint:OnDelete:SET NULL" json:"-"`
+}
+
+// CommunitySubscription makes a user a member of a community. Approved
+// members may post in restricted communities.
+type CommunitySubscription struct {
+	UserID      uint      `gorm:"primaryKey"`
+	CommunityID uint      `gorm:"primaryKey;index"`
+	Approved    bool      `gorm:"not null;default:false"`
+	CreatedAt   time.Time `gorm:"autoCreateTime"`
+	User        User      `gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE"`
+	Community   Community `gorm:"foreignKey:CommunityID;constraint:OnDelete:CASCADE"`
+}
@@ -0,0 +1,22 @@
+package model
+
+import "time"
+
+const (
+	DataExportPending = "pending"
+	DataExportReady   = "ready"
+	DataExportFailed  = "failed"
+)
+
+// DataExport is a user's request for a copy of their data. Its ID is random
+// since it appears in download links.
+type DataExport struct {
+	ID          string    `gorm:"primaryKey"`
+	UserID      uint      `gorm:"index;not null"`
+	Status      string    `gorm:"not null"`
+	FilePath    string    `json:"-"`
+	CreatedAt   time.Time `gorm:"autoCreateTime"`
+	CompletedAt *time.Time
+	ExpiresAt   time.Time `gorm:"index"`
+	User        User      `gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE" json:"-"`
+}
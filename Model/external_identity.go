@@ -0,0 +1,13 @@
+package model
+
+import "time"
+
+type ExternalIdentity struct {
+	ID        uint   `gorm:"primaryKey"`
+	UserID    uint   `gorm:"index;not null"`
+	Provider  string `gorm:"not null;uniqueIndex:idx_provider_subject"`
+	Subject   string `gorm:"not null;uniqueIndex:idx_provider_subject"`
+	Email     string
+	CreatedAt time.Time `gorm:"autoCreateTime"`
+	User      User      `gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE"`
+}
@@ -0,0 +1,17 @@
+package model
+
+import "time"
+
+// InviteCode lets up to MaxUses people sign up while registration is invite
+// only. Users record the code they signed up with and its creator.
+type InviteCode struct {
+	ID          uint   `gorm:"primaryKey"`
+	Code        string `gorm:"uniqueIndex;not null"`
+	CreatedByID uint   `gorm:"index;not null"`
+	MaxUses     int    `gorm:"not null;default:1"`
+	Uses        int    `gorm:"not null;default:0"`
+	ExpiresAt   *time.Time
+	RevokedAt   *time.Time
+	CreatedAt   time.Time `gorm:"autoCreateTime"`
+	CreatedBy   User      `gorm:"foreignKey:CreatedByID;constraint:OnDelete:CASCADE" json:"-"`
+}
@@ -0,0 +1,15 @@
+package model
+
+import "time"
+
+// LinkPreview caches the unfurled metadata of a link, keyed by its
+// normalised URL so every post of the same page shares one fetch. Error is
+// kept for failed fetches so they are not retried before the entry expires.
+type LinkPreview struct {
+	URL         string `gorm:"primaryKey"`
+	Title       string
+	Description string `gorm:"type:text"`
+	ImageURL    string
+	Error       string    `json:"-"`
+	FetchedAt   time.Time `gorm:"not null"`
+}
@@ -0,0 +1,17 @@
+package model
+
+import "time"
+
+type PersonalAccessToken struct {
+	ID         uint   `gorm:"primaryKey"`
+	UserID     uint   `gorm:"index;not null"`
+	Name       string `gorm:"not null"`
+	TokenHash  string `gorm:"uniqueIndex;not null" json:"-"`
+	Prefix     string `gorm:"not null"`
+	Scopes     string `gorm:"not null"`
+	LastUsedAt *time.Time
+	ExpiresAt  *time.Time
+	RevokedAt  *time.Time `json:"-"`
+	CreatedAt  time.Time  `gorm:"autoCreateTime"`
+	User       User       `gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE" json:"-"`
+}
@@ -2,14 +2,36 @@ package model
 
 import "time"
 
+// Post kinds. Link posts carry a normalised URL, and the preview fields are
+// filled in from the link's LinkPreview once it was unfurled.
+const (
+	PostKindText = "text"
+	PostKindLink = "link"
+)
+
+// DeletedAuthor is shown in place of the author of posts whose account was
+// deleted.
+const DeletedAuthor = "[deleted]"
+
+// CommentCount counts the comments of the post that are not deleted. Posts
+// without a CommunityID predate communities and only show up globally.
 type Post struct {
-	ID          uint      `gorm:"primaryKey"`
-	Title       string    `gorm:"not null"`
-	Content     string    `gorm:"not null;type:text"`
-	UserID      uint      `gorm:"not null"`
-	CreatedAt   time.Time `gorm:"autoCreateTime"`
-	UpdatedAt   time.Time `gorm:"autoUpdateTime"`
-	CachedScore int       `gorm:"default:0"`
-	User        User      `gorm:"foreignKey:UserID"`
-	Votes       []Vote    `gorm:"foreignKey:PostID;constraint:OnDelete:CASCADE"`
+	ID              uint      `gorm:"primaryKey"`
+	Title           string    `gorm:"not null"`
+	Content         string    `gorm:"not null;type:text"`
+	Kind            string    `gorm:"not null;default:text"`
+	URL             string    `gorm:"index" json:",omitempty"`
+	LinkTitle       string    `gorm:"->;-:migration" json:",omitempty"`
+	LinkDescription string    `gorm:"->;-:migration" json:",omitempty"`
+	LinkImageURL    string    `gorm:"->;-:migration" json:",omitempty"`
+	UserID          *uint     `gorm:"index"`
+	Author          string    `gorm:"->;-:migration" json:"author"`
+	CommunityID     *uint     `gorm:"index"`
+	Community       string    `gorm:"->;-:migration" json:"community,omitempty"`
+	CreatedAt       time.Time `gorm:"autoCreateTime"`
+	UpdatedAt       time.Time `gorm:"autoUpdateTime"`
+	CachedScore     int       `gorm:"default:0"`
+	CommentCount    int       `gorm:"not null;default:0"`
+	User            User      `gorm:"foreignKey:UserID;constraint:OnDelete:SET NULL"`
+	Votes           []Vote    `gorm:"foreignKey:PostID;constraint:OnDelete:CASCADE"`
 }
@@ -0,0 +1,19 @@
+package model
+
+import "time"
+
+// Session is one login on one device. Its ID is the refresh token family ID
+// and is carried in the sid claim of every access token of that login.
+type Session struct {
+	ID         string `gorm:"primaryKey"`
+	UserID     uint   `gorm:"index;not null"`
+	Device     string
+	IPAddress  string
+	UserAgent  string
+	CreatedAt  time.Time `gorm:"autoCreateTime"`
+	LastSeenAt time.Time
+	ExpiresAt  time.Time
+	RevokedAt  *time.Time `json:"-"`
+	CSRFToken  string     `json:"-"`
+	User       User       `gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE" json:"-"`
+}
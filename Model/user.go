@@ -1,14 +1,57 @@
 package model
 
-import "time"
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Deleted accounts keep their row until the purge, with the username and
+// email replaced by placeholders. The prefix and the .invalid domain are
+// reserved so no signup or email change can take a placeholder first.
+const (
+	deletedUsernamePrefix = "deleted-"
+	reservedEmailDomain   = "invalid"
+)
+
+func DeletedUsername(id uint) string {
+	return fmt.Sprintf("%s%d", deletedUsernamePrefix, id)
+}
+
+func DeletedEmail(id uint) string {
+	return fmt.Sprintf("%s%d@%s", deletedUsernamePrefix, id, reservedEmailDomain)
+}
+
+func IsReservedUsername(username string) bool {
+	return strings.HasPrefix(strings.ToLower(username), deletedUsernamePrefix)
+}
+
+func IsReservedEmail(email string) bool {
+	_, domain, _ := strings.Cut(strings.ToLower(strings.TrimSpace(email)), "@")
+	return domain == reservedEmailDomain || strings.HasSuffix(domain, "."+reservedEmailDomain)
+}
 
 type User struct {
-	ID           uint      `gorm:"primaryKey"`
-	Username     string    `gorm:"unique;not null"`
-	Email        string    `gorm:"unique;not null"`
-	PasswordHash string    `gorm:"not null"`
-	CreatedAt    time.Time `gorm:"autoCreateTime"`
-	UpdatedAt    time.Time `gorm:"autoUpdateTime"`
-	Posts        []Post    `gorm:"foreignKey:UserID"`
-	Votes        []Vote    `gorm:"foreignKey:UserID"`
+	ID              uint   `gorm:"primaryKey"`
+	Username        string `gorm:"unique;not null"`
+	Email           string `gorm:"unique;not null"`
+	PasswordHash    string `gorm:"not null" json:"-"`
+	EmailVerified   bool   `gorm:"not null;default:false"`
+	EmailVerifiedAt *time.Time
+	DisplayName     string
+	Bio             string `gorm:"type:text"`
+	AvatarURL       string
+	TOTPSecret      string         `json:"-"`
+	TOTPEnabled     bool           `gorm:"not null;default:false"`
+	Role            string         `gorm:"not null;default:user"`
+	InvitedByID     *uint          `gorm:"index" json:"-"`
+	InviteCodeID    *uint          `json:"-"`
+	CreatedAt       time.Time      `gorm:"autoCreateTime"`
+	UpdatedAt       time.Time      `gorm:"autoUpdateTime"`
+	DeletedAt       gorm.DeletedAt `gorm:"index" json:"-"`
+	Posts           []Post         `gorm:"foreignKey:UserID;constraint:OnDelete:SET NULL"`
+	Votes           []Vote         `gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE"`
+	InvitedBy       *User          `gorm:"foreignKey:InvitedByID;constraint:OnDelete:SET NULL" json:"-"`
 }
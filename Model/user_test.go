@@ -0,0 +1,52 @@
+package model
+
+import "testing"
+
+func TestDeletedPlaceholdersAreReserved(t *testing.T) {
+	if !IsReservedUsername(DeletedUsername(42)) {
+		t.Errorf("%q is not reserved", DeletedUsername(42))
+	}
+	if !IsReservedEmail(DeletedEmail(42)) {
+		t.Errorf("%q is not reserved", DeletedEmail(42))
+	}
+}
+
+func TestIsReservedUsername(t *testing.T) {
+	tests := []struct {
+		username string
+		want     bool
+	}{
+		{"deleted-1", true},
+		{"Deleted-77", true},
+		{"deleted-", true},
+		{"deleted", false},
+		{"deleted_1", false},
+		{"undeleted-1", false},
+		{"alice", false},
+	}
+	for _, tt := range tests {
+		if got := IsReservedUsername(tt.username); got != tt.want {
+			t.Errorf("IsReservedUsername(%q) = %v, want %v", tt.username, got, tt.want)
+		}
+	}
+}
+
+func TestIsReservedEmail(t *testing.T) {
+	tests := []struct {
+		email string
+		want  bool
+	}{
+		{"deleted-1@invalid", true},
+		{"someone@INVALID", true},
+		{"someone@mail.invalid", true},
+		{" someone@invalid ", true},
+		{"someone@invalid.com", false},
+		{"someone@notinvalid", false},
+		{"invalid@example.com", false},
+	}
+	for _, tt := range tests {
+		if got := IsReservedEmail(tt.email); got != tt.want {
+			t.Errorf("IsReservedEmail(%q) = %v, want %v", tt.email, got, tt.want)
+		}
+	}
+}
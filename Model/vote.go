@@ -10,3 +10,13 @@ type Vote struct {
 	User      User      `gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE"`
 	Post      Post      `gorm:"foreignKey:PostID;constraint:OnDelete:CASCADE"`
 }
+
+// CommentVote is a vote on a comment, with the same values as a Vote.
+type CommentVote struct {
+	UserID    uint      `gorm:"primaryKey"`
+	CommentID uint      `gorm:"primaryKey"`
+	VoteValue int       `gorm:"check:vote_value IN (-1,1)"`
+	CreatedAt time.Time `gorm:"autoCreateTime"`
+	User      User      `gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE"`
+	Comment   Comment   `gorm:"foreignKey:CommentID;constraint:OnDelete:CASCADE"`
+}
@@ -0,0 +1,18 @@
+package model
+
+import "time"
+
+// WebAuthnCredential is a passkey registered by a user. PublicKey keeps the
+// COSE encoding the authenticator sent, and SignCount the last counter it
+// reported, which helps to notice cloned authenticators.
+type WebAuthnCredential struct {
+	ID           uint   `gorm:"primaryKey"`
+	UserID       uint   `gorm:"index;not null"`
+	Name         string `gorm:"not null"`
+	CredentialID []byte `gorm:"uniqueIndex;not null"`
+	PublicKey    []byte `gorm:"not null" json:"-"`
+	SignCount    uint32 `gorm:"not null;default:0" json:"-"`
+	LastUsedAt   *time.Time
+	CreatedAt    time.Time `gorm:"autoCreateTime"`
+	User         User      `gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE" json:"-"`
+}
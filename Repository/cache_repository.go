@@ -13,13 +13,21 @@ import (
 )
 
 type CacheRepository interface {
-	CacheTopPosts(ctx context.Context, timeRange string, posts []*model.Post) error
-	GetTopPosts(ctx context.Context, timeRange string) ([]*model.Post, error)
-	InvalidatePostRanking(ctx context.Context) error
+	CacheTopPosts(ctx context.Context, communityID uint, timeRange string, posts []*model.Post) error
+	GetTopPosts(ctx context.Context, communityID uint, timeRange string) ([]*model.Post, error)
+	InvalidatePostRanking(ctx context.Context, communityID *uint) error
 	CachePost(ctx context.Context, post *model.Post) error
 	GetPost(ctx context.Context, postID uint) (*model.Post, error)
-	InvalidateToken(ctx context.Context, token string, expiration time.Duration) error
-	IsTokenInvalid(ctx context.Context, token string) (bool, error)
+	InvalidatePost(ctx context.Context, postID uint) error
+	RevokeSession(ctx context.Context, sessionID string, expiration time.Duration) error
+	IsSessionRevoked(ctx context.Context, sessionID string) (bool, error)
+	GetTokenVersion(ctx context.Context, userID uint) (int64, error)
+	IncrementTokenVersion(ctx context.Context, userID uint) (int64, error)
+	StoreOneTimeValue(ctx context.Context, key string, value string, expiration time.Duration) error
+	ConsumeOneTimeValue(ctx context.Context, key string) (string, error)
+	AcquireThrottle(ctx context.Context, key string, window time.Duration) (bool, error)
+	IncrementCounter(ctx context.Context, key string, window time.Duration) (int64, error)
+	GetCounter(ctx context.Context, key string) (int64, error)
 }
 
 type RedisCacheRepository struct {
@@ -30,10 +38,19 @@ func NewRedisCacheRepository(client *redis.Client) RedisCacheRepository {
 	return RedisCacheRepository{client: client}
 }
 
-func (r *RedisCacheRepository) CacheTopPosts(ctx context.Context, timeRange string, posts []*model.Post) error {
+// rankingKey names the ranking of one community, or the global one when
+// communityID is 0.
+func rankingKey(communityID uint, timeRange string) string {
+	if communityID == 0 {
+		return fmt.Sprintf("posts:ranking:%s", timeRange)
+	}
+	return fmt.Sprintf("posts:ranking:c%d:%s", communityID, timeRange)
+}
+
+func (r *RedisCacheRepository) CacheTopPosts(ctx context.Context, communityID uint, timeRange string, posts []*model.Post) error {
 	pipe := r.client.TxPipeline()
 
-	rankingKey := fmt.Sprintf("posts:ranking:%s", timeRange)
+	rankingKey := rankingKey(communityID, timeRange)
 
 	postsKey := "posts:details"
 
@@ -55,8 +72,8 @@ func (r *RedisCacheRepository) CacheTopPosts(ctx context.Context, timeRange stri
 	return err
 }
 
-func (r *RedisCacheRepository) GetTopPosts(ctx context.Context, timeRange string) ([]*model.Post, error) {
-	rankingKey := fmt.Sprintf("posts:ranking:%s", timeRange)
+func (r *RedisCacheRepository) GetTopPosts(ctx context.Context, communityID uint, timeRange string) ([]*model.Post, error) {
+	rankingKey := rankingKey(communityID, timeRange)
 	postsKey := "posts:details"
 
 	ids, err := r.client.ZRevRange(ctx, rankingKey, 0, -1).Result()
@@ -80,12 +97,15 @@ func (r *RedisCacheRepository) GetTopPosts(ctx context.Context, timeRange string
 	return posts, nil
 }
 
-func (r *RedisCacheRepository) InvalidatePostRanking(ctx context.Context) error {
-
-	keys := []string{
-		"posts:ranking:day",
-		"posts:ranking:week",
-		"posts:ranking:month",
+// InvalidatePostRanking drops the global rankings, and those of the
+// community when communityID is set.
+func (r *RedisCacheRepository) InvalidatePostRanking(ctx context.Context, communityID *uint) error {
+	var keys []string
+	for _, timeRange := range []string{"day", "week", "month", "all"} {
+		keys = append(keys, rankingKey(0, timeRange))
+		if communityID != nil {
+			keys = append(keys, rankingKey(*communityID, timeRange))
+		}
 	}
 	return r.client.Del(ctx, keys...).Err()
 }
@@ -116,6 +136,10 @@ func (r *RedisCacheRepository) GetPost(ctx context.Context, postID uint) (*model
 	return &post, err
 }
 
+func (r *RedisCacheRepository) InvalidatePost(ctx context.Context, postID uint) error {
+	return r.client.HDel(ctx, "posts:details", fmt.Sprintf("%d", postID)).Err()
+}
+
 func getExpiration(timeRange string) time.Duration {
 	switch timeRange {
 	case "day":
@@ -130,13 +154,71 @@ func getExpiration(timeRange string) time.Duration {
 
 }
 
-func (r *RedisCacheRepository) InvalidateToken(ctx context.Context, token string, expiration time.Duration) error {
-	err := r.client.Set(ctx, "invalid_tokens:"+token, "1", expiration).Err()
-	log.Print(err)
+// RevokeSession rejects the access tokens of a session until they expire on
+// their own, so the expiration only needs to cover the access token lifetime.
+func (r *RedisCacheRepository) RevokeSession(ctx context.Context, sessionID string, expiration time.Duration) error {
+	err := r.client.Set(ctx, "revoked_sessions:"+sessionID, "1", expiration).Err()
+	if err != nil {
+		log.Printf("failed to revoke session %s: %v", sessionID, err)
+	}
 	return err
 }
 
-func (r *RedisCacheRepository) IsTokenInvalid(ctx context.Context, token string) (bool, error) {
-	exists, err := r.client.Exists(ctx, "invalid_tokens:"+token).Result()
+func (r *RedisCacheRepository) IsSessionRevoked(ctx context.Context, sessionID string) (bool, error) {
+	exists, err := r.client.Exists(ctx, "revoked_sessions:"+sessionID).Result()
 	return exists > 0, err
 }
+
+// GetTokenVersion returns the version every access token of the user has to
+// carry. Bumping it signs the user out everywhere.
+func (r *RedisCacheRepository) GetTokenVersion(ctx context.Context, userID uint) (int64, error) {
+	version, err := r.client.Get(ctx, fmt.Sprintf("token_version:%d", userID)).Int64()
+	if errors.Is(err, redis.Nil) {
+		return 0, nil
+	}
+	return version, err
+}
+
+func (r *RedisCacheRepository) IncrementTokenVersion(ctx context.Context, userID uint) (int64, error) {
+	return r.client.Incr(ctx, fmt.Sprintf("token_version:%d", userID)).Result()
+}
+
+func (r *RedisCacheRepository) StoreOneTimeValue(ctx context.Context, key string, value string, expiration time.Duration) error {
+	return r.client.Set(ctx, "one_time:"+key, value, expiration).Err()
+}
+
+// ConsumeOneTimeValue returns the stored value and deletes it in one step, so
+// only one caller can ever consume it. An empty string means it was missing.
+func (r *RedisCacheRepository) ConsumeOneTimeValue(ctx context.Context, key string) (string, error) {
+	value, err := r.client.GetDel(ctx, "one_time:"+key).Result()
+	if errors.Is(err, redis.Nil) {
+		return "", nil
+	}
+	return value, err
+}
+
+// AcquireThrottle reports true when no other call took the same key within
+// the window.
+func (r *RedisCacheRepository) AcquireThrottle(ctx context.Context, key string, window time.Duration) (bool, error) {
+	return r.client.SetNX(ctx, "throttle:"+key, "1", window).Result()
+}
+
+// IncrementCounter counts an event. Every increment restarts the window, so
+// the counter only drops back to zero once events stop for that long.
+func (r *RedisCacheRepository) IncrementCounter(ctx context.Context, key string, window time.Duration) (int64, error) {
+	pipe := r.client.TxPipeline()
+	count := pipe.Incr(ctx, "counter:"+key)
+	pipe.Expire(ctx, "counter:"+key, window)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, err
+	}
+	return count.Val(), nil
+}
+
+func (r *RedisCacheRepository) GetCounter(ctx context.Context, key string) (int64, error) {
+	count, err := r.client.Get(ctx, "counter:"+key).Int64()
+	if errors.Is(err, redis.Nil) {
+		return 0, nil
+	}
+	return count, err
+}
@@ -0,0 +1,220 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"redditBack/model"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ErrParentCommentRemoved is returned by Create when the parent was removed
+// after the caller loaded it.
+var ErrParentCommentRemoved = errors.New("parent comment was removed")
+
+type CommentRepository interface {
+	Create(ctx context.Context, comment *model.Comment, parent *model.Comment) error
+	FindByID(ctx context.Context, id uint) (*model.Comment, error)
+	ListThread(ctx context.Context, postID uint, pathPrefix string, after string, maxDepth int, limit int) ([]*model.Comment, error)
+	UpdateContent(ctx context.Context, id uint, content string) error
+	UpdateScore(ctx context.Context, commentID uint, scoreDelta int) error
+	Delete(ctx context.Context, id uint) error
+}
+
+type CommentRepositoryImpl struct {
+	db *gorm.DB
+}
+
+func NewCommentRepository(db *gorm.DB) CommentRepositoryImpl {
+	return CommentRepositoryImpl{db: db}
+}
+
+// commentPathSegment is the part of a materialized path a comment adds. The
+// fixed width keeps paths in thread order under any collation.
+func commentPathSegment(id uint) string {
+	return fmt.Sprintf("%012d/", id)
+}
+
+// withCommentAuthor selects the author's username next to each comment, like
+// withAuthor does for posts.
+func withCommentAuthor(db *gorm.DB) *gorm.DB {
+	return db.
+		Select("comments.*, COALESCE(users.username, ?) AS author", model.DeletedAuthor).
+		Joins("LEFT JOIN users ON users.id = comments.user_id AND users.deleted_at IS NULL")
+}
+
+// Create stores a comment below parent, or at the top level of its post when
+// parent is nil. The path needs the new id, and the reply and comment counts
+// change with it, so everything happens in one transaction. Removed comments
+// take no new replies.
+func (r *CommentRepositoryImpl) Create(ctx context.Context, comment *model.Comment, parent *model.Comment) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if parent != nil {
+			comment.ParentID = &parent.ID
+			comment.Path = parent.Path
+			comment.Depth = parent.Depth + 1
+		}
+		if err := tx.Create(comment).Error; err != nil {
+			return err
+		}
+
+		comment.Path += commentPathSegment(comment.ID)
+		if err := tx.Model(comment).Update("path", comment.Path).Error; err != nil {
+			return err
+		}
+
+		if parent != nil {
+			result := tx.Model(&model.Comment{}).
+				Where("id = ? AND removed_at IS NULL", parent.ID).
+				Update("reply_count", gorm.Expr("reply_count + 1"))
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected == 0 {
+				return ErrParentCommentRemoved
+			}
+		}
+
+		result := tx.Model(&model.Post{}).
+			Where("id = ?", comment.PostID).
+			Update("comment_count", gorm.Expr("comment_count + 1"))
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errors.New("post not found")
+		}
+		return nil
+	})
+}
+
+func (r *CommentRepositoryImpl) FindByID(ctx context.Context, id uint) (*model.Comment, error) {
+	var comment model.Comment
+	err := withCommentAuthor(r.db.WithContext(ctx)).Where("comments.id = ?", id).First(&comment).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	return &comment, err
+}
+
+// ListThread loads up to limit comments of a post in thread order, each one
+// directly after its parent. pathPrefix narrows it to one comment and its
+// replies, after continues behind the path of the last comment already
+// loaded, and maxDepth cuts off deeper replies.
+func (r *CommentRepositoryImpl) ListThread(ctx context.Context, postID uint, pathPrefix string, after string,
+	maxDepth int, limit int) ([]*model.Comment, error) {
+	var comments []*model.Comment
+
+	query := withCommentAuthor(r.db.WithContext(ctx)).
+		Where("comments.post_id = ? AND comments.depth <= ?", postID, maxDepth).
+		Order("comments.path").
+		Limit(limit)
+
+	if pathPrefix != "" {
+		query = query.Where("comments.path LIKE ?", pathPrefix+"%")
+	}
+	if after != "" {
+		query = query.Where("comments.path > ?", after)
+	}
+
+	if err := query.Find(&comments).Error; err != nil {
+		return nil, err
+	}
+	return comments, nil
+}
+
+func (r *CommentRepositoryImpl) UpdateContent(ctx context.Context, id uint, content string) error {
+	result := r.db.WithContext(ctx).
+		Model(&model.Comment{}).
+		Where("id = ? AND removed_at IS NULL", id).
+		Update("content", content)
+
+	if result.Error != nil {
+		return result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		return errors.New("comment not found")
+	}
+
+	return nil
+}
+
+func (r *CommentRepositoryImpl) UpdateScore(ctx context.Context, commentID uint, scoreDelta int) error {
+	result := r.db.WithContext(ctx).
+		Model(&model.Comment{}).
+		Where("id = ?", commentID).
+		Update("cached_score", gorm.Expr("cached_score + ?", scoreDelta))
+
+	if result.Error != nil {
+		return result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		return errors.New("comment not found")
+	}
+
+	return nil
+}
+
+// Delete removes a comment. One with replies is blanked out and kept so the
+// replies keep their place; one without is deleted, together with any
+// blanked out ancestors it was the last reply of.
+func (r *CommentRepositoryImpl) Delete(ctx context.Context, id uint) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var comment model.Comment
+		err := tx.Where("id = ? AND removed_at IS NULL", id).First(&comment).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return errors.New("comment not found")
+		}
+		if err != nil {
+			return err
+		}
+
+		if comment.ReplyCount > 0 {
+			err = tx.Model(&comment).Updates(map[string]interface{}{
+				"content":    model.DeletedComment,
+				"user_id":    nil,
+				"removed_at": time.Now(),
+			}).Error
+		} else {
+			err = r.deleteLeaf(tx, &comment)
+		}
+		if err != nil {
+			return err
+		}
+
+		return tx.Model(&model.Post{}).
+			Where("id = ? AND comment_count > 0", comment.PostID).
+			Update("comment_count", gorm.Expr("comment_count - 1")).Error
+	})
+}
+
+func (r *CommentRepositoryImpl) deleteLeaf(tx *gorm.DB, comment *model.Comment) error {
+	for {
+		if err := tx.Delete(&model.Comment{}, comment.ID).Error; err != nil {
+			return err
+		}
+		if comment.ParentID == nil {
+			return nil
+		}
+
+		err := tx.Model(&model.Comment{}).
+			Where("id = ? AND reply_count > 0", *comment.ParentID).
+			Update("reply_count", gorm.Expr("reply_count - 1")).Error
+		if err != nil {
+			return err
+		}
+
+		var parent model.Comment
+		if err := tx.First(&parent, *comment.ParentID).Error; err != nil {
+			return err
+		}
+		if parent.RemovedAt == nil || parent.ReplyCount > 0 {
+			return nil
+		}
+		comment = &parent
+	}
+}
@@ -0,0 +1,236 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"os"
+	"redditBack/model"
+	"testing"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// openTestDB connects to the database in TEST_DATABASE_DSN and returns a
+// transaction that is rolled back when the test ends. Tests are skipped when
+// no database is configured.
+func openTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	dsn := os.Getenv("TEST_DATABASE_DSN")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_DSN is not set")
+	}
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&model.User{}, &model.Post{}, &model.Vote{}, &model.Comment{}); err != nil {
+		t.Fatal(err)
+	}
+	tx := db.Begin()
+	if tx.Error != nil {
+		t.Fatal(tx.Error)
+	}
+	t.Cleanup(func() { tx.Rollback() })
+	return tx
+}
+
+type commentFixture struct {
+	t    *testing.T
+	db   *gorm.DB
+	repo CommentRepositoryImpl
+	post *model.Post
+}
+
+func newCommentFixture(t *testing.T) *commentFixture {
+	db := openTestDB(t)
+	post := &model.Post{Title: "title", Content: "content"}
+	if err := db.Create(post).Error; err != nil {
+		t.Fatal(err)
+	}
+	return &commentFixture{t: t, db: db, repo: NewCommentRepository(db), post: post}
+}
+
+func (f *commentFixture) reply(parent *model.Comment, content string) *model.Comment {
+	f.t.Helper()
+	comment := &model.Comment{PostID: f.post.ID, Content: content}
+	if err := f.repo.Create(context.Background(), comment, parent); err != nil {
+		f.t.Fatalf("Create(%q): %v", content, err)
+	}
+	return comment
+}
+
+func (f *commentFixture) find(comment *model.Comment) *model.Comment {
+	f.t.Helper()
+	found, err := f.repo.FindByID(context.Background(), comment.ID)
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	return found
+}
+
+func (f *commentFixture) commentCount() int {
+	f.t.Helper()
+	var post model.Post
+	if err := f.db.First(&post, f.post.ID).Error; err != nil {
+		f.t.Fatal(err)
+	}
+	return post.CommentCount
+}
+
+func (f *commentFixture) list(pathPrefix, after string, maxDepth, limit int) []string {
+	f.t.Helper()
+	comments, err := f.repo.ListThread(context.Background(), f.post.ID, pathPrefix, after, maxDepth, limit)
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	contents := make([]string, len(comments))
+	for i, comment := range comments {
+		contents[i] = comment.Content
+	}
+	return contents
+}
+
+func equalContents(got, want []string) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestCommentRepositoryListThread(t *testing.T) {
+	f := newCommentFixture(t)
+	first := f.reply(nil, "1")
+	firstReply := f.reply(first, "1.1")
+	nested := f.reply(firstReply, "1.1.1")
+	second := f.reply(nil, "2")
+	f.reply(first, "1.2")
+	f.reply(second, "2.1")
+
+	tests := []struct {
+		name       string
+		pathPrefix string
+		after      string
+		maxDepth   int
+		limit      int
+		want       []string
+	}{
+		{"whole tree in thread order", "", "", 10, 100, []string{"1", "1.1", "1.1.1", "1.2", "2", "2.1"}},
+		{"top level only", "", "", 0, 100, []string{"1", "2"}},
+		{"depth limit", "", "", 1, 100, []string{"1", "1.1", "1.2", "2", "2.1"}},
+		{"first page", "", "", 10, 3, []string{"1", "1.1", "1.1.1"}},
+		{"continuation", "", f.find(nested).Path, 10, 3, []string{"1.2", "2", "2.1"}},
+		{"one thread", f.find(first).Path, "", 10, 100, []string{"1", "1.1", "1.1.1", "1.2"}},
+		{"one thread after a reply", f.find(first).Path, f.find(firstReply).Path, 10, 100, []string{"1.1.1", "1.2"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := f.list(tt.pathPrefix, tt.after, tt.maxDepth, tt.limit); !equalContents(got, tt.want) {
+				t.Errorf("ListThread = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCommentRepositoryContinuationAfterLastPath(t *testing.T) {
+	f := newCommentFixture(t)
+	first := f.reply(nil, "1")
+	f.reply(first, "1.1")
+	f.reply(nil, "2")
+
+	comments, err := f.repo.ListThread(context.Background(), f.post.ID, "", "", 10, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(comments) != 2 {
+		t.Fatalf("first page has %d comments, want 2", len(comments))
+	}
+	if got := f.list("", comments[1].Path, 10, 2); !equalContents(got, []string{"2"}) {
+		t.Errorf("second page = %v, want [2]", got)
+	}
+}
+
+func TestCommentRepositoryCreateSetsPathAndCounts(t *testing.T) {
+	f := newCommentFixture(t)
+	parent := f.reply(nil, "parent")
+	child := f.reply(parent, "child")
+
+	if parent.Path != commentPathSegment(parent.ID) || parent.Depth != 0 {
+		t.Errorf("parent path %q at depth %d", parent.Path, parent.Depth)
+	}
+	if child.Path != parent.Path+commentPathSegment(child.ID) || child.Depth != 1 {
+		t.Errorf("child path %q at depth %d", child.Path, child.Depth)
+	}
+	if got := f.find(parent).ReplyCount; got != 1 {
+		t.Errorf("parent reply count = %d, want 1", got)
+	}
+	if got := f.commentCount(); got != 2 {
+		t.Errorf("comment count = %d, want 2", got)
+	}
+}
+
+func TestCommentRepositoryDelete(t *testing.T) {
+	f := newCommentFixture(t)
+	ctx := context.Background()
+	parent := f.reply(nil, "parent")
+	child := f.reply(parent, "child")
+	grandchild := f.reply(child, "grandchild")
+	sibling := f.reply(parent, "sibling")
+
+	// A comment with replies is blanked out and stays in the thread.
+	if err := f.repo.Delete(ctx, child.ID); err != nil {
+		t.Fatal(err)
+	}
+	removed := f.find(child)
+	if removed == nil || removed.RemovedAt == nil || removed.Content != model.DeletedComment {
+		t.Fatalf("child after delete = %+v, want a tombstone", removed)
+	}
+	if got := f.commentCount(); got != 3 {
+		t.Errorf("comment count after tombstone = %d, want 3", got)
+	}
+
+	if err := f.repo.Delete(ctx, child.ID); err == nil {
+		t.Error("deleting a removed comment again succeeded")
+	}
+	if err := f.repo.Create(ctx, &model.Comment{PostID: f.post.ID, Content: "late"}, f.find(child)); !errors.Is(err, ErrParentCommentRemoved) {
+		t.Errorf("reply to removed comment error = %v, want ErrParentCommentRemoved", err)
+	}
+	if got := f.commentCount(); got != 3 {
+		t.Errorf("comment count after refused reply = %d, want 3", got)
+	}
+
+	// Deleting the last reply of a tombstone takes the tombstone with it.
+	if err := f.repo.Delete(ctx, grandchild.ID); err != nil {
+		t.Fatal(err)
+	}
+	if f.find(grandchild) != nil || f.find(child) != nil {
+		t.Error("leaf or its removed parent still exists")
+	}
+	if got := f.find(parent).ReplyCount; got != 1 {
+		t.Errorf("parent reply count = %d, want 1", got)
+	}
+	if got := f.commentCount(); got != 2 {
+		t.Errorf("comment count after leaf delete = %d, want 2", got)
+	}
+
+	// A live parent stays when its last reply goes.
+	if err := f.repo.Delete(ctx, sibling.ID); err != nil {
+		t.Fatal(err)
+	}
+	kept := f.find(parent)
+	if kept == nil || kept.ReplyCount != 0 || kept.RemovedAt != nil {
+		t.Errorf("parent after last reply deleted = %+v", kept)
+	}
+	if got := f.commentCount(); got != 1 {
+		t.Errorf("comment count = %d, want 1", got)
+	}
+	if got := f.list("", "", 10, 100); !equalContents(got, []string{"parent"}) {
+		t.Errorf("thread = %v, want [parent]", got)
+	}
+}
@@ -0,0 +1,123 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"redditBack/model"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type CommunityRepository interface {
+	Create(ctx context.Context, community *model.Community) error
+	FindByName(ctx context.Context, name string) (*model.Community, error)
+	Update(ctx context.Context, id uint, fields map[string]interface{}) error
+	FindSubscription(ctx context.Context, userID uint, communityID uint) (*model.CommunitySubscription, error)
+	Subscribe(ctx context.Context, userID uint, communityID uint, approved bool) error
+	Unsubscribe(ctx context.Context, userID uint, communityID uint) error
+}
+
+type CommunityRepositoryImpl struct {
+	db *gorm.DB
+}
+
+func NewCommunityRepository(db *gorm.DB) CommunityRepositoryImpl {
+	return CommunityRepositoryImpl{db: db}
+}
+
+// Create stores the community with its creator as the first, approved
+// member.
+func (r *CommunityRepositoryImpl) Create(ctx context.Context, community *model.Community) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(community).Error; err != nil {
+			return err
+		}
+		if community.CreatorID == nil {
+			return nil
+		}
+		return subscribe(tx, *community.CreatorID, community.ID, true)
+	})
+}
+
+func (r *CommunityRepositoryImpl) FindByName(ctx context.Context, name string) (*model.Community, error) {
+	var community model.Community
+	err := r.db.WithContext(ctx).Where("name = ?", name).First(&community).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	return &community, err
+}
+
+func (r *CommunityRepositoryImpl) Update(ctx context.Context, id uint, fields map[string]interface{}) error {
+	result := r.db.WithContext(ctx).
+		Model(&model.Community{}).
+		Where("id = ?", id).
+		Updates(fields)
+
+	if result.Error != nil {
+		return result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		return errors.New("community not found")
+	}
+
+	return nil
+}
+
+func (r *CommunityRepositoryImpl) FindSubscription(ctx context.Context, userID uint, communityID uint) (*model.CommunitySubscription, error) {
+	var subscription model.CommunitySubscription
+	err := r.db.WithContext(ctx).
+		Where("user_id = ? AND community_id = ?", userID, communityID).
+		First(&subscription).Error
+
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	return &subscription, err
+}
+
+// Subscribe adds the user to the community. Subscribing again keeps the
+// membership, and approves it when approved is set.
+func (r *CommunityRepositoryImpl) Subscribe(ctx context.Context, userID uint, communityID uint, approved bool) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return subscribe(tx, userID, communityID, approved)
+	})
+}
+
+func subscribe(tx *gorm.DB, userID uint, communityID uint, approved bool) error {
+	if approved {
+		result := tx.Model(&model.CommunitySubscription{}).
+			Where("user_id = ? AND community_id = ?", userID, communityID).
+			Update("approved", true)
+		if result.Error != nil || result.RowsAffected > 0 {
+			return result.Error
+		}
+	}
+
+	subscription := &model.CommunitySubscription{UserID: userID, CommunityID: communityID, Approved: approved}
+	result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(subscription)
+	if result.Error != nil || result.RowsAffected == 0 {
+		return result.Error
+	}
+	return tx.Model(&model.Community{}).
+		Where("id = ?", communityID).
+		Update("subscriber_count", gorm.Expr("subscriber_count + 1")).Error
+}
+
+func (r *CommunityRepositoryImpl) Unsubscribe(ctx context.Context, userID uint, communityID uint) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("user_id = ? AND community_id = ?", userID, communityID).
+			Delete(&model.CommunitySubscription{})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errors.New("subscription not found")
+		}
+		return tx.Model(&model.Community{}).
+			Where("id = ? AND subscriber_count > 0", communityID).
+			Update("subscriber_count", gorm.Expr("subscriber_count - 1")).Error
+	})
+}
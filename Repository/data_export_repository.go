@@ -0,0 +1,85 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"redditBack/model"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type DataExportRepository interface {
+	Create(ctx context.Context, export *model.DataExport) error
+	FindByID(ctx context.Context, id string) (*model.DataExport, error)
+	MarkReady(ctx context.Context, id string, filePath string) error
+	MarkFailed(ctx context.Context, id string) error
+	ListExpired(ctx context.Context, now time.Time) ([]*model.DataExport, error)
+	Delete(ctx context.Context, id string) error
+}
+
+type DataExportRepositoryImpl struct {
+	db *gorm.DB
+}
+
+func NewDataExportRepository(db *gorm.DB) DataExportRepositoryImpl {
+	return DataExportRepositoryImpl{db: db}
+}
+
+func (r *DataExportRepositoryImpl) Create(ctx context.Context, export *model.DataExport) error {
+	return r.db.WithContext(ctx).Create(export).Error
+}
+
+func (r *DataExportRepositoryImpl) FindByID(ctx context.Context, id string) (*model.DataExport, error) {
+	var export model.DataExport
+	err := r.db.WithContext(ctx).Where("id = ?", id).First(&export).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	return &export, err
+}
+
+func (r *DataExportRepositoryImpl) MarkReady(ctx context.Context, id string, filePath string) error {
+	return r.finish(ctx, id, map[string]interface{}{
+		"status":       model.DataExportReady,
+		"file_path":    filePath,
+		"completed_at": time.Now(),
+	})
+}
+
+func (r *DataExportRepositoryImpl) MarkFailed(ctx context.Context, id string) error {
+	return r.finish(ctx, id, map[string]interface{}{
+		"status":       model.DataExportFailed,
+		"completed_at": time.Now(),
+	})
+}
+
+func (r *DataExportRepositoryImpl) finish(ctx context.Context, id string, updates map[string]interface{}) error {
+	result := r.db.WithContext(ctx).
+		Model(&model.DataExport{}).
+		Where("id = ? AND status = ?", id, model.DataExportPending).
+		Updates(updates)
+
+	if result.Error != nil {
+		return result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		return errors.New("export not found")
+	}
+
+	return nil
+}
+
+func (r *DataExportRepositoryImpl) ListExpired(ctx context.Context, now time.Time) ([]*model.DataExport, error) {
+	var exports []*model.DataExport
+	err := r.db.WithContext(ctx).Where("expires_at < ?", now).Find(&exports).Error
+	if err != nil {
+		return nil, err
+	}
+	return exports, nil
+}
+
+func (r *DataExportRepositoryImpl) Delete(ctx context.Context, id string) error {
+	return r.db.WithContext(ctx).Where("id = ?", id).Delete(&model.DataExport{}).Error
+}
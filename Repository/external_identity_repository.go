@@ -0,0 +1,42 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"redditBack/model"
+
+	"gorm.io/gorm"
+)
+
+type ExternalIdentityRepository interface {
+	Create(ctx context.Context, identity *model.ExternalIdentity) error
+	FindByProviderSubject(ctx context.Context, provider, subject string) (*model.ExternalIdentity, error)
+	DeleteForUser(ctx context.Context, userID uint) error
+}
+
+type ExternalIdentityRepositoryImpl struct {
+	db *gorm.DB
+}
+
+func NewExternalIdentityRepository(db *gorm.DB) ExternalIdentityRepositoryImpl {
+	return ExternalIdentityRepositoryImpl{db: db}
+}
+
+func (r *ExternalIdentityRepositoryImpl) Create(ctx context.Context, identity *model.ExternalIdentity) error {
+	return r.db.WithContext(ctx).Create(identity).Error
+}
+
+func (r *ExternalIdentityRepositoryImpl) FindByProviderSubject(ctx context.Context, provider, subject string) (*model.ExternalIdentity, error) {
+	var identity model.ExternalIdentity
+	err := r.db.WithContext(ctx).
+		Where("provider = ? AND subject = ?", provider, subject).
+		First(&identity).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	return &identity, err
+}
+
+func (r *ExternalIdentityRepositoryImpl) DeleteForUser(ctx context.Context, userID uint) error {
+	return r.db.WithContext(ctx).Where("user_id = ?", userID).Delete(&model.ExternalIdentity{}).Error
+}
@@ -0,0 +1,109 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"redditBack/model"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type InviteRepository interface {
+	Create(ctx context.Context, invite *model.InviteCode) error
+	FindByID(ctx context.Context, id uint) (*model.InviteCode, error)
+	ListForCreator(ctx context.Context, userID uint) ([]*model.InviteCode, error)
+	CountCreatedBy(ctx context.Context, userID uint) (int64, error)
+	Redeem(ctx context.Context, code string) (*model.InviteCode, error)
+	Release(ctx context.Context, id uint) error
+	Revoke(ctx context.Context, id uint) error
+}
+
+type InviteRepositoryImpl struct {
+	db *gorm.DB
+}
+
+func NewInviteRepository(db *gorm.DB) InviteRepositoryImpl {
+	return InviteRepositoryImpl{db: db}
+}
+
+func (r *InviteRepositoryImpl) Create(ctx context.Context, invite *model.InviteCode) error {
+	return r.db.WithContext(ctx).Create(invite).Error
+}
+
+func (r *InviteRepositoryImpl) FindByID(ctx context.Context, id uint) (*model.InviteCode, error) {
+	var invite model.InviteCode
+	err := r.db.WithContext(ctx).First(&invite, id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	return &invite, err
+}
+
+func (r *InviteRepositoryImpl) ListForCreator(ctx context.Context, userID uint) ([]*model.InviteCode, error) {
+	var invites []*model.InviteCode
+	err := r.db.WithContext(ctx).
+		Where("created_by_id = ?", userID).
+		Order("created_at DESC").
+		Find(&invites).Error
+	if err != nil {
+		return nil, err
+	}
+	return invites, nil
+}
+
+// CountCreatedBy counts revoked codes too, so revoking does not give a user
+// their quota back.
+func (r *InviteRepositoryImpl) CountCreatedBy(ctx context.Context, userID uint) (int64, error) {
+	var count int64
+	err := r.db.WithContext(ctx).Model(&model.InviteCode{}).Where("created_by_id = ?", userID).Count(&count).Error
+	return count, err
+}
+
+// Redeem takes one use of a valid code in a single update, so concurrent
+// signups cannot use a code more often than allowed. It returns nil when the
+// code is unknown, used up, expired or revoked.
+func (r *InviteRepositoryImpl) Redeem(ctx context.Context, code string) (*model.InviteCode, error) {
+	result := r.db.WithContext(ctx).
+		Model(&model.InviteCode{}).
+		Where("code = ? AND revoked_at IS NULL AND uses < max_uses AND (expires_at IS NULL OR expires_at > ?)", code, time.Now()).
+		Update("uses", gorm.Expr("uses + 1"))
+
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, nil
+	}
+
+	var invite model.InviteCode
+	if err := r.db.WithContext(ctx).Where("code = ?", code).First(&invite).Error; err != nil {
+		return nil, err
+	}
+	return &invite, nil
+}
+
+// Release gives back a use taken by Redeem when the signup failed after all.
+func (r *InviteRepositoryImpl) Release(ctx context.Context, id uint) error {
+	return r.db.WithContext(ctx).
+		Model(&model.InviteCode{}).
+		Where("id = ? AND uses > 0", id).
+		Update("uses", gorm.Expr("uses - 1")).Error
+}
+
+func (r *InviteRepositoryImpl) Revoke(ctx context.Context, id uint) error {
+	result := r.db.WithContext(ctx).
+		Model(&model.InviteCode{}).
+		Where("id = ? AND revoked_at IS NULL", id).
+		Update("revoked_at", time.Now())
+
+	if result.Error != nil {
+		return result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		return errors.New("invite not found")
+	}
+
+	return nil
+}
@@ -0,0 +1,36 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"redditBack/model"
+
+	"gorm.io/gorm"
+)
+
+type LinkPreviewRepository interface {
+	FindByURL(ctx context.Context, url string) (*model.LinkPreview, error)
+	Save(ctx context.Context, preview *model.LinkPreview) error
+}
+
+type LinkPreviewRepositoryImpl struct {
+	db *gorm.DB
+}
+
+func NewLinkPreviewRepository(db *gorm.DB) LinkPreviewRepositoryImpl {
+	return LinkPreviewRepositoryImpl{db: db}
+}
+
+func (r *LinkPreviewRepositoryImpl) FindByURL(ctx context.Context, url string) (*model.LinkPreview, error) {
+	var preview model.LinkPreview
+	err := r.db.WithContext(ctx).Where("url = ?", url).First(&preview).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	return &preview, err
+}
+
+// Save stores the preview, replacing an earlier one of the same URL.
+func (r *LinkPreviewRepositoryImpl) Save(ctx context.Context, preview *model.LinkPreview) error {
+	return r.db.WithContext(ctx).Save(preview).Error
+}
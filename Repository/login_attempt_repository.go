@@ -0,0 +1,60 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+type LoginAttemptRepository interface {
+	RecordFailure(ctx context.Context, key string, window time.Duration) (int64, error)
+	ResetFailures(ctx context.Context, key string) error
+	Block(ctx context.Context, key string, duration time.Duration) error
+	BlockedFor(ctx context.Context, key string) (time.Duration, error)
+	Unblock(ctx context.Context, key string) error
+}
+
+type RedisLoginAttemptRepository struct {
+	client *redis.Client
+}
+
+func NewRedisLoginAttemptRepository(client *redis.Client) RedisLoginAttemptRepository {
+	return RedisLoginAttemptRepository{client: client}
+}
+
+// RecordFailure counts a failed attempt. Every failure restarts the window,
+// so the counter decays once attempts stop for that long.
+func (r *RedisLoginAttemptRepository) RecordFailure(ctx context.Context, key string, window time.Duration) (int64, error) {
+	pipe := r.client.TxPipeline()
+	count := pipe.Incr(ctx, "login_failures:"+key)
+	pipe.Expire(ctx, "login_failures:"+key, window)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, err
+	}
+	return count.Val(), nil
+}
+
+func (r *RedisLoginAttemptRepository) ResetFailures(ctx context.Context, key string) error {
+	return r.client.Del(ctx, "login_failures:"+key).Err()
+}
+
+func (r *RedisLoginAttemptRepository) Block(ctx context.Context, key string, duration time.Duration) error {
+	return r.client.Set(ctx, "login_blocked:"+key, "1", duration).Err()
+}
+
+// BlockedFor returns how long the key stays blocked, zero when it is not.
+func (r *RedisLoginAttemptRepository) BlockedFor(ctx context.Context, key string) (time.Duration, error) {
+	ttl, err := r.client.PTTL(ctx, "login_blocked:"+key).Result()
+	if err != nil {
+		return 0, err
+	}
+	if ttl < 0 {
+		return 0, nil
+	}
+	return ttl, nil
+}
+
+func (r *RedisLoginAttemptRepository) Unblock(ctx context.Context, key string) error {
+	return r.client.Del(ctx, "login_blocked:"+key).Err()
+}
@@ -0,0 +1,83 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"redditBack/model"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type PersonalAccessTokenRepository interface {
+	Create(ctx context.Context, token *model.PersonalAccessToken) error
+	FindByHash(ctx context.Context, tokenHash string) (*model.PersonalAccessToken, error)
+	ListActiveForUser(ctx context.Context, userID uint) ([]*model.PersonalAccessToken, error)
+	Revoke(ctx context.Context, id uint, userID uint) error
+	TouchLastUsed(ctx context.Context, id uint) error
+	RevokeAllForUser(ctx context.Context, userID uint) error
+}
+
+type PersonalAccessTokenRepositoryImpl struct {
+	db *gorm.DB
+}
+
+func NewPersonalAccessTokenRepository(db *gorm.DB) PersonalAccessTokenRepositoryImpl {
+	return PersonalAccessTokenRepositoryImpl{db: db}
+}
+
+func (r *PersonalAccessTokenRepositoryImpl) Create(ctx context.Context, token *model.PersonalAccessToken) error {
+	return r.db.WithContext(ctx).Create(token).Error
+}
+
+func (r *PersonalAccessTokenRepositoryImpl) FindByHash(ctx context.Context, tokenHash string) (*model.PersonalAccessToken, error) {
+	var token model.PersonalAccessToken
+	err := r.db.WithContext(ctx).Where("token_hash = ?", tokenHash).First(&token).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	return &token, err
+}
+
+func (r *PersonalAccessTokenRepositoryImpl) ListActiveForUser(ctx context.Context, userID uint) ([]*model.PersonalAccessToken, error) {
+	var tokens []*model.PersonalAccessToken
+	err := r.db.WithContext(ctx).
+		Where("user_id = ? AND revoked_at IS NULL AND (expires_at IS NULL OR expires_at > ?)", userID, time.Now()).
+		Order("created_at DESC").
+		Find(&tokens).Error
+	if err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+func (r *PersonalAccessTokenRepositoryImpl) Revoke(ctx context.Context, id uint, userID uint) error {
+	result := r.db.WithContext(ctx).
+		Model(&model.PersonalAccessToken{}).
+		Where("id = ? AND user_id = ? AND revoked_at IS NULL", id, userID).
+		Update("revoked_at", time.Now())
+
+	if result.Error != nil {
+		return result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		return errors.New("token not found")
+	}
+
+	return nil
+}
+
+func (r *PersonalAccessTokenRepositoryImpl) TouchLastUsed(ctx context.Context, id uint) error {
+	return r.db.WithContext(ctx).
+		Model(&model.PersonalAccessToken{}).
+		Where("id = ?", id).
+		Update("last_used_at", time.Now()).Error
+}
+
+func (r *PersonalAccessTokenRepositoryImpl) RevokeAllForUser(ctx context.Context, userID uint) error {
+	return r.db.WithContext(ctx).
+		Model(&model.PersonalAccessToken{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Update("revoked_at", time.Now()).Error
+}
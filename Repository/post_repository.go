@@ -15,7 +15,10 @@ type PostRepository interface {
 	Update(ctx context.Context, post *model.Post) error
 	Delete(ctx context.Context, id uint) error
 	UpdateScore(ctx context.Context, postID uint, scoreDelta int) error
-	FindTopPosts(ctx context.Context, startTime time.Time) ([]*model.Post, error)
+	FindTopPosts(ctx context.Context, communityID uint, startTime time.Time) ([]*model.Post, error)
+	FindNewPosts(ctx context.Context, communityID uint, beforeID uint, limit int) ([]*model.Post, error)
+	ForEachByUser(ctx context.Context, userID uint, fn func(*model.Post) error) error
+	AuthorStats(ctx context.Context, userID uint) (postCount int64, karma int64, err error)
 }
 
 type PostRepositoryImpl struct {
@@ -30,9 +33,22 @@ func (r *PostRepositoryImpl) Create(ctx context.Context, post *model.Post) error
 	return r.db.WithContext(ctx).Create(post).Error
 }
 
+// withAuthor selects the author's username, the community name and the link
+// preview next to each post. Posts of deleted accounts are attributed to
+// model.DeletedAuthor.
+func withAuthor(db *gorm.DB) *gorm.DB {
+	return db.
+		Select("posts.*, COALESCE(users.username, ?) AS author, communities.name AS community, "+
+			"link_previews.title AS link_title, link_previews.description AS link_description, "+
+			"link_previews.image_url AS link_image_url", model.DeletedAuthor).
+		Joins("LEFT JOIN users ON users.id = posts.user_id AND users.deleted_at IS NULL").
+		Joins("LEFT JOIN communities ON communities.id = posts.community_id").
+		Joins("LEFT JOIN link_previews ON link_previews.url = posts.url")
+}
+
 func (r *PostRepositoryImpl) FindByID(ctx context.Context, id uint) (*model.Post, error) {
 	var post model.Post
-	err := r.db.WithContext(ctx).First(&post, id).Error
+	err := withAuthor(r.db.WithContext(ctx)).Where("posts.id = ?", id).First(&post).Error
 	if errors.Is(err, gorm.ErrRecordNotFound) {
 		return nil, nil
 	}
@@ -83,15 +99,20 @@ func (r *PostRepositoryImpl) UpdateScore(ctx context.Context, postID uint, score
 	return nil
 }
 
-func (r *PostRepositoryImpl) FindTopPosts(ctx context.Context, startTime time.Time) ([]*model.Post, error) {
+// FindTopPosts ranks the posts of one community, or of all of them when
+// communityID is 0.
+func (r *PostRepositoryImpl) FindTopPosts(ctx context.Context, communityID uint, startTime time.Time) ([]*model.Post, error) {
 	var posts []*model.Post
 
-	query := r.db.WithContext(ctx).
-		Order("cached_score DESC").
-		Order("created_at DESC")
+	query := withAuthor(r.db.WithContext(ctx)).
+		Order("posts.cached_score DESC").
+		Order("posts.created_at DESC")
 
+	if communityID != 0 {
+		query = query.Where("posts.community_id = ?", communityID)
+	}
 	if !startTime.IsZero() {
-		query = query.Where("created_at >= ?", startTime)
+		query = query.Where("posts.created_at >= ?", startTime)
 	}
 
 	err := query.Find(&posts).Error
@@ -101,3 +122,63 @@ func (r *PostRepositoryImpl) FindTopPosts(ctx context.Context, startTime time.Ti
 
 	return posts, nil
 }
+
+// FindNewPosts lists the newest posts of one community, or of all of them
+// when communityID is 0, continuing below beforeID when it is set.
+func (r *PostRepositoryImpl) FindNewPosts(ctx context.Context, communityID uint, beforeID uint, limit int) ([]*model.Post, error) {
+	var posts []*model.Post
+
+	query := withAuthor(r.db.WithContext(ctx)).
+		Order("posts.id DESC").
+		Limit(limit)
+
+	if communityID != 0 {
+		query = query.Where("posts.community_id = ?", communityID)
+	}
+	if beforeID != 0 {
+		query = query.Where("posts.id < ?", beforeID)
+	}
+
+	if err := query.Find(&posts).Error; err != nil {
+		return nil, err
+	}
+	return posts, nil
+}
+
+// AuthorStats returns how many posts the user wrote and their karma, the
+// summed score of their posts and comments.
+func (r *PostRepositoryImpl) AuthorStats(ctx context.Context, userID uint) (int64, int64, error) {
+	var stats struct {
+		PostCount int64
+		Karma     int64
+	}
+	commentKarma := r.db.Model(&model.Comment{}).Select("COALESCE(SUM(cached_score), 0)").Where("user_id = ?", userID)
+	err := r.db.WithContext(ctx).
+		Model(&model.Post{}).
+		Select("COUNT(*) AS post_count, COALESCE(SUM(cached_score), 0) + (?) AS karma", commentKarma).
+		Where("user_id = ?", userID).
+		Scan(&stats).Error
+	return stats.PostCount, stats.Karma, err
+}
+
+// ForEachByUser calls fn for every post of the user, reading them row by row
+// so large histories are never held in memory at once.
+func (r *PostRepositoryImpl) ForEachByUser(ctx context.Context, userID uint, fn func(*model.Post) error) error {
+	db := r.db.WithContext(ctx)
+	rows, err := db.Model(&model.Post{}).Where("user_id = ?", userID).Order("id").Rows()
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var post model.Post
+		if err := db.ScanRows(rows, &post); err != nil {
+			return err
+		}
+		if err := fn(&post); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
@@ -4,15 +4,41 @@ import (
 	"context"
 	"errors"
 	"redditBack/model"
+	"strings"
+	"time"
 
+	"github.com/jackc/pgx/v5/pgconn"
 	"gorm.io/gorm"
 )
 
+// ErrUsernameTaken is returned by Create when another account got the
+// username first, ErrEmailTaken by UpdateProfile for the email address, and
+// ErrUserNotFound by the updates when no account has the given ID.
+var (
+	ErrUsernameTaken = errors.New("username is already taken")
+	ErrEmailTaken    = errors.New("email address is already in use")
+	ErrUserNotFound  = errors.New("user not found")
+)
+
+// isUniqueViolation reports whether err is a unique violation on a
+// constraint of the given column.
+func isUniqueViolation(err error, column string) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23505" && strings.Contains(pgErr.ConstraintName, column)
+}
+
 type UserRepository interface {
 	Create(ctx context.Context, user *model.User) error
 	FindByID(ctx context.Context, id uint) (*model.User, error)
 	FindByUsername(ctx context.Context, username string) (*model.User, error)
 	FindByEmail(ctx context.Context, email string) (*model.User, error)
+	UpdatePasswordHash(ctx context.Context, id uint, hash string) error
+	MarkEmailVerified(ctx context.Context, id uint, email string) error
+	UpdateTOTP(ctx context.Context, id uint, secret string, enabled bool) error
+	UpdateRole(ctx context.Context, id uint, role string) error
+	UpdateProfile(ctx context.Context, id uint, fields map[string]interface{}) error
+	SoftDelete(ctx context.Context, id uint) error
+	PurgeDeleted(ctx context.Context, deletedBefore time.Time) (int64, error)
 }
 
 type UserRepositoryImpl struct {
@@ -24,7 +50,11 @@ func NewUserRepository(db *gorm.DB) UserRepositoryImpl {
 }
 
 func (r *UserRepositoryImpl) Create(ctx context.Context, user *model.User) error {
-	return r.db.WithContext(ctx).Create(user).Error
+	err := r.db.WithContext(ctx).Create(user).Error
+	if isUniqueViolation(err, "username") {
+		return ErrUsernameTaken
+	}
+	return err
 }
 
 func (r *UserRepositoryImpl) FindByID(ctx context.Context, id uint) (*model.User, error) {
@@ -53,3 +83,149 @@ func (r *UserRepositoryImpl) FindByEmail(ctx context.Context, email string) (*mo
 	}
 	return &user, err
 }
+
+func (r *UserRepositoryImpl) UpdatePasswordHash(ctx context.Context, id uint, hash string) error {
+	result := r.db.WithContext(ctx).
+		Model(&model.User{}).
+		Where("id = ?", id).
+		Update("password_hash", hash)
+
+	if result.Error != nil {
+		return result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		return ErrUserNotFound
+	}
+
+	return nil
+}
+
+// MarkEmailVerified only succeeds while the user still has the given email,
+// so a link sent to a previous address cannot verify a new one.
+func (r *UserRepositoryImpl) MarkEmailVerified(ctx context.Context, id uint, email string) error {
+	result := r.db.WithContext(ctx).
+		Model(&model.User{}).
+		Where("id = ? AND email = ?", id, email).
+		Updates(map[string]interface{}{
+			"email_verified":    true,
+			"email_verified_at": time.Now(),
+		})
+
+	if result.Error != nil {
+		return result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		return ErrUserNotFound
+	}
+
+	return nil
+}
+
+func (r *UserRepositoryImpl) UpdateTOTP(ctx context.Context, id uint, secret string, enabled bool) error {
+	result := r.db.WithContext(ctx).
+		Model(&model.User{}).
+		Where("id = ?", id).
+		Updates(map[string]interface{}{
+			"totp_secret":  secret,
+			"totp_enabled": enabled,
+		})
+
+	if result.Error != nil {
+		return result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		return ErrUserNotFound
+	}
+
+	return nil
+}
+
+func (r *UserRepositoryImpl) UpdateRole(ctx context.Context, id uint, role string) error {
+	result := r.db.WithContext(ctx).
+		Model(&model.User{}).
+		Where("id = ?", id).
+		Update("role", role)
+
+	if result.Error != nil {
+		return result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		return ErrUserNotFound
+	}
+
+	return nil
+}
+
+// UpdateProfile sets the given columns in one statement, e.g.
+// "display_name", "bio" or "password_hash". A new "email" is stored
+// unverified.
+func (r *UserRepositoryImpl) UpdateProfile(ctx context.Context, id uint, fields map[string]interface{}) error {
+	if _, ok := fields["email"]; ok {
+		fields["email_verified"] = false
+		fields["email_verified_at"] = nil
+	}
+	result := r.db.WithContext(ctx).
+		Model(&model.User{}).
+		Where("id = ?", id).
+		Updates(fields)
+
+	if isUniqueViolation(result.Error, "email") {
+		return ErrEmailTaken
+	}
+	if result.Error != nil {
+		return result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		return ErrUserNotFound
+	}
+
+	return nil
+}
+
+// SoftDelete scrubs the user's personal data and marks the row deleted. The
+// placeholder username and email keep the unique columns free for new
+// accounts; see model.DeletedUsername.
+func (r *UserRepositoryImpl) SoftDelete(ctx context.Context, id uint) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&model.User{}).
+			Where("id = ?", id).
+			Updates(map[string]interface{}{
+				"username":          model.DeletedUsername(id),
+				"email":             model.DeletedEmail(id),
+				"password_hash":     "",
+				"email_verified":    false,
+				"email_verified_at": nil,
+				"totp_secret":       "",
+				"totp_enabled":      false,
+				"role":              "user",
+				"display_name":      "",
+				"bio":               "",
+				"avatar_url":        "",
+			})
+
+		if result.Error != nil {
+			return result.Error
+		}
+
+		if result.RowsAffected == 0 {
+			return ErrUserNotFound
+		}
+
+		return tx.Delete(&model.User{}, id).Error
+	})
+}
+
+// PurgeDeleted removes accounts soft deleted before the given time. Their
+// posts stay and lose the author, everything else goes with the user.
+func (r *UserRepositoryImpl) PurgeDeleted(ctx context.Context, deletedBefore time.Time) (int64, error) {
+	result := r.db.WithContext(ctx).
+		Unscoped().
+		Where("deleted_at IS NOT NULL AND deleted_at < ?", deletedBefore).
+		Delete(&model.User{})
+	return result.RowsAffected, result.Error
+}
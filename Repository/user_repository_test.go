@@ -0,0 +1,132 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"redditBack/model"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+func TestUserRepositoryPurgeDeletedKeepsContent(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+	users := NewUserRepository(db)
+
+	author := &model.User{Username: "purged", Email: "purged@example.com", PasswordHash: "x"}
+	other := &model.User{Username: "other", Email: "other@example.com", PasswordHash: "x"}
+	for _, user := range []*model.User{author, other} {
+		if err := users.Create(ctx, user); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	post := &model.Post{Title: "title", Content: "content", UserID: &author.ID}
+	if err := db.Create(post).Error; err != nil {
+		t.Fatal(err)
+	}
+	otherPost := &model.Post{Title: "other", Content: "content", UserID: &other.ID}
+	if err := db.Create(otherPost).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Create(&model.Vote{UserID: author.ID, PostID: otherPost.ID, VoteValue: 1}).Error; err != nil {
+		t.Fatal(err)
+	}
+	comments := NewCommentRepository(db)
+	comment := &model.Comment{PostID: otherPost.ID, Content: "comment", UserID: &author.ID}
+	if err := comments.Create(ctx, comment, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := users.SoftDelete(ctx, author.ID); err != nil {
+		t.Fatal(err)
+	}
+	purged, err := users.PurgeDeleted(ctx, time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatalf("PurgeDeleted: %v", err)
+	}
+	if purged != 1 {
+		t.Fatalf("purged %d users, want 1", purged)
+	}
+
+	var remaining int64
+	if err := db.Unscoped().Model(&model.User{}).Where("id = ?", author.ID).Count(&remaining).Error; err != nil {
+		t.Fatal(err)
+	}
+	if remaining != 0 {
+		t.Error("purged user still exists")
+	}
+
+	var keptPost model.Post
+	if err := db.First(&keptPost, post.ID).Error; err != nil {
+		t.Fatalf("post of purged user: %v", err)
+	}
+	if keptPost.UserID != nil {
+		t.Errorf("post still belongs to user %d", *keptPost.UserID)
+	}
+
+	var votes int64
+	if err := db.Model(&model.Vote{}).Where("user_id = ?", author.ID).Count(&votes).Error; err != nil {
+		t.Fatal(err)
+	}
+	if votes != 0 {
+		t.Errorf("%d votes of the purged user are left", votes)
+	}
+
+	keptComment, err := comments.FindByID(ctx, comment.ID)
+	if err != nil || keptComment == nil {
+		t.Fatalf("comment of purged user: %v, %v", keptComment, err)
+	}
+	if keptComment.UserID != nil || keptComment.Author != model.DeletedAuthor {
+		t.Errorf("comment author = %v %q, want none", keptComment.UserID, keptComment.Author)
+	}
+
+	if found, err := users.FindByID(ctx, other.ID); err != nil || found == nil {
+		t.Errorf("other user: %v, %v", found, err)
+	}
+}
+
+func TestUserRepositoryUniqueViolations(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+	users := NewUserRepository(db)
+
+	alice := &model.User{Username: "alice", Email: "alice@example.com", PasswordHash: "x"}
+	bob := &model.User{Username: "bob", Email: "bob@example.com", PasswordHash: "x"}
+	for _, user := range []*model.User{alice, bob} {
+		if err := users.Create(ctx, user); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Each failing statement runs in a savepoint, the test transaction has
+	// to stay usable after it.
+	err := db.Transaction(func(tx *gorm.DB) error {
+		repo := NewUserRepository(tx)
+		return repo.Create(ctx, &model.User{Username: "alice", Email: "other@example.com", PasswordHash: "x"})
+	})
+	if !errors.Is(err, ErrUsernameTaken) {
+		t.Errorf("Create with a taken username = %v, want ErrUsernameTaken", err)
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		repo := NewUserRepository(tx)
+		return repo.UpdateProfile(ctx, bob.ID, map[string]interface{}{"bio": "hi", "email": "alice@example.com"})
+	})
+	if !errors.Is(err, ErrEmailTaken) {
+		t.Errorf("UpdateProfile with a taken email = %v, want ErrEmailTaken", err)
+	}
+
+	if err := users.UpdateProfile(ctx, bob.ID, map[string]interface{}{"email": "robert@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	updated, err := users.FindByID(ctx, bob.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.Email != "robert@example.com" || updated.EmailVerified || updated.Bio != "" {
+		t.Errorf("bob after update = %+v", updated)
+	}
+}
@@ -13,6 +13,11 @@ type VoteRepository interface {
 	FindByUserAndPost(ctx context.Context, userID uint, postID uint) (*model.Vote, error)
 	Update(ctx context.Context, vote *model.Vote) error
 	Delete(ctx context.Context, postID uint) error
+	DeleteByUserAndPost(ctx context.Context, userID uint, postID uint) error
+	FindByUserAndComment(ctx context.Context, userID uint, commentID uint) (*model.CommentVote, error)
+	SaveCommentVote(ctx context.Context, vote *model.CommentVote) error
+	DeleteByUserAndComment(ctx context.Context, userID uint, commentID uint) error
+	ForEachByUser(ctx context.Context, userID uint, fn func(*model.Vote) error) error
 }
 
 type VoteRepositoryImp struct {
@@ -52,3 +57,53 @@ func (r *VoteRepositoryImp) Delete(ctx context.Context, postID uint) error {
 	}
 	return result.Error
 }
+
+func (r *VoteRepositoryImp) DeleteByUserAndPost(ctx context.Context, userID uint, postID uint) error {
+	return r.db.WithContext(ctx).
+		Where("user_id = ? AND post_id = ?", userID, postID).
+		Delete(&model.Vote{}).Error
+}
+
+func (r *VoteRepositoryImp) FindByUserAndComment(ctx context.Context, userID uint, commentID uint) (*model.CommentVote, error) {
+	var vote model.CommentVote
+	err := r.db.WithContext(ctx).
+		Where("user_id = ? AND comment_id = ?", userID, commentID).
+		First(&vote).Error
+
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	return &vote, err
+}
+
+// SaveCommentVote creates the vote or replaces the user's earlier one.
+func (r *VoteRepositoryImp) SaveCommentVote(ctx context.Context, vote *model.CommentVote) error {
+	return r.db.WithContext(ctx).Save(vote).Error
+}
+
+func (r *VoteRepositoryImp) DeleteByUserAndComment(ctx context.Context, userID uint, commentID uint) error {
+	return r.db.WithContext(ctx).
+		Where("user_id = ? AND comment_id = ?", userID, commentID).
+		Delete(&model.CommentVote{}).Error
+}
+
+// ForEachByUser calls fn for every vote the user cast, row by row.
+func (r *VoteRepositoryImp) ForEachByUser(ctx context.Context, userID uint, fn func(*model.Vote) error) error {
+	db := r.db.WithContext(ctx)
+	rows, err := db.Model(&model.Vote{}).Where("user_id = ?", userID).Order("post_id").Rows()
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var vote model.Vote
+		if err := db.ScanRows(rows, &vote); err != nil {
+			return err
+		}
+		if err := fn(&vote); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
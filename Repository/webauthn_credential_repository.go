@@ -0,0 +1,92 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"redditBack/model"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var ErrCredentialNotFound = errors.New("credential not found")
+
+type WebAuthnCredentialRepository interface {
+	Create(ctx context.Context, credential *model.WebAuthnCredential) error
+	FindByCredentialID(ctx context.Context, credentialID []byte) (*model.WebAuthnCredential, error)
+	ListForUser(ctx context.Context, userID uint) ([]*model.WebAuthnCredential, error)
+	CountForUser(ctx context.Context, userID uint) (int64, error)
+	RecordUse(ctx context.Context, id uint, signCount uint32) error
+	Delete(ctx context.Context, id uint, userID uint) error
+	DeleteForUser(ctx context.Context, userID uint) error
+}
+
+type WebAuthnCredentialRepositoryImpl struct {
+	db *gorm.DB
+}
+
+func NewWebAuthnCredentialRepository(db *gorm.DB) WebAuthnCredentialRepositoryImpl {
+	return WebAuthnCredentialRepositoryImpl{db: db}
+}
+
+func (r *WebAuthnCredentialRepositoryImpl) Create(ctx context.Context, credential *model.WebAuthnCredential) error {
+	return r.db.WithContext(ctx).Create(credential).Error
+}
+
+func (r *WebAuthnCredentialRepositoryImpl) FindByCredentialID(ctx context.Context, credentialID []byte) (*model.WebAuthnCredential, error) {
+	var credential model.WebAuthnCredential
+	err := r.db.WithContext(ctx).Where("credential_id = ?", credentialID).First(&credential).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	return &credential, err
+}
+
+func (r *WebAuthnCredentialRepositoryImpl) ListForUser(ctx context.Context, userID uint) ([]*model.WebAuthnCredential, error) {
+	var credentials []*model.WebAuthnCredential
+	err := r.db.WithContext(ctx).
+		Where("user_id = ?", userID).
+		Order("created_at DESC").
+		Find(&credentials).Error
+	if err != nil {
+		return nil, err
+	}
+	return credentials, nil
+}
+
+func (r *WebAuthnCredentialRepositoryImpl) CountForUser(ctx context.Context, userID uint) (int64, error) {
+	var count int64
+	err := r.db.WithContext(ctx).Model(&model.WebAuthnCredential{}).Where("user_id = ?", userID).Count(&count).Error
+	return count, err
+}
+
+// RecordUse stores the counter of a successful login together with its time.
+func (r *WebAuthnCredentialRepositoryImpl) RecordUse(ctx context.Context, id uint, signCount uint32) error {
+	return r.db.WithContext(ctx).
+		Model(&model.WebAuthnCredential{}).
+		Where("id = ?", id).
+		Updates(map[string]interface{}{
+			"sign_count":   signCount,
+			"last_used_at": time.Now(),
+		}).Error
+}
+
+func (r *WebAuthnCredentialRepositoryImpl) Delete(ctx context.Context, id uint, userID uint) error {
+	result := r.db.WithContext(ctx).
+		Where("id = ? AND user_id = ?", id, userID).
+		Delete(&model.WebAuthnCredential{})
+
+	if result.Error != nil {
+		return result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		return ErrCredentialNotFound
+	}
+
+	return nil
+}
+
+func (r *WebAuthnCredentialRepositoryImpl) DeleteForUser(ctx context.Context, userID uint) error {
+	return r.db.WithContext(ctx).Where("user_id = ?", userID).Delete(&model.WebAuthnCredential{}).Error
+}
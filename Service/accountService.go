@@ -0,0 +1,349 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/url"
+	"redditBack/model"
+	"redditBack/repository"
+	"redditBack/utility"
+	"strings"
+	"time"
+)
+
+var (
+	ErrIncorrectPassword = errors.New("current password is incorrect")
+	ErrUserNotFound      = errors.New("user not found")
+	ErrEmailTaken        = errors.New("email address is already in use")
+	ErrInvalidAvatarURL  = errors.New("avatar URL must be an http or https URL")
+	ErrReservedUsername  = errors.New("usernames starting with \"deleted-\" are reserved")
+	ErrReservedEmail     = errors.New("addresses in the .invalid domain are reserved")
+
+	ErrReauthenticationRequired = errors.New("confirm this change with your password, a two-factor code or a passkey")
+)
+
+// PublicProfile is what anyone may see about a user.
+type PublicProfile struct {
+	Username    string    `json:"username"`
+	DisplayName string    `json:"display_name"`
+	Bio         string    `json:"bio"`
+	AvatarURL   string    `json:"avatar_url"`
+	CreatedAt   time.Time `json:"created_at"`
+	PostCount   int64     `json:"post_count"`
+	Karma       int64     `json:"karma"`
+}
+
+// ProfileUpdate holds the fields of a PATCH /me request. Nil fields are left
+// unchanged. Changing the email or the password needs Reauth.
+type ProfileUpdate struct {
+	DisplayName *string
+	Bio         *string
+	AvatarURL   *string
+	Email       *string
+	NewPassword *string
+	Reauth      Reauthentication
+}
+
+// Reauthentication confirms a sensitive change with any one of the factors
+// below. Only account deletion may go without one, and only for a session
+// that signed in within the reauthentication window. ClientIP is where the
+// request came from, for the login guard.
+type Reauthentication struct {
+	Password string
+	TOTPCode string
+	Passkey  *PasskeyAssertion
+	ClientIP string
+}
+
+type AccountService struct {
+	userRepo        repository.UserRepository
+	postRepo        repository.PostRepository
+	identityRepo    repository.ExternalIdentityRepository
+	recoveryRepo    repository.RecoveryCodeRepository
+	accessTokenRepo repository.PersonalAccessTokenRepository
+	credentialRepo  repository.WebAuthnCredentialRepository
+	cacheRepo       repository.CacheRepository
+	tokens          TokenService
+	verification    VerificationService
+	mfa             MFAService
+	webAuthn        WebAuthnService
+	guard           LoginGuardService
+	hasher          utility.PasswordHasher
+	cfg             utility.AccountDeletionConfig
+}
+
+func NewAccountService(userRepo repository.UserRepository, postRepo repository.PostRepository,
+	identityRepo repository.ExternalIdentityRepository, recoveryRepo repository.RecoveryCodeRepository,
+	accessTokenRepo repository.PersonalAccessTokenRepository, credentialRepo repository.WebAuthnCredentialRepository,
+	cacheRepo repository.CacheRepository, tokens TokenService, verification VerificationService, mfa MFAService,
+	webAuthn WebAuthnService, guard LoginGuardService, hasher utility.PasswordHasher,
+	cfg utility.AccountDeletionConfig) AccountService {
+	return AccountService{
+		userRepo:        userRepo,
+		postRepo:        postRepo,
+		identityRepo:    identityRepo,
+		recoveryRepo:    recoveryRepo,
+		accessTokenRepo: accessTokenRepo,
+		credentialRepo:  credentialRepo,
+		cacheRepo:       cacheRepo,
+		tokens:          tokens,
+		verification:    verification,
+		mfa:             mfa,
+		webAuthn:        webAuthn,
+		guard:           guard,
+		hasher:          hasher,
+		cfg:             cfg,
+	}
+}
+
+func (s *AccountService) GetPublicProfile(ctx context.Context, username string) (*PublicProfile, error) {
+	user, err := s.userRepo.FindByUsername(ctx, username)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, ErrUserNotFound
+	}
+
+	postCount, karma, err := s.postRepo.AuthorStats(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &PublicProfile{
+		Username:    user.Username,
+		DisplayName: user.DisplayName,
+		Bio:         user.Bio,
+		AvatarURL:   user.AvatarURL,
+		CreatedAt:   user.CreatedAt,
+		PostCount:   postCount,
+		Karma:       karma,
+	}, nil
+}
+
+// UpdateProfile applies a PATCH /me request. A new email address has to be
+// verified again, and a new password signs out every other session and
+// revokes the user's personal access tokens.
+func (s *AccountService) UpdateProfile(ctx context.Context, principal *utility.Principal, update ProfileUpdate) (*model.User, error) {
+	user, err := s.userRepo.FindByID(ctx, principal.UserID)
+	if err != nil || user == nil {
+		return nil, errors.New("Error in username")
+	}
+
+	if update.Email != nil || update.NewPassword != nil {
+		if err := s.reauthenticate(ctx, principal, user, update.Reauth, false); err != nil {
+			return nil, err
+		}
+	}
+
+	// Everything is checked before anything is written, so a rejected
+	// request leaves the profile as it was.
+	fields := map[string]interface{}{}
+	if update.DisplayName != nil {
+		fields["display_name"] = *update.DisplayName
+	}
+	if update.Bio != nil {
+		fields["bio"] = *update.Bio
+	}
+	if update.AvatarURL != nil {
+		if *update.AvatarURL != "" {
+			parsed, err := url.Parse(*update.AvatarURL)
+			if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+				return nil, ErrInvalidAvatarURL
+			}
+		}
+		fields["avatar_url"] = *update.AvatarURL
+	}
+	if update.Email != nil && *update.Email != user.Email {
+		if model.IsReservedEmail(*update.Email) {
+			return nil, ErrReservedEmail
+		}
+		existing, err := s.userRepo.FindByEmail(ctx, *update.Email)
+		if err != nil {
+			return nil, err
+		}
+		if existing != nil {
+			return nil, ErrEmailTaken
+		}
+		fields["email"] = *update.Email
+	}
+	if update.NewPassword != nil {
+		hash, err := s.hasher.Hash(*update.NewPassword)
+		if err != nil {
+			return nil, errors.New("failed to hash password")
+		}
+		fields["password_hash"] = hash
+	}
+
+	if len(fields) > 0 {
+		err := s.userRepo.UpdateProfile(ctx, user.ID, fields)
+		if errors.Is(err, repository.ErrEmailTaken) {
+			return nil, ErrEmailTaken
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if update.NewPassword != nil {
+		if err := s.tokens.RevokeOtherSessions(ctx, principal); err != nil {
+			return nil, err
+		}
+		if err := s.accessTokenRepo.RevokeAllForUser(ctx, user.ID); err != nil {
+			return nil, err
+		}
+	}
+
+	updated, err := s.userRepo.FindByID(ctx, user.ID)
+	if err != nil || updated == nil {
+		return nil, errors.New("Error in username")
+	}
+	if updated.Email != user.Email {
+		if err := s.verification.SendVerification(ctx, updated); err != nil {
+			log.Printf("failed to send verification email to user %d: %v", updated.ID, err)
+		}
+	}
+	return updated, nil
+}
+
+// DeleteAccount signs the user out everywhere, scrubs their personal data
+// and soft deletes the account. Posts stay up under model.DeletedAuthor and
+// votes keep counting until the purge removes the account.
+func (s *AccountService) DeleteAccount(ctx context.Context, principal *utility.Principal, reauth Reauthentication) error {
+	user, err := s.userRepo.FindByID(ctx, principal.UserID)
+	if err != nil || user == nil {
+		return errors.New("Error in username")
+	}
+
+	if err := s.reauthenticate(ctx, principal, user, reauth, true); err != nil {
+		return err
+	}
+
+	if err := s.tokens.RevokeAllSessions(ctx, user.ID); err != nil {
+		return err
+	}
+	if err := s.accessTokenRepo.RevokeAllForUser(ctx, user.ID); err != nil {
+		return err
+	}
+	if err := s.identityRepo.DeleteForUser(ctx, user.ID); err != nil {
+		return err
+	}
+	if err := s.recoveryRepo.DeleteForUser(ctx, user.ID); err != nil {
+		return err
+	}
+	if err := s.credentialRepo.DeleteForUser(ctx, user.ID); err != nil {
+		return err
+	}
+	if err := s.userRepo.SoftDelete(ctx, user.ID); err != nil {
+		return err
+	}
+
+	// Cached posts and listings still carry the old author name.
+	communities := map[uint]bool{}
+	err = s.postRepo.ForEachByUser(ctx, user.ID, func(post *model.Post) error {
+		if post.CommunityID != nil {
+			communities[*post.CommunityID] = true
+		}
+		return s.cacheRepo.InvalidatePost(ctx, post.ID)
+	})
+	if err != nil {
+		log.Printf("failed to invalidate cached posts of user %d: %v", user.ID, err)
+	}
+	if err := s.cacheRepo.InvalidatePostRanking(ctx, nil); err != nil {
+		log.Printf("failed to invalidate post ranking: %v", err)
+	}
+	for communityID := range communities {
+		if err := s.cacheRepo.InvalidatePostRanking(ctx, &communityID); err != nil {
+			log.Printf("failed to invalidate ranking of community %d: %v", communityID, err)
+		}
+	}
+	log.Printf("user %d deleted their account", user.ID)
+	return nil
+}
+
+// reauthenticate checks the factor given in reauth. Without one it only
+// passes when allowRecentSession is set and the session signed in within the
+// reauthentication window; email and password changes never allow that, so a
+// stolen token cannot take the account over. Password and TOTP attempts count
+// against the login guard like logins do. Accounts created through an
+// identity provider have no usable password, so they rely on the other
+// factors.
+func (s *AccountService) reauthenticate(ctx context.Context, principal *utility.Principal, user *model.User,
+	reauth Reauthentication, allowRecentSession bool) error {
+	switch {
+	case reauth.Password != "":
+		if err := s.guard.Check(ctx, user.Username, reauth.ClientIP); err != nil {
+			return err
+		}
+		match, _, err := s.hasher.Verify(user.PasswordHash, reauth.Password)
+		if err != nil {
+			return err
+		}
+		if !match {
+			s.guard.RecordFailure(ctx, user.Username, reauth.ClientIP)
+			return ErrIncorrectPassword
+		}
+		s.guard.RecordSuccess(ctx, user.Username)
+	case reauth.TOTPCode != "":
+		if !user.TOTPEnabled {
+			return ErrMFANotEnabled
+		}
+		if err := s.guard.CheckSecondFactor(ctx, user.Username, reauth.ClientIP); err != nil {
+			return err
+		}
+		ok, err := s.mfa.checkTOTP(ctx, user, strings.TrimSpace(reauth.TOTPCode))
+		if err != nil {
+			return err
+		}
+		if !ok {
+			s.guard.RecordSecondFactorFailure(ctx, user.Username, reauth.ClientIP)
+			return ErrInvalidMFACode
+		}
+		s.guard.RecordSecondFactorSuccess(ctx, user.Username)
+	case reauth.Passkey != nil:
+		return s.webAuthn.VerifyReauthentication(ctx, principal, *reauth.Passkey)
+	default:
+		if !allowRecentSession {
+			return ErrReauthenticationRequired
+		}
+		recent, err := s.tokens.RecentlyAuthenticated(ctx, principal)
+		if err != nil {
+			return err
+		}
+		if !recent {
+			return ErrReauthenticationRequired
+		}
+	}
+	return nil
+}
+
+// StartPurge removes deleted accounts once their retention period is over,
+// checking every interval until ctx is cancelled.
+func (s *AccountService) StartPurge(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				s.purgeDeleted(ctx)
+			}
+		}
+	}()
+}
+
+func (s *AccountService) purgeDeleted(ctx context.Context) {
+	purged, err := s.userRepo.PurgeDeleted(ctx, time.Now().Add(-s.cfg.RetentionPeriod))
+	if err != nil {
+		log.Printf("failed to purge deleted accounts: %v", err)
+		return
+	}
+	if purged > 0 {
+		log.Printf("purged %d deleted accounts", purged)
+	}
+}
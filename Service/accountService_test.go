@@ -0,0 +1,169 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"redditBack/model"
+	"redditBack/utility"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestAccountReauthenticate(t *testing.T) {
+	hasher := utility.NewBcryptHasher(bcrypt.MinCost)
+	hash, err := hasher.Hash("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := &model.User{ID: 1, Username: "alice", Email: "alice@example.com", PasswordHash: hash}
+	sessions := newFakeSessionRepo(
+		&model.Session{ID: "fresh", UserID: 1, CreatedAt: time.Now().Add(-time.Minute)},
+		&model.Session{ID: "old", UserID: 1, CreatedAt: time.Now().Add(-time.Hour)},
+		&model.Session{ID: "other", UserID: 2, CreatedAt: time.Now()},
+	)
+	tokens := NewTokenService(nil, sessions, nil, nil, nil, utility.TokenConfig{ReauthWindow: 10 * time.Minute})
+	guard := NewLoginGuardService(newFakeLoginAttemptRepo(), nil, utility.LoginProtectionConfig{})
+	service := NewAccountService(nil, nil, nil, nil, nil, nil, nil, tokens, VerificationService{}, MFAService{},
+		WebAuthnService{}, guard, hasher, utility.AccountDeletionConfig{})
+
+	tests := []struct {
+		name               string
+		sessionID          string
+		reauth             Reauthentication
+		allowRecentSession bool
+		wantErr            error
+	}{
+		{"right password", "old", Reauthentication{Password: "secret"}, false, nil},
+		{"wrong password", "fresh", Reauthentication{Password: "guess"}, true, ErrIncorrectPassword},
+		{"code without two-factor", "old", Reauthentication{TOTPCode: "123456"}, false, ErrMFANotEnabled},
+		{"fresh session", "fresh", Reauthentication{}, true, nil},
+		{"fresh session without a factor for a sensitive change", "fresh", Reauthentication{}, false, ErrReauthenticationRequired},
+		{"old session", "old", Reauthentication{}, true, ErrReauthenticationRequired},
+		{"session of another user", "other", Reauthentication{}, true, ErrReauthenticationRequired},
+		{"personal access token", "", Reauthentication{}, true, ErrReauthenticationRequired},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			principal := &utility.Principal{UserID: user.ID, SessionID: tt.sessionID}
+			err := service.reauthenticate(context.Background(), principal, user, tt.reauth, tt.allowRecentSession)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("reauthenticate = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAccountReauthenticateIsThrottled(t *testing.T) {
+	hasher := utility.NewBcryptHasher(bcrypt.MinCost)
+	hash, err := hasher.Hash("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := &model.User{ID: 1, Username: "alice", PasswordHash: hash}
+	guard := NewLoginGuardService(newFakeLoginAttemptRepo(), nil, utility.LoginProtectionConfig{
+		FailureWindow:    time.Hour,
+		LockoutThreshold: 3,
+		LockoutDuration:  time.Hour,
+	})
+	service := NewAccountService(nil, nil, nil, nil, nil, nil, nil, TokenService{}, VerificationService{}, MFAService{},
+		WebAuthnService{}, guard, hasher, utility.AccountDeletionConfig{})
+	principal := &utility.Principal{UserID: user.ID}
+
+	for i := 0; i < 3; i++ {
+		err := service.reauthenticate(context.Background(), principal, user,
+			Reauthentication{Password: "guess", ClientIP: "192.0.2.1"}, false)
+		if !errors.Is(err, ErrIncorrectPassword) {
+			t.Fatalf("attempt %d: reauthenticate = %v, want ErrIncorrectPassword", i+1, err)
+		}
+	}
+
+	err = service.reauthenticate(context.Background(), principal, user,
+		Reauthentication{Password: "secret", ClientIP: "192.0.2.1"}, false)
+	var blocked *LoginBlockedError
+	if !errors.As(err, &blocked) || !blocked.Locked {
+		t.Fatalf("reauthenticate after lockout = %v, want a locked account", err)
+	}
+}
+
+func TestUpdateProfileWritesNothingWhenRejected(t *testing.T) {
+	hasher := utility.NewBcryptHasher(bcrypt.MinCost)
+	hash, err := hasher.Hash("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	users := newFakeUserRepo(
+		&model.User{ID: 1, Username: "alice", Email: "alice@example.com", PasswordHash: hash, DisplayName: "Alice"},
+		&model.User{ID: 2, Username: "bob", Email: "bob@example.com"},
+	)
+	guard := NewLoginGuardService(newFakeLoginAttemptRepo(), nil, utility.LoginProtectionConfig{})
+	service := NewAccountService(users, nil, nil, nil, nil, nil, nil, TokenService{}, VerificationService{}, MFAService{},
+		WebAuthnService{}, guard, hasher, utility.AccountDeletionConfig{})
+	principal := &utility.Principal{UserID: 1}
+
+	displayName, bio := "Mallory", "new bio"
+	tests := []struct {
+		name    string
+		email   string
+		avatar  string
+		wantErr error
+	}{
+		{"reserved email", "alice@invalid", "", ErrReservedEmail},
+		{"taken email", "bob@example.com", "", ErrEmailTaken},
+		{"bad avatar", "alice@example.com", "javascript:alert(1)", ErrInvalidAvatarURL},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			email, avatar := tt.email, tt.avatar
+			update := ProfileUpdate{
+				DisplayName: &displayName,
+				Bio:         &bio,
+				Email:       &email,
+				Reauth:      Reauthentication{Password: "secret"},
+			}
+			if avatar != "" {
+				update.AvatarURL = &avatar
+			}
+			if _, err := service.UpdateProfile(context.Background(), principal, update); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("UpdateProfile = %v, want %v", err, tt.wantErr)
+			}
+			stored, _ := users.FindByID(context.Background(), 1)
+			if stored.DisplayName != "Alice" || stored.Bio != "" || stored.Email != "alice@example.com" {
+				t.Fatalf("rejected update was written: %+v", stored)
+			}
+		})
+	}
+}
+
+func TestUpdateProfilePasswordRevokesAccessTokens(t *testing.T) {
+	hasher := utility.NewBcryptHasher(bcrypt.MinCost)
+	hash, err := hasher.Hash("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	users := newFakeUserRepo(&model.User{ID: 1, Username: "alice", Email: "alice@example.com", PasswordHash: hash})
+	sessions := newFakeSessionRepo(&model.Session{ID: "current", UserID: 1, CreatedAt: time.Now()})
+	accessTokens := &fakeAccessTokenRepo{tokens: []*model.PersonalAccessToken{
+		{ID: 1, UserID: 1, Name: "bot"},
+		{ID: 2, UserID: 2, Name: "someone else's bot"},
+	}}
+	tokens := NewTokenService(nil, sessions, users, nil, nil, utility.TokenConfig{})
+	guard := NewLoginGuardService(newFakeLoginAttemptRepo(), nil, utility.LoginProtectionConfig{})
+	service := NewAccountService(users, nil, nil, nil, accessTokens, nil, nil, tokens, VerificationService{}, MFAService{},
+		WebAuthnService{}, guard, hasher, utility.AccountDeletionConfig{})
+
+	newPassword := "correct horse"
+	_, err = service.UpdateProfile(context.Background(), &utility.Principal{UserID: 1, SessionID: "current"},
+		ProfileUpdate{NewPassword: &newPassword, Reauth: Reauthentication{Password: "secret"}})
+	if err != nil {
+		t.Fatalf("UpdateProfile: %v", err)
+	}
+
+	if active, _ := accessTokens.ListActiveForUser(context.Background(), 1); len(active) != 0 {
+		t.Errorf("%d access tokens still active after a password change", len(active))
+	}
+	if active, _ := accessTokens.ListActiveForUser(context.Background(), 2); len(active) != 1 {
+		t.Error("another user's access token was revoked")
+	}
+}
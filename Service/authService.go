@@ -3,22 +3,67 @@ package service
 import (
 	"context"
 	"errors"
+	"log"
 	"redditBack/model"
 	"redditBack/repository"
+	"redditBack/utility"
 	"time"
 )
 
+// LoginResult carries either the authenticated user or, for users with
+// two-factor authentication, the challenge to complete with one of
+// MFAMethods: a code for MFAService or a passkey for WebAuthnService.
+type LoginResult struct {
+	User         *model.User
+	MFARequired  bool
+	MFAChallenge string
+	MFAMethods   []string
+}
+
+// Second factors a login challenge can be answered with.
+const (
+	MFAMethodTOTP    = "totp"
+	MFAMethodPasskey = "passkey"
+)
+
 type AuthService struct {
-	userRepo  repository.UserRepository
-	cacheRepo repository.CacheRepository
+	userRepo       repository.UserRepository
+	cacheRepo      repository.CacheRepository
+	credentialRepo repository.WebAuthnCredentialRepository
+	hasher         utility.PasswordHasher
+	guard          LoginGuardService
+	invites        InviteService
+	dummyHash      string
+	challengeTTL   time.Duration
 }
 
-func NewAuthService(userRepo repository.UserRepository, cacheRepo repository.CacheRepository) AuthService {
+func NewAuthService(userRepo repository.UserRepository, cacheRepo repository.CacheRepository,
+	credentialRepo repository.WebAuthnCredentialRepository, hasher utility.PasswordHasher,
+	guard LoginGuardService, invites InviteService, mfaCfg utility.MFAConfig) AuthService {
+	// Used to spend the same amount of work on unknown usernames as on real ones.
+	dummyHash, err := hasher.Hash("dummy-password-for-timing")
+	if err != nil {
+		log.Printf("failed to prepare dummy password hash: %v", err)
+	}
 	return AuthService{userRepo: userRepo,
-		cacheRepo: cacheRepo}
+		cacheRepo:      cacheRepo,
+		credentialRepo: credentialRepo,
+		hasher:         hasher,
+		guard:          guard,
+		invites:        invites,
+		dummyHash:      dummyHash,
+		challengeTTL:   mfaCfg.ChallengeTTL}
 }
 
-func (s *AuthService) Register(ctx context.Context, user *model.User) error {
+// Register creates an account. An invite code is redeemed when given, and
+// required while registration is invite only.
+func (s *AuthService) Register(ctx context.Context, user *model.User, password string, inviteCode string) error {
+	if model.IsReservedUsername(user.Username) {
+		return ErrReservedUsername
+	}
+	if model.IsReservedEmail(user.Email) {
+		return ErrReservedEmail
+	}
 
 	existingUser, err := s.userRepo.FindByUsername(ctx, user.Username)
 	if err != nil {
@@ -33,28 +78,115 @@ func (s *AuthService) Register(ctx context.Context, user *model.User) error {
 		return errors.New("username already exists")
 	}
 
-	return s.userRepo.Create(ctx, user)
+	hash, err := s.hasher.Hash(password)
+	if err != nil {
+		return errors.New("failed to hash password")
+	}
+	user.PasswordHash = hash
+
+	invite, err := s.invites.redeem(ctx, inviteCode, user)
+	if err != nil {
+		return err
+	}
+	if err := s.userRepo.Create(ctx, user); err != nil {
+		if releaseErr := s.invites.release(ctx, invite); releaseErr != nil {
+			log.Printf("failed to release invite %d: %v", invite.ID, releaseErr)
+		}
+		return err
+	}
+	return nil
 }
 
-func (s *AuthService) Login(ctx context.Context, username, password string) (*model.User, error) {
+func (s *AuthService) Login(ctx context.Context, username, password, clientIP string) (*LoginResult, error) {
+	if err := s.guard.Check(ctx, username, clientIP); err != nil {
+		return nil, err
+	}
+
 	user, err := s.userRepo.FindByUsername(ctx, username)
 	if err != nil || user == nil {
+		s.hasher.Verify(s.dummyHash, password)
+		s.guard.RecordFailure(ctx, username, clientIP)
 		return nil, errors.New("invalid credentials")
 	}
 
-	if user.PasswordHash != password {
+	match, needsRehash, err := s.hasher.Verify(user.PasswordHash, password)
+	if err != nil || !match {
+		s.guard.RecordFailure(ctx, username, clientIP)
 		return nil, errors.New("invalid credentials")
 	}
+	s.guard.RecordSuccess(ctx, username)
 
-	return user, nil
+	if needsRehash {
+		s.rehashPassword(ctx, user, password)
+	}
+
+	return s.CompleteFirstFactor(ctx, user)
+}
+
+// CompleteFirstFactor is called once a user proved who they are by any first
+// factor. Users with TOTP or a registered passkey get a challenge instead.
+func (s *AuthService) CompleteFirstFactor(ctx context.Context, user *model.User) (*LoginResult, error) {
+	return s.completeFirstFactor(ctx, user, false)
+}
+
+// CompletePasskeyFirstFactor is CompleteFirstFactor for a passkey login that
+// did not verify the user. The challenge can then only be answered with a
+// TOTP code, a passkey cannot be both factors.
+func (s *AuthService) CompletePasskeyFirstFactor(ctx context.Context, user *model.User) (*LoginResult, error) {
+	return s.completeFirstFactor(ctx, user, true)
 }
 
-func (s *AuthService) InvalidateToken(ctx context.Context, tokenString string) error {
+func (s *AuthService) completeFirstFactor(ctx context.Context, user *model.User, viaPasskey bool) (*LoginResult, error) {
+	var methods []string
+	if user.TOTPEnabled {
+		methods = append(methods, MFAMethodTOTP)
+	}
+	if !viaPasskey {
+		passkeys, err := s.credentialRepo.CountForUser(ctx, user.ID)
+		if err != nil {
+			return nil, err
+		}
+		if passkeys > 0 {
+			methods = append(methods, MFAMethodPasskey)
+		}
+	}
+
+	if len(methods) > 0 {
+		challenge, err := s.createMFAChallenge(ctx, user, viaPasskey)
+		if err != nil {
+			return nil, err
+		}
+		return &LoginResult{MFARequired: true, MFAChallenge: challenge, MFAMethods: methods}, nil
+	}
+
+	return &LoginResult{User: user}, nil
+}
 
-	expiration := 24 * time.Hour
-	return s.cacheRepo.InvalidateToken(ctx, tokenString, expiration)
+func (s *AuthService) createMFAChallenge(ctx context.Context, user *model.User, viaPasskey bool) (string, error) {
+	challenge, err := utility.GenerateOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+	state := mfaChallengeState{UserID: user.ID, ViaPasskey: viaPasskey}
+	err = s.cacheRepo.StoreOneTimeValue(ctx, mfaChallengePurpose+":"+utility.HashToken(challenge),
+		state.String(), s.challengeTTL)
+	if err != nil {
+		return "", err
+	}
+	return challenge, nil
 }
 
-func (s *AuthService) IsTokenValid(ctx context.Context, tokenString string) (bool, error) {
-	return s.cacheRepo.IsTokenInvalid(ctx, tokenString)
+// rehashPassword upgrades a stored hash to the current algorithm and
+// parameters. Failures are only logged, the login itself already succeeded.
+func (s *AuthService) rehashPassword(ctx context.Context, user *model.User, password string) {
+	hash, err := s.hasher.Hash(password)
+	if err != nil {
+		log.Printf("failed to rehash password for user %d: %v", user.ID, err)
+		return
+	}
+	if err := s.userRepo.UpdatePasswordHash(ctx, user.ID, hash); err != nil {
+		log.Printf("failed to store rehashed password for user %d: %v", user.ID, err)
+		return
+	}
+	user.PasswordHash = hash
 }
@@ -0,0 +1,222 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log"
+	"redditBack/model"
+	"redditBack/repository"
+	"redditBack/utility"
+)
+
+var (
+	ErrInvalidParentComment = errors.New("parent comment not found on this post")
+	ErrCommentTooDeep       = errors.New("comment thread is nested too deeply")
+	ErrParentCommentRemoved = errors.New("cannot reply to a removed comment")
+)
+
+// CommentThread is one page of a comment tree. Top level entries are the
+// comments whose parent is not part of the page. Next is set when the page
+// size cut the thread short; pass it back as after to continue.
+type CommentThread struct {
+	Comments []*model.Comment `json:"comments"`
+	Next     string           `json:"next,omitempty"`
+}
+
+type CommentService struct {
+	commentRepo repository.CommentRepository
+	postRepo    repository.PostRepository
+	userRepo    repository.UserRepository
+	cacheRepo   repository.CacheRepository
+	verifyCfg   utility.EmailVerificationConfig
+	cfg         utility.CommentConfig
+}
+
+func NewCommentService(commentRepo repository.CommentRepository, postRepo repository.PostRepository,
+	userRepo repository.UserRepository, cacheRepo repository.CacheRepository,
+	verifyCfg utility.EmailVerificationConfig, cfg utility.CommentConfig) CommentService {
+	return CommentService{
+		commentRepo: commentRepo,
+		postRepo:    postRepo,
+		userRepo:    userRepo,
+		cacheRepo:   cacheRepo,
+		verifyCfg:   verifyCfg,
+		cfg:         cfg,
+	}
+}
+
+// CreateComment adds a comment to a post, as a reply when parentID is set.
+func (s *CommentService) CreateComment(ctx context.Context, principal *utility.Principal, postID uint, parentID *uint,
+	content string) (*model.Comment, error) {
+	if err := requireVerifiedEmail(ctx, s.userRepo, s.verifyCfg, principal, "comment"); err != nil {
+		return nil, err
+	}
+
+	post, err := s.postRepo.FindByID(ctx, postID)
+	if err != nil {
+		return nil, err
+	}
+	if post == nil {
+		return nil, errors.New("post not found")
+	}
+
+	var parent *model.Comment
+	if parentID != nil {
+		parent, err = s.commentRepo.FindByID(ctx, *parentID)
+		if err != nil {
+			return nil, err
+		}
+		if parent == nil || parent.PostID != post.ID {
+			return nil, ErrInvalidParentComment
+		}
+		if parent.RemovedAt != nil {
+			return nil, ErrParentCommentRemoved
+		}
+		if parent.Depth+1 >= s.cfg.MaxDepth {
+			return nil, ErrCommentTooDeep
+		}
+	}
+
+	userID := principal.UserID
+	comment := &model.Comment{
+		PostID:  post.ID,
+		Content: content,
+		UserID:  &userID,
+	}
+	if err := s.commentRepo.Create(ctx, comment, parent); err != nil {
+		if errors.Is(err, repository.ErrParentCommentRemoved) {
+			return nil, ErrParentCommentRemoved
+		}
+		return nil, err
+	}
+	s.invalidatePost(ctx, post.ID)
+
+	return s.commentRepo.FindByID(ctx, comment.ID)
+}
+
+func (s *CommentService) EditComment(ctx context.Context, principal *utility.Principal, commentID uint,
+	content string) (*model.Comment, error) {
+	comment, err := s.commentRepo.FindByID(ctx, commentID)
+	if err != nil {
+		return nil, err
+	}
+	if comment == nil || comment.RemovedAt != nil {
+		return nil, errors.New("comment not found")
+	}
+
+	allowed, err := authorizeOwnerOr(ctx, s.userRepo, principal, comment.UserID, "")
+	if err != nil {
+		return nil, err
+	}
+	if !allowed {
+		return nil, ErrForbidden
+	}
+
+	if err := s.commentRepo.UpdateContent(ctx, comment.ID, content); err != nil {
+		return nil, err
+	}
+	return s.commentRepo.FindByID(ctx, comment.ID)
+}
+
+// DeleteComment removes a comment. Moderators and administrators may remove
+// any comment.
+func (s *CommentService) DeleteComment(ctx context.Context, principal *utility.Principal, commentID uint) error {
+	comment, err := s.commentRepo.FindByID(ctx, commentID)
+	if err != nil {
+		return err
+	}
+	if comment == nil || comment.RemovedAt != nil {
+		return errors.New("comment not found")
+	}
+
+	allowed, err := authorizeOwnerOr(ctx, s.userRepo, principal, comment.UserID, utility.PermissionRemoveAnyComment)
+	if err != nil {
+		return err
+	}
+	if !allowed {
+		return ErrForbidden
+	}
+	if comment.UserID == nil || *comment.UserID != principal.UserID {
+		log.Printf("user %d removed comment %d by %s", principal.UserID, comment.ID, comment.Author)
+	}
+
+	if err := s.commentRepo.Delete(ctx, comment.ID); err != nil {
+		return err
+	}
+	s.invalidatePost(ctx, comment.PostID)
+	return nil
+}
+
+// GetPostComments loads the comment tree of a post, continuing behind after
+// when it is set.
+func (s *CommentService) GetPostComments(ctx context.Context, postID uint, after string) (*CommentThread, error) {
+	post, err := s.postRepo.FindByID(ctx, postID)
+	if err != nil {
+		return nil, err
+	}
+	if post == nil {
+		return nil, errors.New("post not found")
+	}
+	return s.loadThread(ctx, post.ID, "", after, s.cfg.LoadDepth-1)
+}
+
+// GetCommentReplies loads a comment and the replies below it. This is how
+// clients load more of a thread past the depth limit.
+func (s *CommentService) GetCommentReplies(ctx context.Context, commentID uint, after string) (*CommentThread, error) {
+	comment, err := s.commentRepo.FindByID(ctx, commentID)
+	if err != nil {
+		return nil, err
+	}
+	if comment == nil {
+		return nil, errors.New("comment not found")
+	}
+	return s.loadThread(ctx, comment.PostID, comment.Path, after, comment.Depth+s.cfg.LoadDepth-1)
+}
+
+func (s *CommentService) loadThread(ctx context.Context, postID uint, pathPrefix, after string, maxDepth int) (*CommentThread, error) {
+	comments, err := s.commentRepo.ListThread(ctx, postID, pathPrefix, after, maxDepth, s.cfg.PageSize+1)
+	if err != nil {
+		return nil, err
+	}
+
+	thread := &CommentThread{}
+	if len(comments) > s.cfg.PageSize {
+		comments = comments[:s.cfg.PageSize]
+		thread.Next = comments[len(comments)-1].Path
+	}
+	thread.Comments = buildCommentTree(comments)
+	return thread, nil
+}
+
+// buildCommentTree nests comments loaded in thread order below their
+// parents. Every comment tells how many of its replies were left out, by the
+// depth limit or the page size.
+func buildCommentTree(comments []*model.Comment) []*model.Comment {
+	roots := []*model.Comment{}
+	byID := make(map[uint]*model.Comment, len(comments))
+	for _, comment := range comments {
+		byID[comment.ID] = comment
+		if comment.ParentID != nil {
+			if parent, ok := byID[*comment.ParentID]; ok {
+				parent.Replies = append(parent.Replies, comment)
+				continue
+			}
+		}
+		roots = append(roots, comment)
+	}
+
+	for _, comment := range comments {
+		if more := comment.ReplyCount - len(comment.Replies); more > 0 {
+			comment.MoreReplies = more
+		}
+	}
+	return roots
+}
+
+// invalidatePost drops the cached post, whose comment count changed. Cached
+// rankings catch up when they expire.
+func (s *CommentService) invalidatePost(ctx context.Context, postID uint) {
+	if err := s.cacheRepo.InvalidatePost(ctx, postID); err != nil {
+		log.Printf("failed to invalidate cached post %d: %v", postID, err)
+	}
+}
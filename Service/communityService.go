@@ -0,0 +1,239 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"redditBack/model"
+	"redditBack/repository"
+	"redditBack/utility"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var communityNamePattern = regexp.MustCompile(`^[a-z0-9_]{3,21}$`)
+
+var (
+	ErrCommunityNotFound     = errors.New("community not found")
+	ErrInvalidCommunityName  = errors.New("community names are 3 to 21 letters, digits or underscores")
+	ErrCommunityExists       = errors.New("community name is already taken")
+	ErrInvalidVisibility     = errors.New("visibility must be public or restricted")
+	ErrInvalidRequirement    = errors.New("posting requirements cannot be negative")
+	ErrNotApprovedMember     = errors.New("only approved members may post in this community")
+	ErrAccountTooNew         = errors.New("account is too new to post in this community")
+	ErrNotEnoughKarma        = errors.New("not enough karma to post in this community")
+	ErrSubscriptionNotFound  = errors.New("not subscribed to this community")
+	ErrCommunityUserNotFound = errors.New("user to approve not found")
+)
+
+// CommunityUpdate holds the fields of a PATCH /c/{name} request. Nil fields
+// are left unchanged.
+type CommunityUpdate struct {
+	Description       *string
+	Rules             *string
+	Visibility        *string
+	MinAccountAgeDays *int
+	MinKarma          *int
+}
+
+type CommunityService struct {
+	communityRepo repository.CommunityRepository
+	postRepo      repository.PostRepository
+	userRepo      repository.UserRepository
+	verifyCfg     utility.EmailVerificationConfig
+}
+
+func NewCommunityService(communityRepo repository.CommunityRepository, postRepo repository.PostRepository,
+	userRepo repository.UserRepository, verifyCfg utility.EmailVerificationConfig) CommunityService {
+	return CommunityService{
+		communityRepo: communityRepo,
+		postRepo:      postRepo,
+		userRepo:      userRepo,
+		verifyCfg:     verifyCfg,
+	}
+}
+
+// CreateCommunity creates a community owned by the caller, who becomes its
+// first member. Names are stored in lower case.
+func (s *CommunityService) CreateCommunity(ctx context.Context, principal *utility.Principal, community *model.Community) error {
+	if err := requireVerifiedEmail(ctx, s.userRepo, s.verifyCfg, principal, "post"); err != nil {
+		return err
+	}
+
+	community.Name = strings.ToLower(strings.TrimSpace(community.Name))
+	if !communityNamePattern.MatchString(community.Name) {
+		return ErrInvalidCommunityName
+	}
+	if community.Visibility == "" {
+		community.Visibility = model.CommunityPublic
+	}
+	if err := validateCommunitySettings(community.Visibility, community.MinAccountAgeDays, community.MinKarma); err != nil {
+		return err
+	}
+
+	existing, err := s.communityRepo.FindByName(ctx, community.Name)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		return ErrCommunityExists
+	}
+
+	userID := principal.UserID
+	community.CreatorID = &userID
+	return s.communityRepo.Create(ctx, community)
+}
+
+func (s *CommunityService) GetCommunity(ctx context.Context, name string) (*model.Community, error) {
+	community, err := s.communityRepo.FindByName(ctx, strings.ToLower(name))
+	if err != nil {
+		return nil, err
+	}
+	if community == nil {
+		return nil, ErrCommunityNotFound
+	}
+	return community, nil
+}
+
+// UpdateCommunity changes the description, rules and posting requirements.
+// Only the creator and administrators may do so.
+func (s *CommunityService) UpdateCommunity(ctx context.Context, principal *utility.Principal, name string,
+	update CommunityUpdate) (*model.Community, error) {
+	community, err := s.manageableCommunity(ctx, principal, name)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := map[string]interface{}{}
+	if update.Description != nil {
+		fields["description"] = *update.Description
+	}
+	if update.Rules != nil {
+		fields["rules"] = *update.Rules
+	}
+	if update.Visibility != nil {
+		community.Visibility = *update.Visibility
+		fields["visibility"] = *update.Visibility
+	}
+	if update.MinAccountAgeDays != nil {
+		community.MinAccountAgeDays = *update.MinAccountAgeDays
+		fields["min_account_age_days"] = *update.MinAccountAgeDays
+	}
+	if update.MinKarma != nil {
+		community.MinKarma = *update.MinKarma
+		fields["min_karma"] = *update.MinKarma
+	}
+	if err := validateCommunitySettings(community.Visibility, community.MinAccountAgeDays, community.MinKarma); err != nil {
+		return nil, err
+	}
+	if len(fields) > 0 {
+		if err := s.communityRepo.Update(ctx, community.ID, fields); err != nil {
+			return nil, err
+		}
+	}
+	return s.GetCommunity(ctx, community.Name)
+}
+
+func (s *CommunityService) Subscribe(ctx context.Context, principal *utility.Principal, name string) error {
+	community, err := s.GetCommunity(ctx, name)
+	if err != nil {
+		return err
+	}
+	return s.communityRepo.Subscribe(ctx, principal.UserID, community.ID, false)
+}
+
+func (s *CommunityService) Unsubscribe(ctx context.Context, principal *utility.Principal, name string) error {
+	community, err := s.GetCommunity(ctx, name)
+	if err != nil {
+		return err
+	}
+	err = s.communityRepo.Unsubscribe(ctx, principal.UserID, community.ID)
+	if err != nil && err.Error() == "subscription not found" {
+		return ErrSubscriptionNotFound
+	}
+	return err
+}
+
+// ApproveMember lets a user post in a restricted community, subscribing
+// them if they are not yet.
+func (s *CommunityService) ApproveMember(ctx context.Context, principal *utility.Principal, name, username string) error {
+	community, err := s.manageableCommunity(ctx, principal, name)
+	if err != nil {
+		return err
+	}
+
+	user, err := s.userRepo.FindByUsername(ctx, username)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return ErrCommunityUserNotFound
+	}
+	return s.communityRepo.Subscribe(ctx, user.ID, community.ID, true)
+}
+
+// requirePostingRights checks that the caller may post in the community:
+// approved membership for restricted communities, then the minimum account
+// age and karma. The creator is always allowed.
+func (s *CommunityService) requirePostingRights(ctx context.Context, community *model.Community, principal *utility.Principal) error {
+	if community.CreatorID != nil && *community.CreatorID == principal.UserID {
+		return nil
+	}
+
+	if community.Visibility == model.CommunityRestricted {
+		subscription, err := s.communityRepo.FindSubscription(ctx, principal.UserID, community.ID)
+		if err != nil {
+			return err
+		}
+		if subscription == nil || !subscription.Approved {
+			return ErrNotApprovedMember
+		}
+	}
+
+	if community.MinAccountAgeDays > 0 {
+		user, err := s.userRepo.FindByID(ctx, principal.UserID)
+		if err != nil || user == nil {
+			return errors.New("Error in username")
+		}
+		minAge := time.Duration(community.MinAccountAgeDays) * 24 * time.Hour
+		if time.Since(user.CreatedAt) < minAge {
+			return ErrAccountTooNew
+		}
+	}
+
+	if community.MinKarma > 0 {
+		_, karma, err := s.postRepo.AuthorStats(ctx, principal.UserID)
+		if err != nil {
+			return err
+		}
+		if karma < int64(community.MinKarma) {
+			return ErrNotEnoughKarma
+		}
+	}
+	return nil
+}
+
+func (s *CommunityService) manageableCommunity(ctx context.Context, principal *utility.Principal, name string) (*model.Community, error) {
+	community, err := s.GetCommunity(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	allowed, err := authorizeOwnerOr(ctx, s.userRepo, principal, community.CreatorID, utility.PermissionManageCommunities)
+	if err != nil {
+		return nil, err
+	}
+	if !allowed {
+		return nil, ErrForbidden
+	}
+	return community, nil
+}
+
+func validateCommunitySettings(visibility string, minAccountAgeDays, minKarma int) error {
+	if visibility != model.CommunityPublic && visibility != model.CommunityRestricted {
+		return ErrInvalidVisibility
+	}
+	if minAccountAgeDays < 0 || minKarma < 0 {
+		return ErrInvalidRequirement
+	}
+	return nil
+}
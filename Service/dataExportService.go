@@ -0,0 +1,325 @@
+package service
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"redditBack/model"
+	"redditBack/repository"
+	"redditBack/utility"
+	"time"
+)
+
+const (
+	dataExportPurpose         = "data_export"
+	dataExportCleanupInterval = time.Hour
+)
+
+var (
+	ErrExportThrottled = errors.New("an export was requested recently, try again later")
+	ErrExportNotFound  = errors.New("export not found")
+)
+
+// DataExportStatus is what a user polls while the export is built. The
+// download URL is only set once the archive is ready.
+type DataExportStatus struct {
+	*model.DataExport
+	DownloadURL string `json:"download_url,omitempty"`
+}
+
+type exportedProfile struct {
+	ID            uint      `json:"id"`
+	Username      string    `json:"username"`
+	Email         string    `json:"email"`
+	DisplayName   string    `json:"display_name"`
+	Bio           string    `json:"bio"`
+	AvatarURL     string    `json:"avatar_url"`
+	EmailVerified bool      `json:"email_verified"`
+	Role          string    `json:"role"`
+	TOTPEnabled   bool      `json:"totp_enabled"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+type exportedPost struct {
+	ID        uint      `json:"id"`
+	Title     string    `json:"title"`
+	Content   string    `json:"content"`
+	Score     int       `json:"score"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type exportedVote struct {
+	PostID    uint      `json:"post_id"`
+	Value     int       `json:"value"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type DataExportService struct {
+	exportRepo repository.DataExportRepository
+	userRepo   repository.UserRepository
+	postRepo   repository.PostRepository
+	voteRepo   repository.VoteRepository
+	cacheRepo  repository.CacheRepository
+	signer     utility.ActionTokenSigner
+	cfg        utility.DataExportConfig
+	baseURL    string
+}
+
+func NewDataExportService(exportRepo repository.DataExportRepository, userRepo repository.UserRepository,
+	postRepo repository.PostRepository, voteRepo repository.VoteRepository, cacheRepo repository.CacheRepository,
+	signer utility.ActionTokenSigner, cfg utility.DataExportConfig, baseURL string) DataExportService {
+	return DataExportService{
+		exportRepo: exportRepo,
+		userRepo:   userRepo,
+		postRepo:   postRepo,
+		voteRepo:   voteRepo,
+		cacheRepo:  cacheRepo,
+		signer:     signer,
+		cfg:        cfg,
+		baseURL:    baseURL,
+	}
+}
+
+// RequestExport records a new export and builds the archive in the
+// background. The caller polls Status until it is ready.
+func (s *DataExportService) RequestExport(ctx context.Context, principal *utility.Principal) (*model.DataExport, error) {
+	allowed, err := s.cacheRepo.AcquireThrottle(ctx, fmt.Sprintf("data_export:%d", principal.UserID), s.cfg.RequestInterval)
+	if err != nil {
+		return nil, err
+	}
+	if !allowed {
+		return nil, ErrExportThrottled
+	}
+
+	id, err := utility.GenerateOpaqueToken()
+	if err != nil {
+		return nil, err
+	}
+	export := &model.DataExport{
+		ID:        id,
+		UserID:    principal.UserID,
+		Status:    model.DataExportPending,
+		ExpiresAt: time.Now().Add(s.cfg.Retention),
+	}
+	if err := s.exportRepo.Create(ctx, export); err != nil {
+		return nil, err
+	}
+
+	go s.build(context.Background(), export)
+	return export, nil
+}
+
+func (s *DataExportService) Status(ctx context.Context, principal *utility.Principal, exportID string) (*DataExportStatus, error) {
+	export, err := s.exportRepo.FindByID(ctx, exportID)
+	if err != nil {
+		return nil, err
+	}
+	if export == nil || export.UserID != principal.UserID || time.Now().After(export.ExpiresAt) {
+		return nil, ErrExportNotFound
+	}
+
+	status := &DataExportStatus{DataExport: export}
+	if export.Status == model.DataExportReady {
+		token, err := s.signer.Sign(&utility.ActionToken{
+			Purpose:  dataExportPurpose,
+			UserID:   export.UserID,
+			Resource: export.ID,
+		}, time.Until(export.ExpiresAt))
+		if err != nil {
+			return nil, err
+		}
+		status.DownloadURL = fmt.Sprintf("%s/exports/%s/download?token=%s", s.baseURL, export.ID, url.QueryEscape(token))
+	}
+	return status, nil
+}
+
+// OpenDownload checks a download link and returns the export it points to.
+// The link works without a session so it can be opened in any browser.
+func (s *DataExportService) OpenDownload(ctx context.Context, exportID, rawToken string) (*model.DataExport, error) {
+	token, err := s.signer.Verify(dataExportPurpose, rawToken)
+	if err != nil {
+		return nil, err
+	}
+	if token.Resource != exportID {
+		return nil, utility.ErrInvalidActionToken
+	}
+
+	export, err := s.exportRepo.FindByID(ctx, exportID)
+	if err != nil {
+		return nil, err
+	}
+	if export == nil || export.UserID != token.UserID || export.Status != model.DataExportReady ||
+		time.Now().After(export.ExpiresAt) {
+		return nil, ErrExportNotFound
+	}
+	return export, nil
+}
+
+// StartCleanup deletes expired archives and their records until ctx is
+// cancelled.
+func (s *DataExportService) StartCleanup(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(dataExportCleanupInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				s.deleteExpired(ctx)
+			}
+		}
+	}()
+}
+
+func (s *DataExportService) deleteExpired(ctx context.Context) {
+	exports, err := s.exportRepo.ListExpired(ctx, time.Now())
+	if err != nil {
+		log.Printf("failed to list expired exports: %v", err)
+		return
+	}
+	for _, export := range exports {
+		if export.FilePath != "" {
+			if err := os.Remove(export.FilePath); err != nil && !errors.Is(err, os.ErrNotExist) {
+				log.Printf("failed to delete export %s: %v", export.ID, err)
+				continue
+			}
+		}
+		if err := s.exportRepo.Delete(ctx, export.ID); err != nil {
+			log.Printf("failed to delete export %s: %v", export.ID, err)
+		}
+	}
+}
+
+func (s *DataExportService) build(ctx context.Context, export *model.DataExport) {
+	path := filepath.Join(s.cfg.Dir, export.ID+".zip")
+	if err := s.writeArchive(ctx, export.UserID, path); err != nil {
+		log.Printf("data export %s for user %d failed: %v", export.ID, export.UserID, err)
+		os.Remove(path)
+		if err := s.exportRepo.MarkFailed(ctx, export.ID); err != nil {
+			log.Printf("failed to mark export %s as failed: %v", export.ID, err)
+		}
+		return
+	}
+	if err := s.exportRepo.MarkReady(ctx, export.ID, path); err != nil {
+		log.Printf("failed to mark export %s as ready: %v", export.ID, err)
+	}
+}
+
+// writeArchive streams the user's data into a ZIP file, one JSON document
+// per kind. Posts and votes are written as they are read from the database.
+func (s *DataExportService) writeArchive(ctx context.Context, userID uint, path string) error {
+	user, err := s.userRepo.FindByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return errors.New("user not found")
+	}
+
+	if err := os.MkdirAll(s.cfg.Dir, 0o700); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	archive := zip.NewWriter(file)
+
+	profile, err := archive.Create("profile.json")
+	if err != nil {
+		return err
+	}
+	err = json.NewEncoder(profile).Encode(exportedProfile{
+		ID:            user.ID,
+		Username:      user.Username,
+		Email:         user.Email,
+		DisplayName:   user.DisplayName,
+		Bio:           user.Bio,
+		AvatarURL:     user.AvatarURL,
+		EmailVerified: user.EmailVerified,
+		Role:          user.Role,
+		TOTPEnabled:   user.TOTPEnabled,
+		CreatedAt:     user.CreatedAt,
+	})
+	if err != nil {
+		return err
+	}
+
+	err = writeJSONArray(archive, "posts.json", func(emit func(interface{}) error) error {
+		return s.postRepo.ForEachByUser(ctx, userID, func(post *model.Post) error {
+			return emit(exportedPost{
+				ID:        post.ID,
+				Title:     post.Title,
+				Content:   post.Content,
+				Score:     post.CachedScore,
+				CreatedAt: post.CreatedAt,
+				UpdatedAt: post.UpdatedAt,
+			})
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	err = writeJSONArray(archive, "votes.json", func(emit func(interface{}) error) error {
+		return s.voteRepo.ForEachByUser(ctx, userID, func(vote *model.Vote) error {
+			return emit(exportedVote{
+				PostID:    vote.PostID,
+				Value:     vote.VoteValue,
+				CreatedAt: vote.CreatedAt,
+			})
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := archive.Close(); err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+// writeJSONArray adds a file to the archive holding a JSON array of every
+// value passed to emit.
+func writeJSONArray(archive *zip.Writer, name string, each func(emit func(interface{}) error) error) error {
+	w, err := archive.Create(name)
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, "["); err != nil {
+		return err
+	}
+
+	first := true
+	err = each(func(value interface{}) error {
+		data, err := json.Marshal(value)
+		if err != nil {
+			return err
+		}
+		if !first {
+			if _, err := io.WriteString(w, ","); err != nil {
+				return err
+			}
+		}
+		first = false
+		_, err = w.Write(data)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = io.WriteString(w, "]\n")
+	return err
+}
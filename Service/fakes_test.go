@@ -0,0 +1,410 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"redditBack/model"
+	"redditBack/repository"
+	"sync"
+	"time"
+)
+
+// The fakes below keep just enough state in memory for the service tests.
+// They embed the repository interface, so a test that reaches a method they
+// do not implement panics instead of silently passing.
+
+type fakeUserRepo struct {
+	repository.UserRepository
+
+	mu     sync.Mutex
+	nextID uint
+	users  map[uint]*model.User
+}
+
+func newFakeUserRepo(users ...*model.User) *fakeUserRepo {
+	repo := &fakeUserRepo{users: make(map[uint]*model.User)}
+	for _, user := range users {
+		if err := repo.Create(context.Background(), user); err != nil {
+			panic(err)
+		}
+	}
+	return repo
+}
+
+func (r *fakeUserRepo) Create(ctx context.Context, user *model.User) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, existing := range r.users {
+		if existing.Username == user.Username {
+			return repository.ErrUsernameTaken
+		}
+		if user.Email != "" && existing.Email == user.Email {
+			return errors.New("duplicate key value violates unique constraint")
+		}
+	}
+	if user.ID == 0 {
+		r.nextID++
+		user.ID = r.nextID
+	} else if user.ID > r.nextID {
+		r.nextID = user.ID
+	}
+	stored := *user
+	r.users[user.ID] = &stored
+	return nil
+}
+
+func (r *fakeUserRepo) find(match func(*model.User) bool) *model.User {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, user := range r.users {
+		if match(user) {
+			found := *user
+			return &found
+		}
+	}
+	return nil
+}
+
+func (r *fakeUserRepo) FindByID(ctx context.Context, id uint) (*model.User, error) {
+	return r.find(func(u *model.User) bool { return u.ID == id }), nil
+}
+
+func (r *fakeUserRepo) FindByUsername(ctx context.Context, username string) (*model.User, error) {
+	return r.find(func(u *model.User) bool { return u.Username == username }), nil
+}
+
+func (r *fakeUserRepo) FindByEmail(ctx context.Context, email string) (*model.User, error) {
+	return r.find(func(u *model.User) bool { return u.Email == email }), nil
+}
+
+// UpdateProfile understands the columns AccountService.UpdateProfile sets.
+func (r *fakeUserRepo) UpdateProfile(ctx context.Context, id uint, fields map[string]interface{}) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	user, ok := r.users[id]
+	if !ok {
+		return errors.New("user not found")
+	}
+	updated := *user
+	for column, value := range fields {
+		switch column {
+		case "display_name":
+			updated.DisplayName = value.(string)
+		case "bio":
+			updated.Bio = value.(string)
+		case "avatar_url":
+			updated.AvatarURL = value.(string)
+		case "email":
+			for _, existing := range r.users {
+				if existing.ID != id && existing.Email == value.(string) {
+					return repository.ErrEmailTaken
+				}
+			}
+			updated.Email = value.(string)
+			updated.EmailVerified = false
+		case "password_hash":
+			updated.PasswordHash = value.(string)
+		default:
+			return fmt.Errorf("unexpected column %q", column)
+		}
+	}
+	r.users[id] = &updated
+	return nil
+}
+
+type fakeCacheRepo struct {
+	repository.CacheRepository
+
+	mu       sync.Mutex
+	values   map[string]string
+	counters map[string]int64
+}
+
+func newFakeCacheRepo() *fakeCacheRepo {
+	return &fakeCacheRepo{values: make(map[string]string), counters: make(map[string]int64)}
+}
+
+func (r *fakeCacheRepo) StoreOneTimeValue(ctx context.Context, key string, value string, expiration time.Duration) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.values[key] = value
+	return nil
+}
+
+func (r *fakeCacheRepo) ConsumeOneTimeValue(ctx context.Context, key string) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	value := r.values[key]
+	delete(r.values, key)
+	return value, nil
+}
+
+func (r *fakeCacheRepo) AcquireThrottle(ctx context.Context, key string, window time.Duration) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, taken := r.values["throttle:"+key]; taken {
+		return false, nil
+	}
+	r.values["throttle:"+key] = "1"
+	return true, nil
+}
+
+func (r *fakeCacheRepo) IncrementCounter(ctx context.Context, key string, window time.Duration) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.counters[key]++
+	return r.counters[key], nil
+}
+
+func (r *fakeCacheRepo) GetCounter(ctx context.Context, key string) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.counters[key], nil
+}
+
+type fakeIdentityRepo struct {
+	mu         sync.Mutex
+	identities []model.ExternalIdentity
+}
+
+func (r *fakeIdentityRepo) Create(ctx context.Context, identity *model.ExternalIdentity) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, existing := range r.identities {
+		if existing.Provider == identity.Provider && existing.Subject == identity.Subject {
+			return errors.New("duplicate key value violates unique constraint")
+		}
+	}
+	identity.ID = uint(len(r.identities) + 1)
+	r.identities = append(r.identities, *identity)
+	return nil
+}
+
+func (r *fakeIdentityRepo) FindByProviderSubject(ctx context.Context, provider, subject string) (*model.ExternalIdentity, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, identity := range r.identities {
+		if identity.Provider == provider && identity.Subject == subject {
+			found := identity
+			return &found, nil
+		}
+	}
+	return nil, nil
+}
+
+func (r *fakeIdentityRepo) DeleteForUser(ctx context.Context, userID uint) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	kept := r.identities[:0]
+	for _, identity := range r.identities {
+		if identity.UserID != userID {
+			kept = append(kept, identity)
+		}
+	}
+	r.identities = kept
+	return nil
+}
+
+type fakeSessionRepo struct {
+	repository.SessionRepository
+
+	mu       sync.Mutex
+	sessions map[string]*model.Session
+}
+
+func newFakeSessionRepo(sessions ...*model.Session) *fakeSessionRepo {
+	repo := &fakeSessionRepo{sessions: make(map[string]*model.Session)}
+	for _, session := range sessions {
+		repo.sessions[session.ID] = session
+	}
+	return repo
+}
+
+func (r *fakeSessionRepo) FindByID(ctx context.Context, id string) (*model.Session, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	session, ok := r.sessions[id]
+	if !ok {
+		return nil, nil
+	}
+	found := *session
+	return &found, nil
+}
+
+func (r *fakeSessionRepo) ListActiveForUser(ctx context.Context, userID uint) ([]*model.Session, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var sessions []*model.Session
+	for _, session := range r.sessions {
+		if session.UserID == userID && session.RevokedAt == nil {
+			found := *session
+			sessions = append(sessions, &found)
+		}
+	}
+	return sessions, nil
+}
+
+type fakeAccessTokenRepo struct {
+	repository.PersonalAccessTokenRepository
+
+	mu     sync.Mutex
+	tokens []*model.PersonalAccessToken
+}
+
+func (r *fakeAccessTokenRepo) ListActiveForUser(ctx context.Context, userID uint) ([]*model.PersonalAccessToken, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var tokens []*model.PersonalAccessToken
+	for _, token := range r.tokens {
+		if token.UserID == userID && token.RevokedAt == nil {
+			found := *token
+			tokens = append(tokens, &found)
+		}
+	}
+	return tokens, nil
+}
+
+func (r *fakeAccessTokenRepo) RevokeAllForUser(ctx context.Context, userID uint) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	for _, token := range r.tokens {
+		if token.UserID == userID && token.RevokedAt == nil {
+			token.RevokedAt = &now
+		}
+	}
+	return nil
+}
+
+type fakeCredentialRepo struct {
+	mu          sync.Mutex
+	nextID      uint
+	credentials []*model.WebAuthnCredential
+}
+
+func (r *fakeCredentialRepo) Create(ctx context.Context, credential *model.WebAuthnCredential) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.nextID++
+	credential.ID = r.nextID
+	stored := *credential
+	r.credentials = append(r.credentials, &stored)
+	return nil
+}
+
+func (r *fakeCredentialRepo) FindByCredentialID(ctx context.Context, credentialID []byte) (*model.WebAuthnCredential, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, credential := range r.credentials {
+		if bytes.Equal(credential.CredentialID, credentialID) {
+			found := *credential
+			return &found, nil
+		}
+	}
+	return nil, nil
+}
+
+func (r *fakeCredentialRepo) ListForUser(ctx context.Context, userID uint) ([]*model.WebAuthnCredential, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var found []*model.WebAuthnCredential
+	for _, credential := range r.credentials {
+		if credential.UserID == userID {
+			copied := *credential
+			found = append(found, &copied)
+		}
+	}
+	return found, nil
+}
+
+func (r *fakeCredentialRepo) CountForUser(ctx context.Context, userID uint) (int64, error) {
+	credentials, err := r.ListForUser(ctx, userID)
+	return int64(len(credentials)), err
+}
+
+func (r *fakeCredentialRepo) RecordUse(ctx context.Context, id uint, signCount uint32) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, credential := range r.credentials {
+		if credential.ID == id {
+			now := time.Now()
+			credential.SignCount = signCount
+			credential.LastUsedAt = &now
+		}
+	}
+	return nil
+}
+
+func (r *fakeCredentialRepo) Delete(ctx context.Context, id uint, userID uint) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, credential := range r.credentials {
+		if credential.ID == id && credential.UserID == userID {
+			r.credentials = append(r.credentials[:i], r.credentials[i+1:]...)
+			return nil
+		}
+	}
+	return repository.ErrCredentialNotFound
+}
+
+func (r *fakeCredentialRepo) DeleteForUser(ctx context.Context, userID uint) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	kept := r.credentials[:0]
+	for _, credential := range r.credentials {
+		if credential.UserID != userID {
+			kept = append(kept, credential)
+		}
+	}
+	r.credentials = kept
+	return nil
+}
+
+type fakeLoginAttemptRepo struct {
+	mu       sync.Mutex
+	failures map[string]int64
+	blocks   map[string]time.Time
+}
+
+func newFakeLoginAttemptRepo() *fakeLoginAttemptRepo {
+	return &fakeLoginAttemptRepo{failures: make(map[string]int64), blocks: make(map[string]time.Time)}
+}
+
+func (r *fakeLoginAttemptRepo) RecordFailure(ctx context.Context, key string, window time.Duration) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.failures[key]++
+	return r.failures[key], nil
+}
+
+func (r *fakeLoginAttemptRepo) ResetFailures(ctx context.Context, key string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.failures, key)
+	return nil
+}
+
+func (r *fakeLoginAttemptRepo) Block(ctx context.Context, key string, duration time.Duration) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.blocks[key] = time.Now().Add(duration)
+	return nil
+}
+
+func (r *fakeLoginAttemptRepo) BlockedFor(ctx context.Context, key string) (time.Duration, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if remaining := time.Until(r.blocks[key]); remaining > 0 {
+		return remaining, nil
+	}
+	return 0, nil
+}
+
+func (r *fakeLoginAttemptRepo) Unblock(ctx context.Context, key string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.blocks, key)
+	return nil
+}
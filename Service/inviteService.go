@@ -0,0 +1,145 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"redditBack/model"
+	"redditBack/repository"
+	"redditBack/utility"
+	"strings"
+	"time"
+)
+
+// inviteCodeLength keeps codes short enough to type while leaving 96 bits of
+// the random token.
+const inviteCodeLength = 16
+
+var (
+	ErrInviteRequired      = errors.New("an invite code is required to sign up")
+	ErrInvalidInvite       = errors.New("invalid, used up or expired invite code")
+	ErrInviteQuotaExceeded = errors.New("invite quota exceeded")
+	ErrInvalidInviteUses   = errors.New("max uses must be at least 1")
+)
+
+type InviteService struct {
+	inviteRepo repository.InviteRepository
+	userRepo   repository.UserRepository
+	cfg        utility.InviteConfig
+}
+
+func NewInviteService(inviteRepo repository.InviteRepository, userRepo repository.UserRepository,
+	cfg utility.InviteConfig) InviteService {
+	return InviteService{
+		inviteRepo: inviteRepo,
+		userRepo:   userRepo,
+		cfg:        cfg,
+	}
+}
+
+// Required reports whether new accounts need an invite code.
+func (s *InviteService) Required() bool {
+	return s.cfg.Required
+}
+
+// Create issues a new code. Administrators choose the number of uses and the
+// lifetime freely; everyone else gets single use codes with the configured
+// lifetime, up to their quota.
+func (s *InviteService) Create(ctx context.Context, principal *utility.Principal, maxUses int, ttl time.Duration) (*model.InviteCode, error) {
+	user, err := s.userRepo.FindByID(ctx, principal.UserID)
+	if err != nil || user == nil {
+		return nil, errors.New("Error in username")
+	}
+
+	if utility.UserCan(user, utility.PermissionManageInvites) {
+		if maxUses == 0 {
+			maxUses = 1
+		}
+		if maxUses < 0 {
+			return nil, ErrInvalidInviteUses
+		}
+		if ttl <= 0 {
+			ttl = s.cfg.TTL
+		}
+	} else {
+		created, err := s.inviteRepo.CountCreatedBy(ctx, user.ID)
+		if err != nil {
+			return nil, err
+		}
+		if created >= int64(s.cfg.UserQuota) {
+			return nil, ErrInviteQuotaExceeded
+		}
+		maxUses, ttl = 1, s.cfg.TTL
+	}
+
+	token, err := utility.GenerateOpaqueToken()
+	if err != nil {
+		return nil, err
+	}
+	expiresAt := time.Now().Add(ttl)
+	invite := &model.InviteCode{
+		Code:        token[:inviteCodeLength],
+		CreatedByID: user.ID,
+		MaxUses:     maxUses,
+		ExpiresAt:   &expiresAt,
+	}
+	if err := s.inviteRepo.Create(ctx, invite); err != nil {
+		return nil, err
+	}
+	return invite, nil
+}
+
+func (s *InviteService) List(ctx context.Context, principal *utility.Principal) ([]*model.InviteCode, error) {
+	return s.inviteRepo.ListForCreator(ctx, principal.UserID)
+}
+
+// Revoke stops a code from being used again. Administrators may revoke any
+// code, users their own.
+func (s *InviteService) Revoke(ctx context.Context, principal *utility.Principal, id uint) error {
+	invite, err := s.inviteRepo.FindByID(ctx, id)
+	if err != nil {
+		return err
+	}
+	if invite == nil {
+		return errors.New("invite not found")
+	}
+
+	allowed, err := authorizeOwnerOr(ctx, s.userRepo, principal, &invite.CreatedByID, utility.PermissionManageInvites)
+	if err != nil {
+		return err
+	}
+	if !allowed {
+		return ErrForbidden
+	}
+	return s.inviteRepo.Revoke(ctx, invite.ID)
+}
+
+// redeem takes one use of code for a new account and records the invitation
+// on the user. Without a code it only fails when invites are required.
+func (s *InviteService) redeem(ctx context.Context, code string, user *model.User) (*model.InviteCode, error) {
+	code = strings.TrimSpace(code)
+	if code == "" {
+		if s.cfg.Required {
+			return nil, ErrInviteRequired
+		}
+		return nil, nil
+	}
+
+	invite, err := s.inviteRepo.Redeem(ctx, code)
+	if err != nil {
+		return nil, err
+	}
+	if invite == nil {
+		return nil, ErrInvalidInvite
+	}
+	user.InvitedByID = &invite.CreatedByID
+	user.InviteCodeID = &invite.ID
+	return invite, nil
+}
+
+// release undoes redeem when the account could not be created after all.
+func (s *InviteService) release(ctx context.Context, invite *model.InviteCode) error {
+	if invite == nil {
+		return nil
+	}
+	return s.inviteRepo.Release(ctx, invite.ID)
+}
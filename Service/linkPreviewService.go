@@ -0,0 +1,99 @@
+package service
+
+import (
+	"context"
+	"log"
+	"redditBack/model"
+	"redditBack/repository"
+	"redditBack/utility"
+	"time"
+)
+
+type linkPreviewJob struct {
+	postID uint
+	url    string
+}
+
+// LinkPreviewService unfurls the links of new posts in the background. The
+// metadata is stored per normalised URL and reused until it expires, so a
+// popular link is fetched once however often it is posted.
+type LinkPreviewService struct {
+	previewRepo repository.LinkPreviewRepository
+	cacheRepo   repository.CacheRepository
+	unfurler    *utility.Unfurler
+	cfg         utility.UnfurlConfig
+	jobs        chan linkPreviewJob
+}
+
+func NewLinkPreviewService(previewRepo repository.LinkPreviewRepository, cacheRepo repository.CacheRepository,
+	unfurler *utility.Unfurler, cfg utility.UnfurlConfig) LinkPreviewService {
+	return LinkPreviewService{
+		previewRepo: previewRepo,
+		cacheRepo:   cacheRepo,
+		unfurler:    unfurler,
+		cfg:         cfg,
+		jobs:        make(chan linkPreviewJob, cfg.QueueSize),
+	}
+}
+
+// Enqueue schedules the preview of a new link post. While the queue is full
+// posts go without a preview rather than holding up the request.
+func (s *LinkPreviewService) Enqueue(postID uint, url string) {
+	select {
+	case s.jobs <- linkPreviewJob{postID: postID, url: url}:
+	default:
+		log.Printf("link preview queue is full, skipping post %d", postID)
+	}
+}
+
+// Start runs the configured number of workers until ctx is cancelled.
+func (s *LinkPreviewService) Start(ctx context.Context) {
+	for i := 0; i < s.cfg.Workers; i++ {
+		go func() {
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case job := <-s.jobs:
+					s.process(ctx, job)
+				}
+			}
+		}()
+	}
+}
+
+func (s *LinkPreviewService) process(ctx context.Context, job linkPreviewJob) {
+	preview, err := s.previewRepo.FindByURL(ctx, job.url)
+	if err != nil {
+		log.Printf("failed to load link preview of post %d: %v", job.postID, err)
+		return
+	}
+	if preview == nil || time.Since(preview.FetchedAt) > s.cfg.CacheTTL {
+		s.refresh(ctx, job.url)
+	}
+
+	// The post may have been cached before its preview existed.
+	if err := s.cacheRepo.InvalidatePost(ctx, job.postID); err != nil {
+		log.Printf("failed to invalidate cached post %d: %v", job.postID, err)
+	}
+}
+
+// refresh fetches and stores the metadata of url. Failures are stored too,
+// so a dead or blocked link is not fetched again until the entry expires.
+func (s *LinkPreviewService) refresh(ctx context.Context, url string) {
+	preview := &model.LinkPreview{URL: url, FetchedAt: time.Now()}
+
+	metadata, err := s.unfurler.Unfurl(ctx, url)
+	if err != nil {
+		log.Printf("failed to unfurl %s: %v", url, err)
+		preview.Error = err.Error()
+	} else {
+		preview.Title = metadata.Title
+		preview.Description = metadata.Description
+		preview.ImageURL = metadata.ImageURL
+	}
+
+	if err := s.previewRepo.Save(ctx, preview); err != nil {
+		log.Printf("failed to store link preview of %s: %v", url, err)
+	}
+}
@@ -0,0 +1,183 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"redditBack/repository"
+	"redditBack/utility"
+	"strings"
+	"time"
+)
+
+// LoginBlockedError is returned while a login attempt is refused without
+// even checking the password.
+type LoginBlockedError struct {
+	Locked     bool
+	RetryAfter time.Duration
+}
+
+func (e *LoginBlockedError) Error() string {
+	if e.Locked {
+		return "account is temporarily locked"
+	}
+	return "too many failed login attempts, try again later"
+}
+
+// RetryAfterSeconds formats the wait for a Retry-After header, rounded up.
+func (e *LoginBlockedError) RetryAfterSeconds() string {
+	return fmt.Sprintf("%d", int(e.RetryAfter.Seconds()+0.999))
+}
+
+type LoginGuardService struct {
+	attemptRepo repository.LoginAttemptRepository
+	userRepo    repository.UserRepository
+	cfg         utility.LoginProtectionConfig
+}
+
+func NewLoginGuardService(attemptRepo repository.LoginAttemptRepository, userRepo repository.UserRepository,
+	cfg utility.LoginProtectionConfig) LoginGuardService {
+	return LoginGuardService{attemptRepo: attemptRepo, userRepo: userRepo, cfg: cfg}
+}
+
+// Second factor attempts are counted apart from passwords under this
+// scope: a correct password resets the password failures, and must not wipe
+// the wrong codes entered after it.
+const secondFactorScope = "mfa:"
+
+// Check refuses the attempt while the client IP or the username is backing
+// off, or while the account is locked.
+func (s *LoginGuardService) Check(ctx context.Context, username, clientIP string) error {
+	return s.check(ctx, "", username, clientIP)
+}
+
+// CheckSecondFactor is Check for a TOTP or recovery code.
+func (s *LoginGuardService) CheckSecondFactor(ctx context.Context, username, clientIP string) error {
+	return s.check(ctx, secondFactorScope, username, clientIP)
+}
+
+func (s *LoginGuardService) check(ctx context.Context, scope, username, clientIP string) error {
+	checks := []struct {
+		key    string
+		locked bool
+	}{
+		{"backoff:ip:" + clientIP, false},
+		{"lock:" + scope + "user:" + normalizeLoginName(username), true},
+		{"backoff:" + scope + "user:" + normalizeLoginName(username), false},
+	}
+
+	for _, check := range checks {
+		remaining, err := s.attemptRepo.BlockedFor(ctx, check.key)
+		if err != nil {
+			return err
+		}
+		if remaining > 0 {
+			return &LoginBlockedError{Locked: check.locked, RetryAfter: remaining}
+		}
+	}
+	return nil
+}
+
+func (s *LoginGuardService) RecordFailure(ctx context.Context, username, clientIP string) {
+	s.recordFailure(ctx, "", username, clientIP)
+}
+
+func (s *LoginGuardService) RecordSecondFactorFailure(ctx context.Context, username, clientIP string) {
+	s.recordFailure(ctx, secondFactorScope, username, clientIP)
+}
+
+func (s *LoginGuardService) recordFailure(ctx context.Context, scope, username, clientIP string) {
+	name := normalizeLoginName(username)
+	userKey := scope + "user:" + name
+	failures := "failures"
+	if scope == secondFactorScope {
+		failures = "second factor failures"
+	}
+
+	userFailures, err := s.attemptRepo.RecordFailure(ctx, userKey, s.cfg.FailureWindow)
+	if err != nil {
+		log.Printf("failed to record login failure for %q: %v", name, err)
+	}
+	ipFailures, err := s.attemptRepo.RecordFailure(ctx, "ip:"+clientIP, s.cfg.FailureWindow)
+	if err != nil {
+		log.Printf("failed to record login failure for ip %s: %v", clientIP, err)
+	}
+
+	if s.cfg.LockoutThreshold > 0 && userFailures >= int64(s.cfg.LockoutThreshold) {
+		if err := s.attemptRepo.Block(ctx, "lock:"+userKey, s.cfg.LockoutDuration); err == nil {
+			log.Printf("login lockout: account %q locked for %s after %d %s", name, s.cfg.LockoutDuration,
+				userFailures, failures)
+		}
+	} else if delay := s.backoff(userFailures, s.cfg.UserBackoffAfter); delay > 0 {
+		s.attemptRepo.Block(ctx, "backoff:"+userKey, delay)
+		log.Printf("login backoff: account %q must wait %s after %d %s", name, delay, userFailures, failures)
+	}
+
+	if delay := s.backoff(ipFailures, s.cfg.IPBackoffAfter); delay > 0 {
+		s.attemptRepo.Block(ctx, "backoff:ip:"+clientIP, delay)
+		log.Printf("login backoff: ip %s must wait %s after %d failures", clientIP, delay, ipFailures)
+	}
+}
+
+// RecordSuccess forgets the failures of the account. The IP counter keeps
+// decaying on its own, one valid login must not hide a spraying attack.
+func (s *LoginGuardService) RecordSuccess(ctx context.Context, username string) {
+	s.recordSuccess(ctx, "", username)
+}
+
+func (s *LoginGuardService) RecordSecondFactorSuccess(ctx context.Context, username string) {
+	s.recordSuccess(ctx, secondFactorScope, username)
+}
+
+func (s *LoginGuardService) recordSuccess(ctx context.Context, scope, username string) {
+	if err := s.attemptRepo.ResetFailures(ctx, scope+"user:"+normalizeLoginName(username)); err != nil {
+		log.Printf("failed to reset login failures for %q: %v", username, err)
+	}
+}
+
+// Unlock lifts a lockout and the back-off of an account before they decay,
+// for passwords and second factors alike.
+func (s *LoginGuardService) Unlock(ctx context.Context, userID uint) error {
+	user, err := s.userRepo.FindByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return ErrUserNotFound
+	}
+
+	name := normalizeLoginName(user.Username)
+	for _, scope := range []string{"", secondFactorScope} {
+		userKey := scope + "user:" + name
+		for _, key := range []string{"lock:" + userKey, "backoff:" + userKey} {
+			if err := s.attemptRepo.Unblock(ctx, key); err != nil {
+				return err
+			}
+		}
+		if err := s.attemptRepo.ResetFailures(ctx, userKey); err != nil {
+			return err
+		}
+	}
+	log.Printf("login lockout: account %q unlocked by an administrator", name)
+	return nil
+}
+
+// backoff doubles the wait for every failure past the threshold.
+func (s *LoginGuardService) backoff(failures int64, after int) time.Duration {
+	if after <= 0 || failures < int64(after) {
+		return 0
+	}
+	exponent := failures - int64(after)
+	if exponent > 30 {
+		exponent = 30
+	}
+	delay := s.cfg.BackoffBase * time.Duration(1<<exponent)
+	if delay > s.cfg.BackoffMax {
+		delay = s.cfg.BackoffMax
+	}
+	return delay
+}
+
+func normalizeLoginName(username string) string {
+	return strings.ToLower(strings.TrimSpace(username))
+}
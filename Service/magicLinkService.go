@@ -0,0 +1,131 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"redditBack/model"
+	"redditBack/repository"
+	"redditBack/utility"
+	"strconv"
+	"strings"
+)
+
+const magicLinkPurpose = "magic_link"
+
+var ErrMagicLinkDisabled = errors.New("magic link login is disabled")
+
+type MagicLinkService struct {
+	userRepo  repository.UserRepository
+	cacheRepo repository.CacheRepository
+	mailer    utility.Mailer
+	signer    utility.ActionTokenSigner
+	cfg       utility.MagicLinkConfig
+	baseURL   string
+}
+
+func NewMagicLinkService(userRepo repository.UserRepository, cacheRepo repository.CacheRepository,
+	mailer utility.Mailer, signer utility.ActionTokenSigner, cfg utility.MagicLinkConfig, baseURL string) MagicLinkService {
+	return MagicLinkService{
+		userRepo:  userRepo,
+		cacheRepo: cacheRepo,
+		mailer:    mailer,
+		signer:    signer,
+		cfg:       cfg,
+		baseURL:   baseURL,
+	}
+}
+
+// RequestLink emails a single use login link when the address belongs to a
+// user. Like password resets it answers the same for unknown addresses, and
+// the throttle is keyed by the address so it applies to those too.
+func (s *MagicLinkService) RequestLink(ctx context.Context, email string) error {
+	if !s.cfg.Enabled {
+		return ErrMagicLinkDisabled
+	}
+
+	email = strings.TrimSpace(email)
+	throttleKey := magicLinkPurpose + ":" + utility.HashToken(strings.ToLower(email))
+	acquired, err := s.cacheRepo.AcquireThrottle(ctx, throttleKey, s.cfg.RequestInterval)
+	if err != nil {
+		return err
+	}
+	if !acquired {
+		return nil
+	}
+
+	user, err := s.userRepo.FindByEmail(ctx, email)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return nil
+	}
+
+	token := &utility.ActionToken{Purpose: magicLinkPurpose, UserID: user.ID, Email: user.Email}
+	signed, err := s.signer.Sign(token, s.cfg.TokenTTL)
+	if err != nil {
+		return err
+	}
+	err = s.cacheRepo.StoreOneTimeValue(ctx, magicLinkPurpose+":"+token.Nonce,
+		strconv.FormatUint(uint64(user.ID), 10), s.cfg.TokenTTL)
+	if err != nil {
+		return err
+	}
+
+	go s.sendLinkMail(user, signed)
+	return nil
+}
+
+func (s *MagicLinkService) sendLinkMail(user *model.User, token string) {
+	link := tokenLink(s.cfg.LinkURL, s.baseURL+"/login/magic/verify", token)
+	err := s.mailer.Send(context.Background(), utility.MailMessage{
+		To:      user.Email,
+		Subject: "Your login link",
+		Body: fmt.Sprintf("Hi %s,\n\nopen the link below to log in to your account:\n\n%s\n\n"+
+			"The link expires in %s and works once. If you did not ask for it, you can ignore this email.\n",
+			user.Username, link, s.cfg.TokenTTL),
+	})
+	if err != nil {
+		log.Printf("failed to send magic link email to user %d: %v", user.ID, err)
+	}
+}
+
+// ExchangeLink consumes a login link and returns its user. The link only
+// works for the address it was sent to, and since opening it proves access to
+// that address, an unverified email is marked verified on the way.
+func (s *MagicLinkService) ExchangeLink(ctx context.Context, rawToken string) (*model.User, error) {
+	if !s.cfg.Enabled {
+		return nil, ErrMagicLinkDisabled
+	}
+
+	token, err := s.signer.Verify(magicLinkPurpose, rawToken)
+	if err != nil {
+		return nil, err
+	}
+	stored, err := s.cacheRepo.ConsumeOneTimeValue(ctx, magicLinkPurpose+":"+token.Nonce)
+	if err != nil {
+		return nil, err
+	}
+	if stored == "" {
+		return nil, utility.ErrInvalidActionToken
+	}
+
+	user, err := s.userRepo.FindByID(ctx, token.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil || user.Email != token.Email {
+		return nil, utility.ErrInvalidActionToken
+	}
+
+	if !user.EmailVerified {
+		if err := s.userRepo.MarkEmailVerified(ctx, user.ID, user.Email); err != nil {
+			log.Printf("failed to mark email verified for user %d: %v", user.ID, err)
+		} else {
+			user.EmailVerified = true
+		}
+	}
+	return user, nil
+}
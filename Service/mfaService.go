@@ -0,0 +1,252 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"redditBack/model"
+	"redditBack/repository"
+	"redditBack/utility"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	mfaChallengePurpose    = "mfa_challenge"
+	maxMFAChallengeRetries = 5
+)
+
+var (
+	ErrMFAAlreadyEnabled   = errors.New("two-factor authentication is already enabled")
+	ErrMFANotEnabled       = errors.New("two-factor authentication is not enabled")
+	ErrInvalidMFACode      = errors.New("invalid two-factor code")
+	ErrInvalidMFAChallenge = errors.New("invalid or expired two-factor challenge")
+)
+
+type MFAService struct {
+	userRepo     repository.UserRepository
+	cacheRepo    repository.CacheRepository
+	recoveryRepo repository.RecoveryCodeRepository
+	tokens       TokenService
+	guard        LoginGuardService
+	cfg          utility.MFAConfig
+}
+
+func NewMFAService(userRepo repository.UserRepository, cacheRepo repository.CacheRepository,
+	recoveryRepo repository.RecoveryCodeRepository, tokens TokenService, guard LoginGuardService,
+	cfg utility.MFAConfig) MFAService {
+	return MFAService{
+		userRepo:     userRepo,
+		cacheRepo:    cacheRepo,
+		recoveryRepo: recoveryRepo,
+		tokens:       tokens,
+		guard:        guard,
+		cfg:          cfg,
+	}
+}
+
+// BeginTOTPEnrollment stores a new, not yet enabled secret for the user and
+// returns it together with its otpauth URI.
+func (s *MFAService) BeginTOTPEnrollment(ctx context.Context, principal *utility.Principal) (string, string, error) {
+	user, err := s.findUser(ctx, principal)
+	if err != nil {
+		return "", "", err
+	}
+	if user.TOTPEnabled {
+		return "", "", ErrMFAAlreadyEnabled
+	}
+
+	secret, err := utility.GenerateTOTPSecret()
+	if err != nil {
+		return "", "", err
+	}
+	if err := s.userRepo.UpdateTOTP(ctx, user.ID, secret, false); err != nil {
+		return "", "", err
+	}
+
+	return secret, utility.TOTPProvisioningURI(s.cfg.Issuer, user.Username, secret), nil
+}
+
+// ConfirmTOTPEnrollment enables TOTP once the user proves the authenticator
+// works, and returns the recovery codes. They are only ever shown here.
+func (s *MFAService) ConfirmTOTPEnrollment(ctx context.Context, principal *utility.Principal, code string) ([]string, error) {
+	user, err := s.findUser(ctx, principal)
+	if err != nil {
+		return nil, err
+	}
+	if user.TOTPEnabled {
+		return nil, ErrMFAAlreadyEnabled
+	}
+	if user.TOTPSecret == "" {
+		return nil, ErrMFANotEnabled
+	}
+
+	ok, err := s.checkTOTP(ctx, user, code)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrInvalidMFACode
+	}
+
+	codes, err := utility.GenerateRecoveryCodes(s.cfg.RecoveryCodeCount)
+	if err != nil {
+		return nil, err
+	}
+	hashes := make([]string, 0, len(codes))
+	for _, recoveryCode := range codes {
+		hashes = append(hashes, utility.HashToken(recoveryCode))
+	}
+	if err := s.recoveryRepo.ReplaceForUser(ctx, user.ID, hashes); err != nil {
+		return nil, err
+	}
+
+	if err := s.userRepo.UpdateTOTP(ctx, user.ID, user.TOTPSecret, true); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// DisableTOTP lets the user turn two-factor authentication off with a valid
+// TOTP or recovery code.
+func (s *MFAService) DisableTOTP(ctx context.Context, principal *utility.Principal, code string) error {
+	user, err := s.findUser(ctx, principal)
+	if err != nil {
+		return err
+	}
+	if !user.TOTPEnabled {
+		return ErrMFANotEnabled
+	}
+
+	ok, err := s.verifyCode(ctx, user, code)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrInvalidMFACode
+	}
+	return s.clearTOTP(ctx, user.ID)
+}
+
+// ResetTOTP is the administrative reset for users who lost both their
+// authenticator and their recovery codes. It also ends their sessions.
+func (s *MFAService) ResetTOTP(ctx context.Context, userID uint) error {
+	user, err := s.userRepo.FindByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return ErrUserNotFound
+	}
+	if err := s.clearTOTP(ctx, user.ID); err != nil {
+		return err
+	}
+	log.Printf("two-factor authentication reset for user %d", user.ID)
+	return s.tokens.RevokeAllSessions(ctx, user.ID)
+}
+
+// CompleteLogin finishes a login that AuthService.Login answered with a
+// challenge. A challenge survives a few wrong codes before it is dropped, and
+// since a new one only takes the password, wrong codes also count against
+// the account in the login guard.
+func (s *MFAService) CompleteLogin(ctx context.Context, challenge, code, clientIP string) (*model.User, error) {
+	state, err := consumeMFAChallenge(ctx, s.cacheRepo, challenge)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := s.userRepo.FindByID(ctx, state.UserID)
+	if err != nil || user == nil || !user.TOTPEnabled {
+		return nil, ErrInvalidMFAChallenge
+	}
+	if err := s.guard.CheckSecondFactor(ctx, user.Username, clientIP); err != nil {
+		return nil, err
+	}
+
+	ok, err := s.verifyCode(ctx, user, code)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		s.guard.RecordSecondFactorFailure(ctx, user.Username, clientIP)
+		if state.Attempts+1 < maxMFAChallengeRetries {
+			state.Attempts++
+			key := mfaChallengePurpose + ":" + utility.HashToken(challenge)
+			if err := s.cacheRepo.StoreOneTimeValue(ctx, key, state.String(), s.cfg.ChallengeTTL); err != nil {
+				return nil, err
+			}
+		}
+		return nil, ErrInvalidMFACode
+	}
+	s.guard.RecordSecondFactorSuccess(ctx, user.Username)
+	return user, nil
+}
+
+// mfaChallengeState is what the cache holds for a login waiting for its
+// second factor. ViaPasskey records that a passkey was the first factor, so
+// the same passkey cannot count as the second one too.
+type mfaChallengeState struct {
+	UserID     uint
+	Attempts   int
+	ViaPasskey bool
+}
+
+func (st mfaChallengeState) String() string {
+	return fmt.Sprintf("%d:%d:%t", st.UserID, st.Attempts, st.ViaPasskey)
+}
+
+// consumeMFAChallenge takes a login challenge out of the cache.
+func consumeMFAChallenge(ctx context.Context, cacheRepo repository.CacheRepository, challenge string) (*mfaChallengeState, error) {
+	stored, err := cacheRepo.ConsumeOneTimeValue(ctx, mfaChallengePurpose+":"+utility.HashToken(challenge))
+	if err != nil {
+		return nil, err
+	}
+
+	parts := strings.SplitN(stored, ":", 3)
+	userID, err := strconv.ParseUint(parts[0], 10, 64)
+	if err != nil {
+		return nil, ErrInvalidMFAChallenge
+	}
+	state := &mfaChallengeState{UserID: uint(userID)}
+	if len(parts) > 1 {
+		state.Attempts, _ = strconv.Atoi(parts[1])
+	}
+	if len(parts) > 2 {
+		state.ViaPasskey, _ = strconv.ParseBool(parts[2])
+	}
+	return state, nil
+}
+
+func (s *MFAService) verifyCode(ctx context.Context, user *model.User, code string) (bool, error) {
+	code = strings.TrimSpace(code)
+	if len(code) == 6 {
+		return s.checkTOTP(ctx, user, code)
+	}
+	return s.recoveryRepo.Consume(ctx, user.ID, utility.HashToken(utility.NormalizeRecoveryCode(code)))
+}
+
+// checkTOTP validates a code and refuses a time step that was already used.
+func (s *MFAService) checkTOTP(ctx context.Context, user *model.User, code string) (bool, error) {
+	ok, step := utility.ValidateTOTP(user.TOTPSecret, code, time.Now())
+	if !ok {
+		return false, nil
+	}
+	return s.cacheRepo.AcquireThrottle(ctx, fmt.Sprintf("totp_step:%d:%d", user.ID, step), 2*time.Minute)
+}
+
+func (s *MFAService) clearTOTP(ctx context.Context, userID uint) error {
+	if err := s.userRepo.UpdateTOTP(ctx, userID, "", false); err != nil {
+		return err
+	}
+	return s.recoveryRepo.DeleteForUser(ctx, userID)
+}
+
+func (s *MFAService) findUser(ctx context.Context, principal *utility.Principal) (*model.User, error) {
+	user, err := s.userRepo.FindByID(ctx, principal.UserID)
+	if err != nil || user == nil {
+		return nil, errors.New("Error in username")
+	}
+	return user, nil
+}
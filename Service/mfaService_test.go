@@ -0,0 +1,70 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"redditBack/model"
+	"redditBack/utility"
+	"testing"
+	"time"
+)
+
+// wrongTOTPCode returns a code the secret does not accept right now.
+func wrongTOTPCode(t *testing.T, secret string) string {
+	t.Helper()
+	for i := 0; i < 1000; i++ {
+		code := fmt.Sprintf("%06d", i)
+		if ok, _ := utility.ValidateTOTP(secret, code, time.Now()); !ok {
+			return code
+		}
+	}
+	t.Fatal("no wrong code found")
+	return ""
+}
+
+func TestMFACompleteLoginLocksAcrossChallenges(t *testing.T) {
+	secret, err := utility.GenerateTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := &model.User{ID: 1, Username: "alice", Email: "alice@example.com", TOTPSecret: secret, TOTPEnabled: true}
+	cache := newFakeCacheRepo()
+	guard := NewLoginGuardService(newFakeLoginAttemptRepo(), nil, utility.LoginProtectionConfig{
+		FailureWindow:    time.Hour,
+		LockoutThreshold: 3,
+		LockoutDuration:  time.Hour,
+	})
+	mfa := NewMFAService(newFakeUserRepo(user), cache, nil, TokenService{}, guard,
+		utility.MFAConfig{ChallengeTTL: time.Minute})
+	auth := AuthService{cacheRepo: cache, challengeTTL: time.Minute}
+
+	// Every guess gets a fresh challenge, as if the password was entered
+	// again each time.
+	code := wrongTOTPCode(t, secret)
+	for i := 0; i < 3; i++ {
+		challenge, err := auth.createMFAChallenge(context.Background(), user, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := mfa.CompleteLogin(context.Background(), challenge, code, "192.0.2.1"); !errors.Is(err, ErrInvalidMFACode) {
+			t.Fatalf("guess %d: CompleteLogin = %v, want ErrInvalidMFACode", i+1, err)
+		}
+	}
+
+	challenge, err := auth.createMFAChallenge(context.Background(), user, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = mfa.CompleteLogin(context.Background(), challenge, code, "198.51.100.7")
+	var blocked *LoginBlockedError
+	if !errors.As(err, &blocked) || !blocked.Locked {
+		t.Fatalf("CompleteLogin after lockout = %v, want a locked account", err)
+	}
+
+	// A correct password must not reset the count of wrong codes.
+	guard.RecordSuccess(context.Background(), user.Username)
+	if err := guard.CheckSecondFactor(context.Background(), user.Username, "198.51.100.7"); err == nil {
+		t.Fatal("password success lifted the second factor lockout")
+	}
+}
@@ -0,0 +1,297 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"redditBack/model"
+	"redditBack/repository"
+	"redditBack/utility"
+	"strings"
+	"time"
+	"unicode"
+)
+
+const (
+	oidcStatePurpose = "oidc_state"
+	oidcStateTTL     = 10 * time.Minute
+)
+
+var (
+	ErrUnknownProvider   = errors.New("unknown identity provider")
+	ErrInvalidOIDCState  = errors.New("invalid or expired login state")
+	ErrOIDCEmailMissing  = errors.New("identity provider did not return an email address")
+	ErrOIDCEmailTaken    = errors.New("an account with this email already exists, sign in and connect the provider from your account")
+	ErrOIDCIdentityTaken = errors.New("this provider account is already connected to another user")
+)
+
+// oidcState is kept in the cache between the redirect and the callback.
+// ConnectUserID is set when a signed in user connects a provider.
+type oidcState struct {
+	Provider      string `json:"provider"`
+	Verifier      string `json:"verifier"`
+	Nonce         string `json:"nonce"`
+	BindingHash   string `json:"binding_hash"`
+	UseCookies    bool   `json:"use_cookies,omitempty"`
+	ConnectUserID uint   `json:"connect_user_id,omitempty"`
+}
+
+// OIDCRedirect starts a flow at the provider. Binding has to come back with
+// the callback from the same browser, in a cookie, so a callback URL from
+// someone else's flow cannot sign the browser in or connect an account.
+type OIDCRedirect struct {
+	URL     string
+	Binding string
+}
+
+// OIDCLoginResult is the user a callback signed in, and whether the login
+// should end in session cookies.
+type OIDCLoginResult struct {
+	User       *model.User
+	UseCookies bool
+}
+
+type OIDCService struct {
+	userRepo     repository.UserRepository
+	identityRepo repository.ExternalIdentityRepository
+	cacheRepo    repository.CacheRepository
+	hasher       utility.PasswordHasher
+	invites      InviteService
+	providers    map[string]*utility.OIDCClient
+}
+
+func NewOIDCService(userRepo repository.UserRepository, identityRepo repository.ExternalIdentityRepository,
+	cacheRepo repository.CacheRepository, hasher utility.PasswordHasher, invites InviteService,
+	providers []*utility.OIDCClient) OIDCService {
+	byName := make(map[string]*utility.OIDCClient, len(providers))
+	for _, provider := range providers {
+		byName[provider.Name()] = provider
+	}
+	return OIDCService{
+		userRepo:     userRepo,
+		identityRepo: identityRepo,
+		cacheRepo:    cacheRepo,
+		hasher:       hasher,
+		invites:      invites,
+		providers:    byName,
+	}
+}
+
+// StartLogin returns the provider URL to redirect the user to. State, nonce
+// and the PKCE verifier are kept in Redis until the callback, together with
+// whether the login should end in session cookies.
+func (s *OIDCService) StartLogin(ctx context.Context, providerName string, useCookies bool) (*OIDCRedirect, error) {
+	return s.start(ctx, oidcState{Provider: providerName, UseCookies: useCookies})
+}
+
+// StartConnect is StartLogin for a signed in user who wants to connect a
+// provider account to their own. This is the only way an external identity
+// gets linked to an existing account.
+func (s *OIDCService) StartConnect(ctx context.Context, principal *utility.Principal, providerName string,
+	useCookies bool) (*OIDCRedirect, error) {
+	return s.start(ctx, oidcState{Provider: providerName, UseCookies: useCookies, ConnectUserID: principal.UserID})
+}
+
+func (s *OIDCService) start(ctx context.Context, stored oidcState) (*OIDCRedirect, error) {
+	provider, ok := s.providers[stored.Provider]
+	if !ok {
+		return nil, ErrUnknownProvider
+	}
+
+	state, err := utility.GenerateOpaqueToken()
+	if err != nil {
+		return nil, err
+	}
+	binding, err := utility.GenerateOpaqueToken()
+	if err != nil {
+		return nil, err
+	}
+	stored.BindingHash = utility.HashToken(binding)
+	if stored.Nonce, err = utility.GenerateOpaqueToken(); err != nil {
+		return nil, err
+	}
+	if stored.Verifier, err = utility.GenerateOpaqueToken(); err != nil {
+		return nil, err
+	}
+
+	encoded, err := json.Marshal(stored)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.cacheRepo.StoreOneTimeValue(ctx, oidcStatePurpose+":"+state, string(encoded), oidcStateTTL); err != nil {
+		return nil, err
+	}
+
+	authURL, err := provider.AuthCodeURL(ctx, state, stored.Nonce, stored.Verifier)
+	if err != nil {
+		return nil, err
+	}
+	return &OIDCRedirect{URL: authURL, Binding: binding}, nil
+}
+
+// HandleCallback completes the code flow and returns the local user linked
+// to the external identity. On the first login it creates an account, or
+// links the identity to the signed in user when the flow was a connect.
+// binding is the OIDCRedirect.Binding the browser sent back.
+func (s *OIDCService) HandleCallback(ctx context.Context, providerName, state, binding, code string) (*OIDCLoginResult, error) {
+	provider, ok := s.providers[providerName]
+	if !ok {
+		return nil, ErrUnknownProvider
+	}
+
+	raw, err := s.cacheRepo.ConsumeOneTimeValue(ctx, oidcStatePurpose+":"+state)
+	if err != nil {
+		return nil, err
+	}
+	var stored oidcState
+	if raw == "" || json.Unmarshal([]byte(raw), &stored) != nil || stored.Provider != providerName ||
+		!utility.ValidCSRFToken(utility.HashToken(binding), stored.BindingHash) {
+		return nil, ErrInvalidOIDCState
+	}
+
+	claims, err := provider.Exchange(ctx, code, stored.Verifier, stored.Nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	identity, err := s.identityRepo.FindByProviderSubject(ctx, providerName, claims.Subject)
+	if err != nil {
+		return nil, err
+	}
+	if identity != nil {
+		if stored.ConnectUserID != 0 && identity.UserID != stored.ConnectUserID {
+			return nil, ErrOIDCIdentityTaken
+		}
+		user, err := s.userRepo.FindByID(ctx, identity.UserID)
+		if err != nil || user == nil {
+			return nil, errors.New("linked user not found")
+		}
+		return &OIDCLoginResult{User: user, UseCookies: stored.UseCookies}, nil
+	}
+
+	var user *model.User
+	if stored.ConnectUserID != 0 {
+		user, err = s.userRepo.FindByID(ctx, stored.ConnectUserID)
+		if err == nil && user == nil {
+			err = ErrUserNotFound
+		}
+	} else {
+		user, err = s.createUser(ctx, claims)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.identityRepo.Create(ctx, &model.ExternalIdentity{
+		UserID:   user.ID,
+		Provider: providerName,
+		Subject:  claims.Subject,
+		Email:    claims.Email,
+	})
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("linked %s identity %s to user %d", providerName, claims.Subject, user.ID)
+	return &OIDCLoginResult{User: user, UseCookies: stored.UseCookies}, nil
+}
+
+// createUser signs up a new account for the identity. An existing account
+// with the same email is never linked here, even when the provider says the
+// address is verified: the owner has to sign in and connect the provider.
+func (s *OIDCService) createUser(ctx context.Context, claims *utility.OIDCClaims) (*model.User, error) {
+	if claims.Email == "" {
+		return nil, ErrOIDCEmailMissing
+	}
+	if model.IsReservedEmail(claims.Email) {
+		return nil, ErrReservedEmail
+	}
+
+	existing, err := s.userRepo.FindByEmail(ctx, claims.Email)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		return nil, ErrOIDCEmailTaken
+	}
+	// There is no way to pass an invite code through the provider, so while
+	// registration is invite only, people sign up with a password first.
+	if s.invites.Required() {
+		return nil, ErrInviteRequired
+	}
+
+	// The account gets a random password nobody knows, so it can only be used
+	// through the provider until the user sets one with a password reset.
+	randomPassword, err := utility.GenerateOpaqueToken()
+	if err != nil {
+		return nil, err
+	}
+	hash, err := s.hasher.Hash(randomPassword)
+	if err != nil {
+		return nil, err
+	}
+
+	base := usernameBase(claims)
+	for attempt := 0; attempt < 20; attempt++ {
+		candidate := base
+		if attempt > 0 {
+			candidate = fmt.Sprintf("%s%d", base, attempt)
+		}
+		if model.IsReservedUsername(candidate) {
+			continue
+		}
+		taken, err := s.userRepo.FindByUsername(ctx, candidate)
+		if err != nil {
+			return nil, err
+		}
+		if taken != nil {
+			continue
+		}
+
+		user := &model.User{
+			Username:      candidate,
+			Email:         claims.Email,
+			PasswordHash:  hash,
+			EmailVerified: claims.EmailVerified,
+		}
+		if claims.EmailVerified {
+			now := time.Now()
+			user.EmailVerifiedAt = &now
+		}
+		err = s.userRepo.Create(ctx, user)
+		if errors.Is(err, repository.ErrUsernameTaken) {
+			// Someone took the name in the meantime.
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return user, nil
+	}
+	return nil, errors.New("could not find a free username")
+}
+
+// usernameBase derives a username from the claims, keeping letters, digits
+// and underscores only.
+func usernameBase(claims *utility.OIDCClaims) string {
+	source := claims.PreferredUsername
+	if source == "" {
+		source, _, _ = strings.Cut(claims.Email, "@")
+	}
+
+	var b strings.Builder
+	for _, r := range strings.ToLower(source) {
+		if r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_') {
+			b.WriteRune(r)
+		}
+	}
+	base := b.String()
+	if len(base) > 24 {
+		base = base[:24]
+	}
+	if len(base) < 3 {
+		base = "user_" + base
+	}
+	return base
+}
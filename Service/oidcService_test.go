@@ -0,0 +1,428 @@
+package service
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"redditBack/model"
+	"redditBack/repository"
+	"redditBack/utility"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const mockClientID = "reddit-back"
+
+// mockIdP is an OpenID provider serving discovery, token and JWKS endpoints.
+// Tests play the browser: they follow the URL from StartLogin by calling
+// authorize, then hand the code to HandleCallback.
+type mockIdP struct {
+	server *httptest.Server
+	key    *ecdsa.PrivateKey
+
+	mu    sync.Mutex
+	codes map[string]mockAuthorization
+}
+
+type mockAuthorization struct {
+	challenge string
+	claims    utility.OIDCClaims
+}
+
+func newMockIdP(t *testing.T) *mockIdP {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idp := &mockIdP{key: key, codes: make(map[string]mockAuthorization)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(utility.OIDCDiscovery{
+			Issuer:                idp.server.URL,
+			AuthorizationEndpoint: idp.server.URL + "/authorize",
+			TokenEndpoint:         idp.server.URL + "/token",
+			JWKSURI:               idp.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "EC",
+				"kid": "test",
+				"crv": "P-256",
+				"x":   base64.RawURLEncoding.EncodeToString(key.PublicKey.X.FillBytes(make([]byte, 32))),
+				"y":   base64.RawURLEncoding.EncodeToString(key.PublicKey.Y.FillBytes(make([]byte, 32))),
+			}},
+		})
+	})
+	mux.HandleFunc("/token", idp.token)
+	idp.server = httptest.NewServer(mux)
+	t.Cleanup(idp.server.Close)
+	return idp
+}
+
+func (idp *mockIdP) token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil || r.PostForm.Get("client_id") != mockClientID {
+		http.Error(w, `{"error":"invalid_request"}`, http.StatusBadRequest)
+		return
+	}
+
+	idp.mu.Lock()
+	authorization, ok := idp.codes[r.PostForm.Get("code")]
+	delete(idp.codes, r.PostForm.Get("code"))
+	idp.mu.Unlock()
+
+	verifier := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if !ok || base64.RawURLEncoding.EncodeToString(verifier[:]) != authorization.challenge {
+		http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+		return
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, authorization.claims)
+	token.Header["kid"] = "test"
+	signed, err := token.SignedString(idp.key)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{"id_token": signed, "token_type": "Bearer"})
+}
+
+// authorize answers the authorization request in authURL for the given
+// identity and returns the state and code the provider redirects back with.
+// mutate may change the ID token or the PKCE challenge before the code is
+// issued.
+func (idp *mockIdP) authorize(t *testing.T, authURL string, identity utility.OIDCClaims,
+	mutate func(*mockAuthorization)) (string, string) {
+	t.Helper()
+	parsed, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := parsed.Query()
+	if query.Get("client_id") != mockClientID || query.Get("code_challenge_method") != "S256" {
+		t.Fatalf("unexpected authorization request %s", authURL)
+	}
+
+	now := time.Now()
+	identity.Nonce = query.Get("nonce")
+	identity.RegisteredClaims = jwt.RegisteredClaims{
+		Issuer:    idp.server.URL,
+		Subject:   identity.Subject,
+		Audience:  jwt.ClaimStrings{mockClientID},
+		IssuedAt:  jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(now.Add(5 * time.Minute)),
+	}
+	authorization := mockAuthorization{challenge: query.Get("code_challenge"), claims: identity}
+	if mutate != nil {
+		mutate(&authorization)
+	}
+
+	code, err := utility.GenerateOpaqueToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	idp.mu.Lock()
+	idp.codes[code] = authorization
+	idp.mu.Unlock()
+	return query.Get("state"), code
+}
+
+type oidcTestEnv struct {
+	service    OIDCService
+	idp        *mockIdP
+	users      *fakeUserRepo
+	identities *fakeIdentityRepo
+}
+
+func newOIDCTestEnv(t *testing.T, users ...*model.User) *oidcTestEnv {
+	idp := newMockIdP(t)
+	client := utility.NewOIDCClient(utility.OIDCProviderConfig{
+		Name:        "mock",
+		IssuerURL:   idp.server.URL,
+		ClientID:    mockClientID,
+		RedirectURL: "http://localhost:8080/oidc/mock/callback",
+		Scopes:      []string{"openid", "email", "profile"},
+	}, idp.server.Client())
+
+	env := &oidcTestEnv{idp: idp, users: newFakeUserRepo(users...), identities: &fakeIdentityRepo{}}
+	env.service = NewOIDCService(env.users, env.identities, newFakeCacheRepo(),
+		utility.NewBcryptHasher(bcrypt.MinCost), NewInviteService(nil, env.users, utility.InviteConfig{}),
+		[]*utility.OIDCClient{client})
+	return env
+}
+
+func identityClaims(subject, email, username string) utility.OIDCClaims {
+	return utility.OIDCClaims{
+		Email:             email,
+		EmailVerified:     true,
+		PreferredUsername: username,
+		RegisteredClaims:  jwt.RegisteredClaims{Subject: subject},
+	}
+}
+
+func (env *oidcTestEnv) login(t *testing.T, identity utility.OIDCClaims, mutate func(*mockAuthorization)) (*OIDCLoginResult, error) {
+	t.Helper()
+	redirect, err := env.service.StartLogin(context.Background(), "mock", false)
+	if err != nil {
+		t.Fatalf("StartLogin: %v", err)
+	}
+	state, code := env.idp.authorize(t, redirect.URL, identity, mutate)
+	return env.service.HandleCallback(context.Background(), "mock", state, redirect.Binding, code)
+}
+
+func TestOIDCLoginCreatesAndReusesAccount(t *testing.T) {
+	env := newOIDCTestEnv(t)
+	identity := identityClaims("sub-1", "alice@example.com", "alice")
+
+	first, err := env.login(t, identity, nil)
+	if err != nil {
+		t.Fatalf("first login: %v", err)
+	}
+	if first.User.Username != "alice" || first.User.Email != "alice@example.com" || !first.User.EmailVerified {
+		t.Fatalf("created user = %+v", first.User)
+	}
+
+	second, err := env.login(t, identity, nil)
+	if err != nil {
+		t.Fatalf("second login: %v", err)
+	}
+	if second.User.ID != first.User.ID {
+		t.Fatalf("second login returned user %d, want %d", second.User.ID, first.User.ID)
+	}
+}
+
+func TestOIDCCallbackRejectsInvalidResponses(t *testing.T) {
+	tests := []struct {
+		name        string
+		mutate      func(*mockAuthorization)
+		wantIDToken bool
+	}{
+		{
+			name:   "PKCE verifier mismatch",
+			mutate: func(a *mockAuthorization) { a.challenge = "not-the-challenge" },
+		},
+		{
+			name:        "nonce mismatch",
+			mutate:      func(a *mockAuthorization) { a.claims.Nonce = "other-nonce" },
+			wantIDToken: true,
+		},
+		{
+			name:        "wrong audience",
+			mutate:      func(a *mockAuthorization) { a.claims.Audience = jwt.ClaimStrings{"someone-else"} },
+			wantIDToken: true,
+		},
+		{
+			name:        "wrong issuer",
+			mutate:      func(a *mockAuthorization) { a.claims.Issuer = "https://evil.example.com" },
+			wantIDToken: true,
+		},
+		{
+			name: "expired id token",
+			mutate: func(a *mockAuthorization) {
+				a.claims.IssuedAt = jwt.NewNumericDate(time.Now().Add(-time.Hour))
+				a.claims.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-30 * time.Minute))
+			},
+			wantIDToken: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newOIDCTestEnv(t)
+			result, err := env.login(t, identityClaims("sub-1", "alice@example.com", "alice"), tt.mutate)
+			if err == nil {
+				t.Fatalf("HandleCallback accepted the response and returned user %+v", result.User)
+			}
+			if tt.wantIDToken && !errors.Is(err, utility.ErrInvalidIDToken) {
+				t.Fatalf("HandleCallback error = %v, want ErrInvalidIDToken", err)
+			}
+			if user, _ := env.users.FindByEmail(context.Background(), "alice@example.com"); user != nil {
+				t.Fatalf("a user was created: %+v", user)
+			}
+		})
+	}
+}
+
+func TestOIDCCallbackRejectsReusedState(t *testing.T) {
+	env := newOIDCTestEnv(t)
+	redirect, err := env.service.StartLogin(context.Background(), "mock", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state, code := env.idp.authorize(t, redirect.URL, identityClaims("sub-1", "alice@example.com", "alice"), nil)
+	if _, err := env.service.HandleCallback(context.Background(), "mock", state, redirect.Binding, code); err != nil {
+		t.Fatalf("first callback: %v", err)
+	}
+	if _, err := env.service.HandleCallback(context.Background(), "mock", state, redirect.Binding, code); !errors.Is(err, ErrInvalidOIDCState) {
+		t.Fatalf("replayed callback error = %v, want ErrInvalidOIDCState", err)
+	}
+}
+
+func TestOIDCUsernameCollisionIsSuffixed(t *testing.T) {
+	env := newOIDCTestEnv(t,
+		&model.User{Username: "alice", Email: "alice@one.example.com"},
+		&model.User{Username: "alice1", Email: "alice@two.example.com"},
+	)
+
+	result, err := env.login(t, identityClaims("sub-1", "alice@three.example.com", "Alice"), nil)
+	if err != nil {
+		t.Fatalf("login: %v", err)
+	}
+	if result.User.Username != "alice2" {
+		t.Fatalf("username = %q, want alice2", result.User.Username)
+	}
+}
+
+func TestOIDCDoesNotLinkExistingEmail(t *testing.T) {
+	env := newOIDCTestEnv(t, &model.User{Username: "alice", Email: "alice@example.com", EmailVerified: true})
+
+	_, err := env.login(t, identityClaims("sub-1", "alice@example.com", "alice"), nil)
+	if !errors.Is(err, ErrOIDCEmailTaken) {
+		t.Fatalf("login error = %v, want ErrOIDCEmailTaken", err)
+	}
+	if identity, _ := env.identities.FindByProviderSubject(context.Background(), "mock", "sub-1"); identity != nil {
+		t.Fatalf("identity was linked: %+v", identity)
+	}
+}
+
+func TestOIDCConnectLinksSignedInUser(t *testing.T) {
+	owner := &model.User{Username: "alice", Email: "alice@example.com"}
+	other := &model.User{Username: "bob", Email: "bob@example.com"}
+	env := newOIDCTestEnv(t, owner, other)
+	identity := identityClaims("sub-1", "alice@example.com", "alice")
+
+	redirect, err := env.service.StartConnect(context.Background(), &utility.Principal{UserID: owner.ID}, "mock", true)
+	if err != nil {
+		t.Fatalf("StartConnect: %v", err)
+	}
+	state, code := env.idp.authorize(t, redirect.URL, identity, nil)
+	connected, err := env.service.HandleCallback(context.Background(), "mock", state, redirect.Binding, code)
+	if err != nil {
+		t.Fatalf("connect callback: %v", err)
+	}
+	if connected.User.ID != owner.ID || !connected.UseCookies {
+		t.Fatalf("connect returned user %d, cookies %v; want user %d with cookies", connected.User.ID, connected.UseCookies, owner.ID)
+	}
+
+	loggedIn, err := env.login(t, identity, nil)
+	if err != nil || loggedIn.User.ID != owner.ID {
+		t.Fatalf("login after connect = %+v, %v; want user %d", loggedIn, err, owner.ID)
+	}
+
+	redirect, err = env.service.StartConnect(context.Background(), &utility.Principal{UserID: other.ID}, "mock", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state, code = env.idp.authorize(t, redirect.URL, identity, nil)
+	if _, err := env.service.HandleCallback(context.Background(), "mock", state, redirect.Binding, code); !errors.Is(err, ErrOIDCIdentityTaken) {
+		t.Fatalf("connecting a taken identity error = %v, want ErrOIDCIdentityTaken", err)
+	}
+}
+
+func TestOIDCCallbackRequiresBrowserBinding(t *testing.T) {
+	tests := []struct {
+		name    string
+		binding func(redirect *OIDCRedirect) string
+	}{
+		{"no cookie", func(*OIDCRedirect) string { return "" }},
+		{"cookie of another flow", func(*OIDCRedirect) string { return "attacker-binding" }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newOIDCTestEnv(t)
+			redirect, err := env.service.StartLogin(context.Background(), "mock", false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			state, code := env.idp.authorize(t, redirect.URL, identityClaims("sub-1", "alice@example.com", "alice"), nil)
+			_, err = env.service.HandleCallback(context.Background(), "mock", state, tt.binding(redirect), code)
+			if !errors.Is(err, ErrInvalidOIDCState) {
+				t.Fatalf("HandleCallback error = %v, want ErrInvalidOIDCState", err)
+			}
+			if user, _ := env.users.FindByEmail(context.Background(), "alice@example.com"); user != nil {
+				t.Fatalf("a user was created: %+v", user)
+			}
+		})
+	}
+}
+
+func TestOIDCConnectCallbackRequiresBrowserBinding(t *testing.T) {
+	victim := &model.User{Username: "victim", Email: "victim@example.com"}
+	env := newOIDCTestEnv(t, victim)
+
+	// The victim started a connect flow, the attacker finished one of their
+	// own at the provider and gets the victim's browser to the callback.
+	victimRedirect, err := env.service.StartConnect(context.Background(), &utility.Principal{UserID: victim.ID}, "mock", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	attackerRedirect, err := env.service.StartConnect(context.Background(), &utility.Principal{UserID: victim.ID}, "mock", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state, code := env.idp.authorize(t, attackerRedirect.URL, identityClaims("attacker", "attacker@example.com", "attacker"), nil)
+	_, err = env.service.HandleCallback(context.Background(), "mock", state, victimRedirect.Binding, code)
+	if !errors.Is(err, ErrInvalidOIDCState) {
+		t.Fatalf("HandleCallback error = %v, want ErrInvalidOIDCState", err)
+	}
+	if identity, _ := env.identities.FindByProviderSubject(context.Background(), "mock", "attacker"); identity != nil {
+		t.Fatalf("attacker identity was linked: %+v", identity)
+	}
+}
+
+// failingUserRepo returns the queued errors from Create before it starts
+// storing users.
+type failingUserRepo struct {
+	*fakeUserRepo
+	createErrs []error
+}
+
+func (r *failingUserRepo) Create(ctx context.Context, user *model.User) error {
+	if len(r.createErrs) > 0 {
+		err := r.createErrs[0]
+		r.createErrs = r.createErrs[1:]
+		return err
+	}
+	return r.fakeUserRepo.Create(ctx, user)
+}
+
+func TestOIDCCreateUserRetriesOnlyTakenUsernames(t *testing.T) {
+	claims := identityClaims("sub-1", "alice@example.com", "alice")
+	newService := func(users *failingUserRepo) OIDCService {
+		return NewOIDCService(users, &fakeIdentityRepo{}, newFakeCacheRepo(), utility.NewBcryptHasher(bcrypt.MinCost),
+			NewInviteService(nil, users, utility.InviteConfig{}), nil)
+	}
+
+	raced := &failingUserRepo{fakeUserRepo: newFakeUserRepo(), createErrs: []error{repository.ErrUsernameTaken}}
+	service := newService(raced)
+	user, err := service.createUser(context.Background(), &claims)
+	if err != nil {
+		t.Fatalf("createUser after a username race: %v", err)
+	}
+	if user.Username != "alice1" {
+		t.Fatalf("username = %q, want alice1", user.Username)
+	}
+
+	broken := &failingUserRepo{fakeUserRepo: newFakeUserRepo(), createErrs: []error{errors.New("connection refused")}}
+	service = newService(broken)
+	if _, err := service.createUser(context.Background(), &claims); err == nil || err.Error() != "connection refused" {
+		t.Fatalf("createUser error = %v, want the repository error", err)
+	}
+	if len(broken.users) != 0 {
+		t.Fatalf("createUser retried after a repository error and stored %d users", len(broken.users))
+	}
+}
@@ -0,0 +1,136 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"redditBack/model"
+	"redditBack/repository"
+	"redditBack/utility"
+	"strconv"
+)
+
+const passwordResetPurpose = "password_reset"
+
+var ErrInvalidResetToken = errors.New("invalid or expired reset token")
+
+type PasswordResetService struct {
+	userRepo        repository.UserRepository
+	cacheRepo       repository.CacheRepository
+	accessTokenRepo repository.PersonalAccessTokenRepository
+	tokens          TokenService
+	hasher          utility.PasswordHasher
+	mailer          utility.Mailer
+	cfg             utility.PasswordResetConfig
+	baseURL         string
+}
+
+func NewPasswordResetService(userRepo repository.UserRepository, cacheRepo repository.CacheRepository,
+	accessTokenRepo repository.PersonalAccessTokenRepository, tokens TokenService, hasher utility.PasswordHasher,
+	mailer utility.Mailer, cfg utility.PasswordResetConfig, baseURL string) PasswordResetService {
+	return PasswordResetService{
+		userRepo:        userRepo,
+		cacheRepo:       cacheRepo,
+		accessTokenRepo: accessTokenRepo,
+		tokens:          tokens,
+		hasher:          hasher,
+		mailer:          mailer,
+		cfg:             cfg,
+		baseURL:         baseURL,
+	}
+}
+
+// RequestReset emails a reset link when the address belongs to a user. It
+// behaves the same for unknown addresses, and the mail is sent in the
+// background so response times do not reveal which addresses exist either.
+func (s *PasswordResetService) RequestReset(ctx context.Context, email string) error {
+	user, err := s.userRepo.FindByEmail(ctx, email)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return nil
+	}
+
+	acquired, err := s.cacheRepo.AcquireThrottle(ctx, fmt.Sprintf("%s:%d", passwordResetPurpose, user.ID), s.cfg.RequestInterval)
+	if err != nil {
+		return err
+	}
+	if !acquired {
+		return nil
+	}
+
+	token, err := utility.GenerateOpaqueToken()
+	if err != nil {
+		return err
+	}
+	err = s.cacheRepo.StoreOneTimeValue(ctx, passwordResetPurpose+":"+utility.HashToken(token),
+		strconv.FormatUint(uint64(user.ID), 10), s.cfg.TokenTTL)
+	if err != nil {
+		return err
+	}
+
+	go s.sendResetMail(user, token)
+	return nil
+}
+
+func (s *PasswordResetService) sendResetMail(user *model.User, token string) {
+	link := tokenLink(s.cfg.LinkURL, s.baseURL+"/password/reset", token)
+	err := s.mailer.Send(context.Background(), utility.MailMessage{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Hi %s,\n\nsomeone asked to reset the password of your account. "+
+			"Open the link below to choose a new one:\n\n%s\n\n"+
+			"The link expires in %s and works once. If it was not you, you can ignore this email.\n",
+			user.Username, link, s.cfg.TokenTTL),
+	})
+	if err != nil {
+		log.Printf("failed to send password reset email to user %d: %v", user.ID, err)
+	}
+}
+
+// tokenLink adds token to the configured front-end page, or to fallback when
+// no page is configured.
+func tokenLink(configured string, fallback string, token string) string {
+	link := configured
+	if link == "" {
+		link = fallback
+	}
+	parsed, err := url.Parse(link)
+	if err != nil {
+		return fallback + "?token=" + url.QueryEscape(token)
+	}
+	query := parsed.Query()
+	query.Set("token", token)
+	parsed.RawQuery = query.Encode()
+	return parsed.String()
+}
+
+// ResetPassword sets a new password with a reset token, signs the user out
+// of every session and revokes their personal access tokens, since the old
+// password may have been compromised.
+func (s *PasswordResetService) ResetPassword(ctx context.Context, token string, newPassword string) error {
+	stored, err := s.cacheRepo.ConsumeOneTimeValue(ctx, passwordResetPurpose+":"+utility.HashToken(token))
+	if err != nil {
+		return err
+	}
+	userID, err := strconv.ParseUint(stored, 10, 64)
+	if err != nil {
+		return ErrInvalidResetToken
+	}
+
+	hash, err := s.hasher.Hash(newPassword)
+	if err != nil {
+		return errors.New("failed to hash password")
+	}
+	if err := s.userRepo.UpdatePasswordHash(ctx, uint(userID), hash); err != nil {
+		return ErrInvalidResetToken
+	}
+
+	if err := s.accessTokenRepo.RevokeAllForUser(ctx, uint(userID)); err != nil {
+		return err
+	}
+	return s.tokens.RevokeAllSessions(ctx, uint(userID))
+}
@@ -0,0 +1,22 @@
+package service
+
+import "testing"
+
+func TestTokenLink(t *testing.T) {
+	tests := []struct {
+		name       string
+		configured string
+		want       string
+	}{
+		{"served page", "", "http://localhost:8080/password/reset?token=a%2Bb"},
+		{"front-end page", "https://app.example.com/reset", "https://app.example.com/reset?token=a%2Bb"},
+		{"front-end page with query", "https://app.example.com/auth?step=reset", "https://app.example.com/auth?step=reset&token=a%2Bb"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tokenLink(tt.configured, "http://localhost:8080/password/reset", "a+b"); got != tt.want {
+				t.Fatalf("tokenLink = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,111 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"redditBack/model"
+	"redditBack/repository"
+	"redditBack/utility"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidAccessToken = errors.New("invalid access token")
+	ErrInvalidScope       = errors.New("invalid scope")
+)
+
+// accessTokenTouchInterval limits how often last-used times are written, so
+// a busy script does not update its token row on every request.
+const accessTokenTouchInterval = time.Minute
+
+type PersonalAccessTokenService struct {
+	tokenRepo repository.PersonalAccessTokenRepository
+	userRepo  repository.UserRepository
+	cacheRepo repository.CacheRepository
+}
+
+func NewPersonalAccessTokenService(tokenRepo repository.PersonalAccessTokenRepository, userRepo repository.UserRepository,
+	cacheRepo repository.CacheRepository) PersonalAccessTokenService {
+	return PersonalAccessTokenService{
+		tokenRepo: tokenRepo,
+		userRepo:  userRepo,
+		cacheRepo: cacheRepo,
+	}
+}
+
+// Create issues a token with the given scopes and returns it in plain text
+// together with its record. Only the hash is stored, so it cannot be shown
+// again.
+func (s *PersonalAccessTokenService) Create(ctx context.Context, principal *utility.Principal, name string, scopes []string, ttl time.Duration) (string, *model.PersonalAccessToken, error) {
+	if len(scopes) == 0 {
+		return "", nil, ErrInvalidScope
+	}
+	for _, scope := range scopes {
+		if !utility.HasScope(utility.GrantableScopes, scope) {
+			return "", nil, fmt.Errorf("%w: %s", ErrInvalidScope, scope)
+		}
+	}
+
+	secret, err := utility.GenerateOpaqueToken()
+	if err != nil {
+		return "", nil, err
+	}
+	raw := utility.PersonalAccessTokenPrefix + secret
+
+	token := &model.PersonalAccessToken{
+		UserID:    principal.UserID,
+		Name:      name,
+		TokenHash: utility.HashToken(raw),
+		Prefix:    raw[:len(utility.PersonalAccessTokenPrefix)+6],
+		Scopes:    strings.Join(scopes, ","),
+	}
+	if ttl > 0 {
+		expiresAt := time.Now().Add(ttl)
+		token.ExpiresAt = &expiresAt
+	}
+	if err := s.tokenRepo.Create(ctx, token); err != nil {
+		return "", nil, err
+	}
+	return raw, token, nil
+}
+
+func (s *PersonalAccessTokenService) List(ctx context.Context, principal *utility.Principal) ([]*model.PersonalAccessToken, error) {
+	return s.tokenRepo.ListActiveForUser(ctx, principal.UserID)
+}
+
+func (s *PersonalAccessTokenService) Revoke(ctx context.Context, principal *utility.Principal, tokenID uint) error {
+	return s.tokenRepo.Revoke(ctx, tokenID, principal.UserID)
+}
+
+// AuthenticateAccessToken implements utility.PersonalAccessTokenAuthenticator.
+func (s *PersonalAccessTokenService) AuthenticateAccessToken(ctx context.Context, raw string) (*utility.Principal, error) {
+	token, err := s.tokenRepo.FindByHash(ctx, utility.HashToken(raw))
+	if err != nil {
+		return nil, err
+	}
+	if token == nil || token.RevokedAt != nil || (token.ExpiresAt != nil && time.Now().After(*token.ExpiresAt)) {
+		return nil, ErrInvalidAccessToken
+	}
+
+	user, err := s.userRepo.FindByID(ctx, token.UserID)
+	if err != nil || user == nil {
+		return nil, ErrInvalidAccessToken
+	}
+
+	touch, err := s.cacheRepo.AcquireThrottle(ctx, fmt.Sprintf("pat_touch:%d", token.ID), accessTokenTouchInterval)
+	if err == nil && touch {
+		err = s.tokenRepo.TouchLastUsed(ctx, token.ID)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &utility.Principal{
+		UserID:        user.ID,
+		Role:          user.Role,
+		EmailVerified: user.EmailVerified,
+		Scopes:        strings.Split(token.Scopes, ","),
+	}, nil
+}
@@ -6,45 +6,91 @@ import (
 	"log"
 	"redditBack/model"
 	"redditBack/repository"
+	"redditBack/utility"
 	"time"
 )
 
+// newPostsPageSize is how many posts one page of a /new listing holds.
+const newPostsPageSize = 50
+
+// PostDetail is a post as shown on its own page. UserVote is the caller's
+// vote, 1 or -1, and 0 when they have not voted.
+type PostDetail struct {
+	*model.Post
+	Score    int `json:"score"`
+	UserVote int `json:"user_vote"`
+}
+
 type PostService struct {
-	postRepo  repository.PostRepository
-	userRepo  repository.UserRepository
-	cacheRepo repository.CacheRepository
-	voteRepo  repository.VoteRepository
+	postRepo    repository.PostRepository
+	userRepo    repository.UserRepository
+	cacheRepo   repository.CacheRepository
+	voteRepo    repository.VoteRepository
+	communities CommunityService
+	previews    LinkPreviewService
+	verifyCfg   utility.EmailVerificationConfig
 }
 
-func NewPostService(postRepo repository.PostRepository, userRepo repository.UserRepository, cacheRepo repository.CacheRepository, voteRepo repository.VoteRepository) PostService {
+func NewPostService(postRepo repository.PostRepository, userRepo repository.UserRepository, cacheRepo repository.CacheRepository, voteRepo repository.VoteRepository,
+	communities CommunityService, previews LinkPreviewService, verifyCfg utility.EmailVerificationConfig) PostService {
 	return PostService{
-		postRepo:  postRepo,
-		userRepo:  userRepo,
-		cacheRepo: cacheRepo,
-		voteRepo:  voteRepo}
+		postRepo:    postRepo,
+		userRepo:    userRepo,
+		cacheRepo:   cacheRepo,
+		voteRepo:    voteRepo,
+		communities: communities,
+		previews:    previews,
+		verifyCfg:   verifyCfg}
 }
 
-func (p *PostService) CreateNewPost(ctx context.Context, post *model.Post, username string) error {
+// CreateNewPost posts globally, or in the named community when the caller
+// meets its posting requirements. Posts with a URL become link posts whose
+// preview is fetched in the background.
+func (p *PostService) CreateNewPost(ctx context.Context, post *model.Post, communityName string, principal *utility.Principal) error {
 
-	user, err := p.userRepo.FindByUsername(ctx, username)
-	if err != nil {
-		return errors.New("Error in username")
+	if err := requireVerifiedEmail(ctx, p.userRepo, p.verifyCfg, principal, "post"); err != nil {
+		return err
+	}
+	if communityName != "" {
+		community, err := p.communities.GetCommunity(ctx, communityName)
+		if err != nil {
+			return err
+		}
+		if err := p.communities.requirePostingRights(ctx, community, principal); err != nil {
+			return err
+		}
+		post.CommunityID = &community.ID
+	}
+	if post.URL != "" {
+		normalized, err := utility.NormalizeLinkURL(post.URL)
+		if err != nil {
+			return err
+		}
+		post.URL = normalized
+		post.Kind = model.PostKindLink
 	}
-	post.UserID = user.ID
-	return p.postRepo.Create(ctx, post)
+	userID := principal.UserID
+	post.UserID = &userID
+	if err := p.postRepo.Create(ctx, post); err != nil {
+		return err
+	}
+	if post.Kind == model.PostKindLink {
+		p.previews.Enqueue(post.ID, post.URL)
+	}
+	return nil
 }
 
-func (p *PostService) EditPost(ctx context.Context, post *model.Post, username string) error {
+func (p *PostService) EditPost(ctx context.Context, post *model.Post, principal *utility.Principal) error {
 
-	user, err := p.userRepo.FindByUsername(ctx, username)
-	if err != nil {
-		return errors.New("Error in username")
-	}
 	tempPost, postErr := p.postRepo.FindByID(ctx, post.ID)
-	if postErr != nil {
+	if postErr != nil || tempPost == nil {
 		return errors.New("post not found")
 	}
-	if tempPost.UserID != user.ID {
+	allowed, err := authorizeOwnerOr(ctx, p.userRepo, principal, tempPost.UserID, "")
+	if err != nil {
+		return err
+	}
+	if !allowed {
 		return errors.New("unauthorized to edit post")
 	}
 
@@ -54,37 +100,97 @@ func (p *PostService) EditPost(ctx context.Context, post *model.Post, username s
 		Content: post.Content,
 		UserID:  tempPost.UserID,
 	}
-	return p.postRepo.Update(ctx, &updatedPost)
-
+	if err := p.postRepo.Update(ctx, &updatedPost); err != nil {
+		return err
+	}
+	p.invalidatePost(ctx, tempPost)
+	return nil
 }
 
-func (p *PostService) RemovePost(ctx context.Context, post *model.Post, username string) error {
+func (p *PostService) RemovePost(ctx context.Context, post *model.Post, principal *utility.Principal) error {
 
-	user, err := p.userRepo.FindByUsername(ctx, username)
-	if err != nil {
-		return errors.New("Error in username")
-	}
 	tempPost, postErr := p.postRepo.FindByID(ctx, post.ID)
-	if postErr != nil {
+	if postErr != nil || tempPost == nil {
 		return errors.New("post not found")
 	}
-	if tempPost.UserID != user.ID {
+	allowed, err := authorizeOwnerOr(ctx, p.userRepo, principal, tempPost.UserID, utility.PermissionRemoveAnyPost)
+	if err != nil {
+		return err
+	}
+	if !allowed {
 		return errors.New("unauthorized to edit post")
 	}
+	if tempPost.UserID == nil || *tempPost.UserID != principal.UserID {
+		log.Printf("user %d removed post %d by %s", principal.UserID, tempPost.ID, tempPost.Author)
+	}
 	err2 := p.voteRepo.Delete(ctx, tempPost.ID)
 	if err2 != nil {
 		log.Println("some erros in deleting votes, but igonre it")
 	}
-	return p.postRepo.Delete(ctx, tempPost.ID)
+	if err := p.postRepo.Delete(ctx, tempPost.ID); err != nil {
+		return err
+	}
+	p.invalidatePost(ctx, tempPost)
+	return nil
+}
 
+// GetPost reads a post through the cache, loading and caching it on a miss.
+// The caller's vote is personal, so it is looked up on every request and
+// never cached.
+func (p *PostService) GetPost(ctx context.Context, postID uint, principal *utility.Principal) (*PostDetail, error) {
+	post, err := p.cacheRepo.GetPost(ctx, postID)
+	if err != nil {
+		log.Printf("failed to read post %d from cache: %v", postID, err)
+		post = nil
+	}
+	if post == nil {
+		post, err = p.postRepo.FindByID(ctx, postID)
+		if err != nil {
+			return nil, err
+		}
+		if post == nil {
+			return nil, errors.New("post not found")
+		}
+		if err := p.cacheRepo.CachePost(ctx, post); err != nil {
+			log.Printf("failed to cache post %d: %v", postID, err)
+		}
+	}
+
+	detail := &PostDetail{Post: post, Score: post.CachedScore}
+	vote, err := p.voteRepo.FindByUserAndPost(ctx, principal.UserID, post.ID)
+	if err != nil {
+		return nil, err
+	}
+	if vote != nil {
+		detail.UserVote = vote.VoteValue
+	}
+	return detail, nil
+}
+
+// invalidatePost drops a changed post from the cache, and the rankings that
+// embed it. Failures are only logged, the change itself is already stored.
+func (p *PostService) invalidatePost(ctx context.Context, post *model.Post) {
+	if err := p.cacheRepo.InvalidatePost(ctx, post.ID); err != nil {
+		log.Printf("failed to invalidate cached post %d: %v", post.ID, err)
+	}
+	if err := p.cacheRepo.InvalidatePostRanking(ctx, post.CommunityID); err != nil {
+		log.Printf("failed to invalidate post ranking: %v", err)
+	}
 }
 
-func (p *PostService) GetTopPosts(ctx context.Context, timeRange string) ([]*model.Post, error) {
+// GetTopPosts ranks the posts of the named community, or of all communities
+// when communityName is empty.
+func (p *PostService) GetTopPosts(ctx context.Context, communityName string, timeRange string) ([]*model.Post, error) {
 
 	var startTime time.Time
 	now := time.Now()
 
-	cachedPosts, err := p.cacheRepo.GetTopPosts(ctx, timeRange)
+	communityID, err := p.communityID(ctx, communityName)
+	if err != nil {
+		return nil, err
+	}
+
+	cachedPosts, err := p.cacheRepo.GetTopPosts(ctx, communityID, timeRange)
 	if err == nil && len(cachedPosts) > 0 {
 		return cachedPosts, nil
 	}
@@ -101,14 +207,36 @@ func (p *PostService) GetTopPosts(ctx context.Context, timeRange string) ([]*mod
 		return nil, errors.New("invalid time range")
 	}
 
-	posts, err := p.postRepo.FindTopPosts(ctx, startTime)
+	posts, err := p.postRepo.FindTopPosts(ctx, communityID, startTime)
 	if err != nil {
 		return nil, err
 	}
-	if err := p.cacheRepo.CacheTopPosts(ctx, timeRange, posts); err != nil {
+	if err := p.cacheRepo.CacheTopPosts(ctx, communityID, timeRange, posts); err != nil {
 		log.Printf("Failed to cache posts: %v", err)
 		return nil, err
 	}
 
 	return posts, nil
 }
+
+// GetNewPosts lists the newest posts of the named community, or of all
+// communities when communityName is empty. beforeID continues a previous
+// page from its last post.
+func (p *PostService) GetNewPosts(ctx context.Context, communityName string, beforeID uint) ([]*model.Post, error) {
+	communityID, err := p.communityID(ctx, communityName)
+	if err != nil {
+		return nil, err
+	}
+	return p.postRepo.FindNewPosts(ctx, communityID, beforeID, newPostsPageSize)
+}
+
+func (p *PostService) communityID(ctx context.Context, communityName string) (uint, error) {
+	if communityName == "" {
+		return 0, nil
+	}
+	community, err := p.communities.GetCommunity(ctx, communityName)
+	if err != nil {
+		return 0, err
+	}
+	return community.ID, nil
+}
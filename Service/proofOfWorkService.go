@@ -0,0 +1,132 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"math/bits"
+	"redditBack/repository"
+	"redditBack/utility"
+)
+
+const proofOfWorkPurpose = "proof_of_work"
+
+var (
+	ErrUnknownProofOfWorkAction = errors.New("no proof of work is required for this action")
+	ErrProofOfWorkRequired      = errors.New("a solved proof-of-work challenge is required")
+	ErrInvalidProofOfWork       = errors.New("invalid, expired or already used proof of work")
+	ErrProofOfWorkOutdated      = errors.New("the difficulty has risen since this challenge was issued, request a new one")
+)
+
+// ProofOfWorkChallenge asks the client for a string solution such that the
+// SHA-256 hash of "challenge:solution" starts with Difficulty zero bits.
+type ProofOfWorkChallenge struct {
+	Challenge  string `json:"challenge"`
+	Difficulty int    `json:"difficulty"`
+	Algorithm  string `json:"algorithm"`
+	ExpiresIn  int64  `json:"expires_in"`
+}
+
+type ProofOfWorkService struct {
+	cacheRepo repository.CacheRepository
+	signer    utility.ActionTokenSigner
+	cfg       utility.ProofOfWorkConfig
+}
+
+func NewProofOfWorkService(cacheRepo repository.CacheRepository, signer utility.ActionTokenSigner,
+	cfg utility.ProofOfWorkConfig) ProofOfWorkService {
+	return ProofOfWorkService{
+		cacheRepo: cacheRepo,
+		signer:    signer,
+		cfg:       cfg,
+	}
+}
+
+func (s *ProofOfWorkService) Requires(action string) bool {
+	return s.cfg.Requires(action)
+}
+
+// IssueChallenge signs a challenge for one action from the client's IP
+// range. Nothing is stored until the challenge is solved.
+func (s *ProofOfWorkService) IssueChallenge(ctx context.Context, action, clientIP string) (*ProofOfWorkChallenge, error) {
+	if !s.cfg.Requires(action) {
+		return nil, ErrUnknownProofOfWorkAction
+	}
+
+	ipRange := utility.IPRange(clientIP, s.cfg.IPv4PrefixBits, s.cfg.IPv6PrefixBits)
+	difficulty, err := s.difficulty(ctx, action, ipRange)
+	if err != nil {
+		return nil, err
+	}
+
+	token := &utility.ActionToken{Purpose: proofOfWorkPurpose, Resource: action + "@" + ipRange, Difficulty: difficulty}
+	challenge, err := s.signer.Sign(token, s.cfg.ChallengeTTL)
+	if err != nil {
+		return nil, err
+	}
+	return &ProofOfWorkChallenge{
+		Challenge:  challenge,
+		Difficulty: difficulty,
+		Algorithm:  "sha256",
+		ExpiresIn:  int64(s.cfg.ChallengeTTL.Seconds()),
+	}, nil
+}
+
+// Verify checks a solution for action when the action requires one. Each
+// challenge works once, and every solved one counts towards the burst
+// detection of its IP range. A challenge issued before the difficulty rose is
+// refused, so challenges collected while the range was quiet cannot be spent
+// during a burst.
+func (s *ProofOfWorkService) Verify(ctx context.Context, action, clientIP, challenge, solution string) error {
+	if !s.cfg.Requires(action) {
+		return nil
+	}
+	if challenge == "" || solution == "" {
+		return ErrProofOfWorkRequired
+	}
+
+	ipRange := utility.IPRange(clientIP, s.cfg.IPv4PrefixBits, s.cfg.IPv6PrefixBits)
+	token, err := s.signer.Verify(proofOfWorkPurpose, challenge)
+	if err != nil || token.Resource != action+"@"+ipRange {
+		return ErrInvalidProofOfWork
+	}
+	if !utility.SolvesProofOfWork(challenge, solution, token.Difficulty) {
+		return ErrInvalidProofOfWork
+	}
+	difficulty, err := s.difficulty(ctx, action, ipRange)
+	if err != nil {
+		return err
+	}
+	if token.Difficulty < difficulty {
+		return ErrProofOfWorkOutdated
+	}
+
+	fresh, err := s.cacheRepo.AcquireThrottle(ctx, proofOfWorkPurpose+":"+token.Nonce, s.cfg.ChallengeTTL)
+	if err != nil {
+		return err
+	}
+	if !fresh {
+		return ErrInvalidProofOfWork
+	}
+
+	_, err = s.cacheRepo.IncrementCounter(ctx, proofOfWorkPurpose+":"+action+":"+ipRange, s.cfg.BurstWindow)
+	return err
+}
+
+// difficulty adds one bit, doubling the expected work, for every doubling of
+// recent solutions from the range past the burst threshold.
+func (s *ProofOfWorkService) difficulty(ctx context.Context, action, ipRange string) (int, error) {
+	recent, err := s.cacheRepo.GetCounter(ctx, proofOfWorkPurpose+":"+action+":"+ipRange)
+	if err != nil {
+		return 0, err
+	}
+
+	difficulty := s.cfg.BaseDifficulty
+	threshold := int64(s.cfg.BurstThreshold)
+	if threshold > 0 && recent >= threshold {
+		difficulty += bits.Len64(uint64(recent / threshold))
+	}
+	if difficulty > s.cfg.MaxDifficulty {
+		difficulty = s.cfg.MaxDifficulty
+	}
+	return difficulty, nil
+}
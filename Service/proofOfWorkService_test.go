@@ -0,0 +1,73 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"redditBack/utility"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func solveProofOfWork(t *testing.T, challenge *ProofOfWorkChallenge) string {
+	t.Helper()
+	for i := 0; i < 1<<20; i++ {
+		solution := strconv.Itoa(i)
+		if utility.SolvesProofOfWork(challenge.Challenge, solution, challenge.Difficulty) {
+			return solution
+		}
+	}
+	t.Fatalf("no solution found for difficulty %d", challenge.Difficulty)
+	return ""
+}
+
+func TestProofOfWorkRefusesChallengesFromBeforeABurst(t *testing.T) {
+	ctx := context.Background()
+	cfg := utility.ProofOfWorkConfig{
+		RequiredFor:    []string{"signup"},
+		BaseDifficulty: 1,
+		MaxDifficulty:  6,
+		ChallengeTTL:   time.Minute,
+		BurstWindow:    time.Minute,
+		BurstThreshold: 2,
+		IPv4PrefixBits: 24,
+		IPv6PrefixBits: 48,
+	}
+	service := NewProofOfWorkService(newFakeCacheRepo(), utility.NewActionTokenSigner("secret"), cfg)
+
+	// A bot collects challenges while the range is quiet.
+	var stockpile []*ProofOfWorkChallenge
+	for i := 0; i < 3; i++ {
+		challenge, err := service.IssueChallenge(ctx, "signup", "192.0.2.1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if challenge.Difficulty != cfg.BaseDifficulty {
+			t.Fatalf("quiet range difficulty = %d, want %d", challenge.Difficulty, cfg.BaseDifficulty)
+		}
+		stockpile = append(stockpile, challenge)
+	}
+
+	// The first ones spent start a burst, after which the rest are stale.
+	for _, challenge := range stockpile[:2] {
+		if err := service.Verify(ctx, "signup", "192.0.2.2", challenge.Challenge, solveProofOfWork(t, challenge)); err != nil {
+			t.Fatalf("Verify before the burst: %v", err)
+		}
+	}
+	stale := stockpile[2]
+	err := service.Verify(ctx, "signup", "192.0.2.3", stale.Challenge, solveProofOfWork(t, stale))
+	if !errors.Is(err, ErrProofOfWorkOutdated) {
+		t.Fatalf("Verify with a stockpiled challenge = %v, want ErrProofOfWorkOutdated", err)
+	}
+
+	fresh, err := service.IssueChallenge(ctx, "signup", "192.0.2.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fresh.Difficulty <= cfg.BaseDifficulty {
+		t.Fatalf("difficulty during the burst = %d, want more than %d", fresh.Difficulty, cfg.BaseDifficulty)
+	}
+	if err := service.Verify(ctx, "signup", "192.0.2.1", fresh.Challenge, solveProofOfWork(t, fresh)); err != nil {
+		t.Fatalf("Verify with a fresh challenge: %v", err)
+	}
+}
@@ -0,0 +1,98 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log"
+	"redditBack/repository"
+	"redditBack/utility"
+)
+
+var (
+	ErrForbidden           = errors.New("forbidden")
+	ErrInvalidRole         = errors.New("invalid role")
+	ErrRoleRequiresMFA     = errors.New("the user must enable two-factor authentication before getting this role")
+	ErrCannotChangeOwnRole = errors.New("administrators cannot change their own role")
+)
+
+type RoleService struct {
+	userRepo repository.UserRepository
+}
+
+func NewRoleService(userRepo repository.UserRepository) RoleService {
+	return RoleService{userRepo: userRepo}
+}
+
+// SetRole changes a user's role. The acting administrator's permission is
+// checked against the database here as well as in the route middleware.
+func (s *RoleService) SetRole(ctx context.Context, principal *utility.Principal, userID uint, role string) error {
+	actor, err := s.userRepo.FindByID(ctx, principal.UserID)
+	if err != nil || actor == nil {
+		return errors.New("Error in username")
+	}
+	if !utility.UserCan(actor, utility.PermissionManageUsers) {
+		return ErrForbidden
+	}
+	if !utility.IsValidRole(role) {
+		return ErrInvalidRole
+	}
+	if actor.ID == userID {
+		return ErrCannotChangeOwnRole
+	}
+
+	user, err := s.userRepo.FindByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return ErrUserNotFound
+	}
+	if utility.IsElevatedRole(role) && !user.TOTPEnabled {
+		return ErrRoleRequiresMFA
+	}
+
+	if err := s.userRepo.UpdateRole(ctx, user.ID, role); err != nil {
+		if errors.Is(err, repository.ErrUserNotFound) {
+			return ErrUserNotFound
+		}
+		return err
+	}
+	log.Printf("user %d changed the role of user %d from %s to %s", actor.ID, user.ID, user.Role, role)
+	return nil
+}
+
+// authorizeOwnerOr lets the owner of a resource act on it, and anyone else
+// whose role grants permission. Elevated access is checked against the
+// database, since the role in the token may be outdated.
+func authorizeOwnerOr(ctx context.Context, userRepo repository.UserRepository, principal *utility.Principal,
+	ownerID *uint, permission string) (bool, error) {
+	if ownerID != nil && *ownerID == principal.UserID {
+		return true, nil
+	}
+	if permission == "" || !utility.RoleHasPermission(principal.Role, permission) {
+		return false, nil
+	}
+	user, err := userRepo.FindByID(ctx, principal.UserID)
+	if err != nil {
+		return false, err
+	}
+	return utility.UserCan(user, permission), nil
+}
+
+// BootstrapAdmins gives the configured usernames the admin role. Like any
+// other administrator they still need two-factor authentication to use it.
+func (s *RoleService) BootstrapAdmins(ctx context.Context, usernames []string) {
+	for _, username := range usernames {
+		user, err := s.userRepo.FindByUsername(ctx, username)
+		if err != nil || user == nil {
+			log.Printf("configured administrator %q not found", username)
+			continue
+		}
+		if user.Role == utility.RoleAdmin {
+			continue
+		}
+		if err := s.userRepo.UpdateRole(ctx, user.ID, utility.RoleAdmin); err != nil {
+			log.Printf("failed to make %q an administrator: %v", username, err)
+		}
+	}
+}
@@ -0,0 +1,35 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"redditBack/model"
+	"redditBack/utility"
+	"testing"
+)
+
+func TestAdminActionsOnUnknownUser(t *testing.T) {
+	ctx := context.Background()
+	users := newFakeUserRepo(&model.User{ID: 1, Username: "admin", Role: utility.RoleAdmin, TOTPEnabled: true})
+	roles := NewRoleService(users)
+	guard := NewLoginGuardService(newFakeLoginAttemptRepo(), users, utility.LoginProtectionConfig{})
+	mfa := NewMFAService(users, nil, nil, TokenService{}, guard, utility.MFAConfig{})
+
+	tests := []struct {
+		name   string
+		action func() error
+	}{
+		{"set role", func() error {
+			return roles.SetRole(ctx, &utility.Principal{UserID: 1}, 42, utility.RoleModerator)
+		}},
+		{"unlock", func() error { return guard.Unlock(ctx, 42) }},
+		{"reset two-factor", func() error { return mfa.ResetTOTP(ctx, 42) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.action(); !errors.Is(err, ErrUserNotFound) {
+				t.Fatalf("error = %v, want ErrUserNotFound", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,270 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log"
+	"redditBack/model"
+	"redditBack/repository"
+	"redditBack/utility"
+	"time"
+)
+
+var (
+	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+	ErrRefreshTokenReused  = errors.New("refresh token reuse detected")
+	ErrSessionNotFound     = errors.New("session not found")
+)
+
+type TokenPair struct {
+	AccessToken      string `json:"token"`
+	RefreshToken     string `json:"refresh_token"`
+	ExpiresIn        int64  `json:"expires_in"`
+	RefreshExpiresIn int64  `json:"refresh_expires_in"`
+	// CSRFToken is only handed out in a cookie, to browser clients.
+	CSRFToken string `json:"-"`
+}
+
+// SessionMeta describes the client a session was started or refreshed from.
+type SessionMeta struct {
+	Device    string
+	IPAddress string
+	UserAgent string
+}
+
+type SessionInfo struct {
+	*model.Session
+	Current bool `json:"current"`
+}
+
+type TokenService struct {
+	refreshRepo  repository.RefreshTokenRepository
+	sessionRepo  repository.SessionRepository
+	userRepo     repository.UserRepository
+	cacheRepo    repository.CacheRepository
+	keys         *utility.KeyRing
+	accessTTL    time.Duration
+	refreshTTL   time.Duration
+	reauthWindow time.Duration
+}
+
+func NewTokenService(refreshRepo repository.RefreshTokenRepository, sessionRepo repository.SessionRepository,
+	userRepo repository.UserRepository, cacheRepo repository.CacheRepository,
+	keys *utility.KeyRing, cfg utility.TokenConfig) TokenService {
+	return TokenService{
+		refreshRepo:  refreshRepo,
+		sessionRepo:  sessionRepo,
+		userRepo:     userRepo,
+		cacheRepo:    cacheRepo,
+		keys:         keys,
+		accessTTL:    cfg.AccessTokenTTL,
+		refreshTTL:   cfg.RefreshTokenTTL,
+		reauthWindow: cfg.ReauthWindow,
+	}
+}
+
+// IssueTokens starts a new session, and with it a new token family, for a
+// freshly authenticated user.
+func (s *TokenService) IssueTokens(ctx context.Context, user *model.User, meta SessionMeta) (*TokenPair, error) {
+	sessionID, err := utility.GenerateOpaqueToken()
+	if err != nil {
+		return nil, err
+	}
+	csrfToken, err := utility.GenerateOpaqueToken()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	session := &model.Session{
+		ID:         sessionID,
+		UserID:     user.ID,
+		Device:     meta.Device,
+		IPAddress:  meta.IPAddress,
+		UserAgent:  meta.UserAgent,
+		LastSeenAt: now,
+		ExpiresAt:  now.Add(s.refreshTTL),
+		CSRFToken:  csrfToken,
+	}
+	if err := s.sessionRepo.Create(ctx, session); err != nil {
+		return nil, err
+	}
+
+	return s.issue(ctx, user, session)
+}
+
+// Refresh exchanges a refresh token for a new token pair in the same family.
+// Presenting a token that was already exchanged revokes the whole family,
+// since either the client or an attacker is holding a stolen copy.
+func (s *TokenService) Refresh(ctx context.Context, refreshToken string, meta SessionMeta) (*TokenPair, error) {
+	stored, err := s.refreshRepo.FindByHash(ctx, utility.HashToken(refreshToken))
+	if err != nil {
+		return nil, err
+	}
+	if stored == nil || stored.RevokedAt != nil || time.Now().After(stored.ExpiresAt) {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	fresh, err := s.refreshRepo.MarkUsed(ctx, stored.ID)
+	if err != nil {
+		return nil, err
+	}
+	if !fresh {
+		log.Printf("refresh token reuse detected for user %d, revoking family %s", stored.UserID, stored.FamilyID)
+		if err := s.revokeSession(ctx, stored.FamilyID); err != nil {
+			return nil, err
+		}
+		return nil, ErrRefreshTokenReused
+	}
+
+	user, err := s.userRepo.FindByID(ctx, stored.UserID)
+	if err != nil || user == nil {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	session, err := s.sessionRepo.FindByID(ctx, stored.FamilyID)
+	if err != nil || session == nil {
+		return nil, ErrInvalidRefreshToken
+	}
+	err = s.sessionRepo.Touch(ctx, session.ID, meta.IPAddress, meta.UserAgent, time.Now().Add(s.refreshTTL))
+	if err != nil {
+		return nil, err
+	}
+
+	return s.issue(ctx, user, session)
+}
+
+func (s *TokenService) ListSessions(ctx context.Context, principal *utility.Principal) ([]SessionInfo, error) {
+	sessions, err := s.sessionRepo.ListActiveForUser(ctx, principal.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	infos := make([]SessionInfo, 0, len(sessions))
+	for _, session := range sessions {
+		infos = append(infos, SessionInfo{Session: session, Current: session.ID == principal.SessionID})
+	}
+	return infos, nil
+}
+
+// RecentlyAuthenticated reports whether the principal's session signed in
+// within the reauthentication window. Refreshing tokens does not restart the
+// window, and personal access tokens never count.
+func (s *TokenService) RecentlyAuthenticated(ctx context.Context, principal *utility.Principal) (bool, error) {
+	if principal.SessionID == "" || s.reauthWindow <= 0 {
+		return false, nil
+	}
+	session, err := s.sessionRepo.FindByID(ctx, principal.SessionID)
+	if err != nil {
+		return false, err
+	}
+	if session == nil || session.UserID != principal.UserID || session.RevokedAt != nil {
+		return false, nil
+	}
+	return time.Since(session.CreatedAt) <= s.reauthWindow, nil
+}
+
+// RevokeSession signs one of the user's own sessions out.
+func (s *TokenService) RevokeSession(ctx context.Context, principal *utility.Principal, sessionID string) error {
+	session, err := s.sessionRepo.FindByID(ctx, sessionID)
+	if err != nil {
+		return err
+	}
+	if session == nil || session.UserID != principal.UserID {
+		return ErrSessionNotFound
+	}
+	return s.revokeSession(ctx, sessionID)
+}
+
+// RevokeOtherSessions signs out every session of the user but the one making
+// the request.
+func (s *TokenService) RevokeOtherSessions(ctx context.Context, principal *utility.Principal) error {
+	sessions, err := s.sessionRepo.ListActiveForUser(ctx, principal.UserID)
+	if err != nil {
+		return err
+	}
+	for _, session := range sessions {
+		if session.ID == principal.SessionID {
+			continue
+		}
+		if err := s.revokeSession(ctx, session.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *TokenService) SignOutEverywhere(ctx context.Context, principal *utility.Principal) error {
+	return s.RevokeAllSessions(ctx, principal.UserID)
+}
+
+// RevokeAllSessions signs the user out everywhere. Bumping the token version
+// also rejects access tokens of sessions we might not know about.
+func (s *TokenService) RevokeAllSessions(ctx context.Context, userID uint) error {
+	user, err := s.userRepo.FindByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return errors.New("user not found")
+	}
+
+	if _, err := s.cacheRepo.IncrementTokenVersion(ctx, user.ID); err != nil {
+		return err
+	}
+	if err := s.sessionRepo.RevokeAllForUser(ctx, user.ID); err != nil {
+		return err
+	}
+	return s.refreshRepo.RevokeAllForUser(ctx, user.ID)
+}
+
+func (s *TokenService) revokeSession(ctx context.Context, sessionID string) error {
+	if err := s.refreshRepo.RevokeFamily(ctx, sessionID); err != nil {
+		return err
+	}
+	if err := s.sessionRepo.Revoke(ctx, sessionID); err != nil {
+		return err
+	}
+	return s.cacheRepo.RevokeSession(ctx, sessionID, s.accessTTL)
+}
+
+func (s *TokenService) issue(ctx context.Context, user *model.User, session *model.Session) (*TokenPair, error) {
+	version, err := s.cacheRepo.GetTokenVersion(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	claims := utility.NewClaims(user.ID)
+	claims.SessionID = session.ID
+	claims.CSRF = session.CSRFToken
+	claims.Version = version
+	claims.Role = user.Role
+	claims.EmailVerified = user.EmailVerified
+	accessToken, err := utility.GenerateToken(s.keys, claims, s.accessTTL)
+	if err != nil {
+		return nil, err
+	}
+
+	refreshToken, err := utility.GenerateOpaqueToken()
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.refreshRepo.Create(ctx, &model.RefreshToken{
+		TokenHash: utility.HashToken(refreshToken),
+		FamilyID:  session.ID,
+		UserID:    user.ID,
+		ExpiresAt: time.Now().Add(s.refreshTTL),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &TokenPair{
+		AccessToken:      accessToken,
+		RefreshToken:     refreshToken,
+		ExpiresIn:        int64(s.accessTTL.Seconds()),
+		RefreshExpiresIn: int64(s.refreshTTL.Seconds()),
+		CSRFToken:        session.CSRFToken,
+	}, nil
+}
@@ -6,78 +6,151 @@ import (
 	"fmt"
 	"redditBack/model"
 	"redditBack/repository"
+	"redditBack/utility"
 )
 
 var (
 	ErrInvalidVoteValue = errors.New("vote value must be 1 or -1")
-	ErrSelfVote         = errors.New("cannot vote on your own post")
+	ErrSelfVote         = errors.New("cannot vote on your own post or comment")
 )
 
 type VoteService struct {
-	voteRepo  repository.VoteRepository
-	postRepo  repository.PostRepository
-	userRepo  repository.UserRepository
-	cacheRepo repository.CacheRepository
+	voteRepo    repository.VoteRepository
+	postRepo    repository.PostRepository
+	commentRepo repository.CommentRepository
+	userRepo    repository.UserRepository
+	cacheRepo   repository.CacheRepository
+	verifyCfg   utility.EmailVerificationConfig
 }
 
 func NewVoteService(voteRepo repository.VoteRepository, postRepo repository.PostRepository,
-	userRepo repository.UserRepository, cacheRepo repository.CacheRepository) VoteService {
+	commentRepo repository.CommentRepository, userRepo repository.UserRepository, cacheRepo repository.CacheRepository,
+	verifyCfg utility.EmailVerificationConfig) VoteService {
 	return VoteService{
-		voteRepo:  voteRepo,
-		postRepo:  postRepo,
-		userRepo:  userRepo,
-		cacheRepo: cacheRepo,
+		voteRepo:    voteRepo,
+		postRepo:    postRepo,
+		commentRepo: commentRepo,
+		userRepo:    userRepo,
+		cacheRepo:   cacheRepo,
+		verifyCfg:   verifyCfg,
 	}
 }
 
-func (s *VoteService) VotePost(ctx context.Context, postID uint, username string, voteValue int) error {
+// voteTarget is a post or a comment as far as voting is concerned.
+type voteTarget struct {
+	ownerID *uint
+	// previous is the caller's current vote, 0 when there is none.
+	previous int
+	// store saves the caller's new vote, where 0 removes it.
+	store       func(voteValue int) error
+	updateScore func(delta int) error
+}
+
+// castVote applies the rules shared by post and comment votes: 1 and -1 vote,
+// 0 clears the vote, nobody votes on their own content, and the cached score
+// moves by the difference to the previous vote.
+func (s *VoteService) castVote(ctx context.Context, principal *utility.Principal, target voteTarget, voteValue int) error {
+	if err := requireVerifiedEmail(ctx, s.userRepo, s.verifyCfg, principal, "vote"); err != nil {
+		return err
+	}
+	if target.ownerID != nil && *target.ownerID == principal.UserID {
+		return ErrSelfVote
+	}
+
+	voteDelta := voteValue - target.previous
+	if voteDelta == 0 {
+		return nil
+	}
+	if err := target.store(voteValue); err != nil {
+		return fmt.Errorf("failed to process vote: %w", err)
+	}
+	if err := target.updateScore(voteDelta); err != nil {
+		return fmt.Errorf("failed to update score: %w", err)
+	}
+	return nil
+}
+
+func (s *VoteService) VotePost(ctx context.Context, postID uint, principal *utility.Principal, voteValue int) error {
 
 	if voteValue != 1 && voteValue != -1 && voteValue != 0 {
 		return ErrInvalidVoteValue
 	}
-	fmt.Printf("postID : %u", postID)
 	post, err := s.postRepo.FindByID(ctx, postID)
 	if err != nil || post == nil {
 		return fmt.Errorf("post not found")
 	}
-	user, err := s.userRepo.FindByUsername(ctx, username)
+
+	existingVote, err := s.voteRepo.FindByUserAndPost(ctx, principal.UserID, postID)
 	if err != nil {
-		return fmt.Errorf("can't find user with username %s", username)
+		return fmt.Errorf("failed to process vote: %w", err)
 	}
-	fmt.Print(post.UserID)
-	if post.UserID == user.ID {
-		return ErrSelfVote
+	target := voteTarget{
+		ownerID: post.UserID,
+		store: func(voteValue int) error {
+			if voteValue == 0 {
+				return s.voteRepo.DeleteByUserAndPost(ctx, principal.UserID, postID)
+			}
+			if existingVote != nil {
+				existingVote.VoteValue = voteValue
+				return s.voteRepo.Update(ctx, existingVote)
+			}
+			return s.voteRepo.Create(ctx, &model.Vote{
+				UserID:    principal.UserID,
+				PostID:    postID,
+				VoteValue: voteValue,
+			})
+		},
+		updateScore: func(delta int) error {
+			return s.postRepo.UpdateScore(ctx, postID, delta)
+		},
+	}
+	if existingVote != nil {
+		target.previous = existingVote.VoteValue
 	}
 
-	existingVote, err := s.voteRepo.FindByUserAndPost(ctx, user.ID, postID)
-	var voteDelta int
-
-	if err == nil && existingVote != nil {
-		voteDelta = voteValue - existingVote.VoteValue
-		existingVote.VoteValue = voteValue
-		err = s.voteRepo.Update(ctx, existingVote)
-	} else {
+	if err := s.castVote(ctx, principal, target, voteValue); err != nil {
+		return err
+	}
 
-		voteDelta = voteValue
-		fmt.Printf("%u %s %s", user.ID, postID, voteValue)
-		newVote := &model.Vote{
-			UserID:    user.ID,
-			PostID:    postID,
-			VoteValue: voteValue,
+	s.cacheRepo.InvalidatePost(ctx, postID)
+	s.cacheRepo.InvalidatePostRanking(ctx, post.CommunityID)
+	return nil
+}
 
-		}
-		err = s.voteRepo.Create(ctx, newVote)
+// VoteComment votes on a comment with the same rules as VotePost. Removed
+// comments take no votes.
+func (s *VoteService) VoteComment(ctx context.Context, commentID uint, principal *utility.Principal, voteValue int) error {
+	if voteValue != 1 && voteValue != -1 && voteValue != 0 {
+		return ErrInvalidVoteValue
+	}
+	comment, err := s.commentRepo.FindByID(ctx, commentID)
+	if err != nil || comment == nil || comment.RemovedAt != nil {
+		return errors.New("comment not found")
 	}
 
+	existingVote, err := s.voteRepo.FindByUserAndComment(ctx, principal.UserID, commentID)
 	if err != nil {
 		return fmt.Errorf("failed to process vote: %w", err)
 	}
-
-	err = s.postRepo.UpdateScore(ctx, postID, voteDelta)
-	if err != nil {
-		return fmt.Errorf("failed to update post score: %w", err)
+	target := voteTarget{
+		ownerID: comment.UserID,
+		store: func(voteValue int) error {
+			if voteValue == 0 {
+				return s.voteRepo.DeleteByUserAndComment(ctx, principal.UserID, commentID)
+			}
+			return s.voteRepo.SaveCommentVote(ctx, &model.CommentVote{
+				UserID:    principal.UserID,
+				CommentID: commentID,
+				VoteValue: voteValue,
+			})
+		},
+		updateScore: func(delta int) error {
+			return s.commentRepo.UpdateScore(ctx, commentID, delta)
+		},
+	}
+	if existingVote != nil {
+		target.previous = existingVote.VoteValue
 	}
 
-	s.cacheRepo.InvalidatePostRanking(ctx)
-	return nil
+	return s.castVote(ctx, principal, target, voteValue)
 }
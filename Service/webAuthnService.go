@@ -0,0 +1,320 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"redditBack/model"
+	"redditBack/repository"
+	"redditBack/utility"
+	"strconv"
+	"strings"
+)
+
+const (
+	passkeyRegisterPurpose = "passkey_register"
+	passkeyLoginPurpose    = "passkey_login"
+	passkeyReauthPurpose   = "passkey_reauth"
+	defaultPasskeyName     = "Passkey"
+)
+
+var (
+	ErrInvalidPasskey          = errors.New("invalid or unknown passkey")
+	ErrInvalidPasskeyChallenge = errors.New("invalid or expired passkey challenge")
+	ErrPasskeyExists           = errors.New("passkey is already registered")
+)
+
+// passkeyCeremony is kept in the cache between the two steps of a
+// registration or login, keyed by the challenge the browser signs.
+type passkeyCeremony struct {
+	UserID       uint   `json:"user_id,omitempty"`
+	Name         string `json:"name,omitempty"`
+	SecondFactor bool   `json:"second_factor,omitempty"`
+}
+
+// PasskeyLoginResult is a verified passkey login. MultiFactor is set when the
+// passkey completes the login on its own: it answered the second factor
+// challenge of another login, or the authenticator verified the user, e.g.
+// with a PIN or biometrics.
+type PasskeyLoginResult struct {
+	User        *model.User
+	MultiFactor bool
+}
+
+// PasskeyAssertion is the decoded response of navigator.credentials.get().
+type PasskeyAssertion struct {
+	CredentialID      []byte
+	ClientDataJSON    []byte
+	AuthenticatorData []byte
+	Signature         []byte
+}
+
+type WebAuthnService struct {
+	credentialRepo repository.WebAuthnCredentialRepository
+	userRepo       repository.UserRepository
+	cacheRepo      repository.CacheRepository
+	cfg            utility.WebAuthnConfig
+}
+
+func NewWebAuthnService(credentialRepo repository.WebAuthnCredentialRepository, userRepo repository.UserRepository,
+	cacheRepo repository.CacheRepository, cfg utility.WebAuthnConfig) WebAuthnService {
+	return WebAuthnService{
+		credentialRepo: credentialRepo,
+		userRepo:       userRepo,
+		cacheRepo:      cacheRepo,
+		cfg:            cfg,
+	}
+}
+
+// BeginRegistration returns the options for navigator.credentials.create().
+func (s *WebAuthnService) BeginRegistration(ctx context.Context, principal *utility.Principal, name string) (*utility.WebAuthnCreationOptions, error) {
+	user, err := s.userRepo.FindByID(ctx, principal.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, ErrUserNotFound
+	}
+	existing, err := s.credentialRepo.ListForUser(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	name = strings.TrimSpace(name)
+	if name == "" {
+		name = defaultPasskeyName
+	}
+	challenge, err := s.startCeremony(ctx, passkeyRegisterPurpose, passkeyCeremony{UserID: user.ID, Name: name})
+	if err != nil {
+		return nil, err
+	}
+
+	displayName := user.DisplayName
+	if displayName == "" {
+		displayName = user.Username
+	}
+	// The user handle must not identify the person, the numeric ID is enough.
+	userHandle := []byte(strconv.FormatUint(uint64(user.ID), 10))
+	return utility.NewWebAuthnCreationOptions(s.cfg, challenge, userHandle, user.Username, displayName,
+		credentialIDs(existing)), nil
+}
+
+// FinishRegistration verifies the authenticator's response and stores the
+// new credential.
+func (s *WebAuthnService) FinishRegistration(ctx context.Context, principal *utility.Principal,
+	clientDataJSON, attestationObject []byte) (*model.WebAuthnCredential, error) {
+	clientData, err := utility.ParseWebAuthnClientData(clientDataJSON)
+	if err != nil {
+		return nil, err
+	}
+	ceremony, err := s.finishCeremony(ctx, passkeyRegisterPurpose, clientData.Challenge)
+	if err != nil {
+		return nil, err
+	}
+	if ceremony.UserID != principal.UserID {
+		return nil, ErrInvalidPasskeyChallenge
+	}
+
+	registration, err := utility.VerifyWebAuthnRegistration(s.cfg, clientData.Challenge, clientDataJSON, attestationObject)
+	if err != nil {
+		return nil, err
+	}
+	existing, err := s.credentialRepo.FindByCredentialID(ctx, registration.CredentialID)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		return nil, ErrPasskeyExists
+	}
+
+	credential := &model.WebAuthnCredential{
+		UserID:       principal.UserID,
+		Name:         ceremony.Name,
+		CredentialID: registration.CredentialID,
+		PublicKey:    registration.PublicKey,
+		SignCount:    registration.SignCount,
+	}
+	if err := s.credentialRepo.Create(ctx, credential); err != nil {
+		return nil, err
+	}
+	return credential, nil
+}
+
+func (s *WebAuthnService) ListCredentials(ctx context.Context, principal *utility.Principal) ([]*model.WebAuthnCredential, error) {
+	return s.credentialRepo.ListForUser(ctx, principal.UserID)
+}
+
+func (s *WebAuthnService) DeleteCredential(ctx context.Context, principal *utility.Principal, id uint) error {
+	return s.credentialRepo.Delete(ctx, id, principal.UserID)
+}
+
+// BeginLogin returns the options for navigator.credentials.get(). Without an
+// MFA challenge any discoverable passkey may log in. With one, the passkey
+// answers the second factor of that login and must belong to its user; the
+// challenge is used up either way. A challenge from a passkey login takes no
+// passkey as its second factor.
+func (s *WebAuthnService) BeginLogin(ctx context.Context, mfaChallenge string) (*utility.WebAuthnRequestOptions, error) {
+	var ceremony passkeyCeremony
+	var allowed [][]byte
+	if mfaChallenge != "" {
+		state, err := consumeMFAChallenge(ctx, s.cacheRepo, mfaChallenge)
+		if err != nil {
+			return nil, err
+		}
+		if state.ViaPasskey {
+			return nil, ErrInvalidMFAChallenge
+		}
+		credentials, err := s.credentialRepo.ListForUser(ctx, state.UserID)
+		if err != nil {
+			return nil, err
+		}
+		if len(credentials) == 0 {
+			return nil, ErrInvalidMFAChallenge
+		}
+		ceremony = passkeyCeremony{UserID: state.UserID, SecondFactor: true}
+		allowed = credentialIDs(credentials)
+	}
+
+	challenge, err := s.startCeremony(ctx, passkeyLoginPurpose, ceremony)
+	if err != nil {
+		return nil, err
+	}
+	return utility.NewWebAuthnRequestOptions(s.cfg, challenge, allowed), nil
+}
+
+// FinishLogin verifies an assertion and returns the credential's user.
+func (s *WebAuthnService) FinishLogin(ctx context.Context, credentialID, clientDataJSON, authenticatorData,
+	signature []byte) (*PasskeyLoginResult, error) {
+	ceremony, credential, assertion, err := s.verifyAssertion(ctx, passkeyLoginPurpose, PasskeyAssertion{
+		CredentialID:      credentialID,
+		ClientDataJSON:    clientDataJSON,
+		AuthenticatorData: authenticatorData,
+		Signature:         signature,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := s.userRepo.FindByID(ctx, credential.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, ErrInvalidPasskey
+	}
+	return &PasskeyLoginResult{User: user, MultiFactor: ceremony.SecondFactor || assertion.UserVerified}, nil
+}
+
+// BeginReauthentication returns the options for navigator.credentials.get()
+// when a signed in user confirms a sensitive change with one of their
+// passkeys.
+func (s *WebAuthnService) BeginReauthentication(ctx context.Context, principal *utility.Principal) (*utility.WebAuthnRequestOptions, error) {
+	credentials, err := s.credentialRepo.ListForUser(ctx, principal.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if len(credentials) == 0 {
+		return nil, ErrInvalidPasskey
+	}
+
+	challenge, err := s.startCeremony(ctx, passkeyReauthPurpose, passkeyCeremony{UserID: principal.UserID})
+	if err != nil {
+		return nil, err
+	}
+	return utility.NewWebAuthnRequestOptions(s.cfg, challenge, credentialIDs(credentials)), nil
+}
+
+// VerifyReauthentication checks an assertion answering BeginReauthentication
+// with a passkey of the same user.
+func (s *WebAuthnService) VerifyReauthentication(ctx context.Context, principal *utility.Principal, response PasskeyAssertion) error {
+	ceremony, _, _, err := s.verifyAssertion(ctx, passkeyReauthPurpose, response)
+	if err != nil {
+		return err
+	}
+	if ceremony.UserID != principal.UserID {
+		return ErrInvalidPasskey
+	}
+	return nil
+}
+
+// verifyAssertion finishes the ceremony the assertion answers and checks it
+// against the stored credential. A signature counter that does not move
+// forward suggests a cloned authenticator, so the assertion is refused.
+func (s *WebAuthnService) verifyAssertion(ctx context.Context, purpose string, response PasskeyAssertion) (*passkeyCeremony,
+	*model.WebAuthnCredential, *utility.WebAuthnAssertion, error) {
+	clientData, err := utility.ParseWebAuthnClientData(response.ClientDataJSON)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	ceremony, err := s.finishCeremony(ctx, purpose, clientData.Challenge)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	credential, err := s.credentialRepo.FindByCredentialID(ctx, response.CredentialID)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if credential == nil || (ceremony.UserID != 0 && credential.UserID != ceremony.UserID) {
+		return nil, nil, nil, ErrInvalidPasskey
+	}
+
+	assertion, err := utility.VerifyWebAuthnAssertion(s.cfg, clientData.Challenge, credential.PublicKey,
+		response.ClientDataJSON, response.AuthenticatorData, response.Signature)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	// Authenticators without a counter always report zero.
+	if (assertion.SignCount != 0 || credential.SignCount != 0) && assertion.SignCount <= credential.SignCount {
+		log.Printf("passkey %d of user %d reported sign count %d after %d, possibly cloned",
+			credential.ID, credential.UserID, assertion.SignCount, credential.SignCount)
+		return nil, nil, nil, ErrInvalidPasskey
+	}
+	if err := s.credentialRepo.RecordUse(ctx, credential.ID, assertion.SignCount); err != nil {
+		return nil, nil, nil, err
+	}
+	return ceremony, credential, assertion, nil
+}
+
+func (s *WebAuthnService) startCeremony(ctx context.Context, purpose string, ceremony passkeyCeremony) (string, error) {
+	challenge, err := utility.GenerateOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+	state, err := json.Marshal(ceremony)
+	if err != nil {
+		return "", err
+	}
+	err = s.cacheRepo.StoreOneTimeValue(ctx, purpose+":"+utility.HashToken(challenge), string(state), s.cfg.ChallengeTTL)
+	if err != nil {
+		return "", err
+	}
+	return challenge, nil
+}
+
+func (s *WebAuthnService) finishCeremony(ctx context.Context, purpose, challenge string) (*passkeyCeremony, error) {
+	if challenge == "" {
+		return nil, ErrInvalidPasskeyChallenge
+	}
+	stored, err := s.cacheRepo.ConsumeOneTimeValue(ctx, purpose+":"+utility.HashToken(challenge))
+	if err != nil {
+		return nil, err
+	}
+	if stored == "" {
+		return nil, ErrInvalidPasskeyChallenge
+	}
+	var ceremony passkeyCeremony
+	if err := json.Unmarshal([]byte(stored), &ceremony); err != nil {
+		return nil, ErrInvalidPasskeyChallenge
+	}
+	return &ceremony, nil
+}
+
+func credentialIDs(credentials []*model.WebAuthnCredential) [][]byte {
+	ids := make([][]byte, 0, len(credentials))
+	for _, credential := range credentials {
+		ids = append(ids, credential.CredentialID)
+	}
+	return ids
+}
@@ -0,0 +1,328 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"redditBack/model"
+	"redditBack/utility"
+	"redditBack/utility/webauthntest"
+	"testing"
+	"time"
+)
+
+type webAuthnTestEnv struct {
+	service     WebAuthnService
+	auth        AuthService
+	credentials *fakeCredentialRepo
+}
+
+func newWebAuthnTestEnv(users ...*model.User) *webAuthnTestEnv {
+	cache := newFakeCacheRepo()
+	env := &webAuthnTestEnv{credentials: &fakeCredentialRepo{}}
+	env.service = NewWebAuthnService(env.credentials, newFakeUserRepo(users...), cache, utility.WebAuthnConfig{
+		RPID:         "example.com",
+		RPName:       "Example",
+		Origins:      []string{"https://example.com"},
+		ChallengeTTL: time.Minute,
+	})
+	env.auth = AuthService{cacheRepo: cache, credentialRepo: env.credentials, challengeTTL: time.Minute}
+	return env
+}
+
+// register adds a passkey for the user and returns the authenticator
+// holding it.
+func (env *webAuthnTestEnv) register(t *testing.T, userID uint) *webauthntest.Authenticator {
+	t.Helper()
+	authenticator, err := webauthntest.NewAuthenticator("example.com", "https://example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	principal := &utility.Principal{UserID: userID}
+	options, err := env.service.BeginRegistration(context.Background(), principal, "Laptop")
+	if err != nil {
+		t.Fatalf("BeginRegistration: %v", err)
+	}
+	clientDataJSON, attestationObject := authenticator.Register(options.Challenge)
+	credential, err := env.service.FinishRegistration(context.Background(), principal, clientDataJSON, attestationObject)
+	if err != nil {
+		t.Fatalf("FinishRegistration: %v", err)
+	}
+	if credential.UserID != userID || credential.Name != "Laptop" {
+		t.Fatalf("registered credential = %+v", credential)
+	}
+	return authenticator
+}
+
+// login runs a passkey login, answering the challenge with authenticator.
+func (env *webAuthnTestEnv) login(t *testing.T, authenticator *webauthntest.Authenticator, mfaChallenge string) (*PasskeyLoginResult, error) {
+	t.Helper()
+	options, err := env.service.BeginLogin(context.Background(), mfaChallenge)
+	if err != nil {
+		t.Fatalf("BeginLogin: %v", err)
+	}
+	clientDataJSON, authenticatorData, signature := authenticator.Assert(options.Challenge)
+	return env.service.FinishLogin(context.Background(), authenticator.CredentialID, clientDataJSON, authenticatorData, signature)
+}
+
+func TestPasskeyRegisterThenLogin(t *testing.T) {
+	env := newWebAuthnTestEnv(&model.User{ID: 1, Username: "alice", Email: "alice@example.com"})
+	authenticator := env.register(t, 1)
+
+	login, err := env.login(t, authenticator, "")
+	if err != nil {
+		t.Fatalf("FinishLogin: %v", err)
+	}
+	if login.User.ID != 1 || login.MultiFactor {
+		t.Fatalf("login = user %d, multi-factor %v; want user 1 without multi-factor", login.User.ID, login.MultiFactor)
+	}
+
+	authenticator.UserVerified = true
+	login, err = env.login(t, authenticator, "")
+	if err != nil || !login.MultiFactor {
+		t.Fatalf("user verified login = %+v, %v; want multi-factor", login, err)
+	}
+}
+
+func TestPasskeyRegistrationRejectsDuplicates(t *testing.T) {
+	env := newWebAuthnTestEnv(&model.User{ID: 1, Username: "alice", Email: "alice@example.com"})
+	authenticator := env.register(t, 1)
+
+	principal := &utility.Principal{UserID: 1}
+	options, err := env.service.BeginRegistration(context.Background(), principal, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(options.ExcludeCredentials) != 1 {
+		t.Fatalf("exclude credentials = %v, want the registered passkey", options.ExcludeCredentials)
+	}
+	clientDataJSON, attestationObject := authenticator.Register(options.Challenge)
+	if _, err := env.service.FinishRegistration(context.Background(), principal, clientDataJSON, attestationObject); !errors.Is(err, ErrPasskeyExists) {
+		t.Fatalf("FinishRegistration error = %v, want ErrPasskeyExists", err)
+	}
+}
+
+func TestPasskeyBeginRegistrationUnknownUser(t *testing.T) {
+	env := newWebAuthnTestEnv()
+	_, err := env.service.BeginRegistration(context.Background(), &utility.Principal{UserID: 7}, "")
+	if !errors.Is(err, ErrUserNotFound) {
+		t.Fatalf("BeginRegistration error = %v, want ErrUserNotFound", err)
+	}
+}
+
+func TestPasskeyLoginRejectsInvalidAssertions(t *testing.T) {
+	tests := []struct {
+		name    string
+		assert  func(t *testing.T, env *webAuthnTestEnv, a *webauthntest.Authenticator) (clientDataJSON, authenticatorData, signature []byte)
+		wantErr error
+	}{
+		{
+			name: "wrong origin",
+			assert: func(t *testing.T, env *webAuthnTestEnv, a *webauthntest.Authenticator) ([]byte, []byte, []byte) {
+				a.Origin = "https://evil.example.com"
+				return a.Assert(beginLogin(t, env))
+			},
+			wantErr: utility.ErrInvalidWebAuthnResponse,
+		},
+		{
+			name: "wrong RP ID hash",
+			assert: func(t *testing.T, env *webAuthnTestEnv, a *webauthntest.Authenticator) ([]byte, []byte, []byte) {
+				a.RPID = "evil.example.com"
+				return a.Assert(beginLogin(t, env))
+			},
+			wantErr: utility.ErrInvalidWebAuthnResponse,
+		},
+		{
+			name: "unknown challenge",
+			assert: func(t *testing.T, env *webAuthnTestEnv, a *webauthntest.Authenticator) ([]byte, []byte, []byte) {
+				beginLogin(t, env)
+				return a.Assert("made-up-challenge")
+			},
+			wantErr: ErrInvalidPasskeyChallenge,
+		},
+		{
+			name: "registration challenge",
+			assert: func(t *testing.T, env *webAuthnTestEnv, a *webauthntest.Authenticator) ([]byte, []byte, []byte) {
+				options, err := env.service.BeginRegistration(context.Background(), &utility.Principal{UserID: 1}, "")
+				if err != nil {
+					t.Fatal(err)
+				}
+				return a.Assert(options.Challenge)
+			},
+			wantErr: ErrInvalidPasskeyChallenge,
+		},
+		{
+			name: "tampered signature",
+			assert: func(t *testing.T, env *webAuthnTestEnv, a *webauthntest.Authenticator) ([]byte, []byte, []byte) {
+				clientDataJSON, authenticatorData, signature := a.Assert(beginLogin(t, env))
+				signature[len(signature)-1] ^= 0x01
+				return clientDataJSON, authenticatorData, signature
+			},
+			wantErr: utility.ErrInvalidWebAuthnResponse,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newWebAuthnTestEnv(&model.User{ID: 1, Username: "alice", Email: "alice@example.com"})
+			authenticator := env.register(t, 1)
+
+			clientDataJSON, authenticatorData, signature := tt.assert(t, env, authenticator)
+			_, err := env.service.FinishLogin(context.Background(), authenticator.CredentialID, clientDataJSON,
+				authenticatorData, signature)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("FinishLogin error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func beginLogin(t *testing.T, env *webAuthnTestEnv) string {
+	t.Helper()
+	options, err := env.service.BeginLogin(context.Background(), "")
+	if err != nil {
+		t.Fatalf("BeginLogin: %v", err)
+	}
+	return options.Challenge
+}
+
+func TestPasskeyLoginRejectsReusedChallenge(t *testing.T) {
+	env := newWebAuthnTestEnv(&model.User{ID: 1, Username: "alice", Email: "alice@example.com"})
+	authenticator := env.register(t, 1)
+
+	clientDataJSON, authenticatorData, signature := authenticator.Assert(beginLogin(t, env))
+	if _, err := env.service.FinishLogin(context.Background(), authenticator.CredentialID, clientDataJSON,
+		authenticatorData, signature); err != nil {
+		t.Fatalf("first FinishLogin: %v", err)
+	}
+	_, err := env.service.FinishLogin(context.Background(), authenticator.CredentialID, clientDataJSON,
+		authenticatorData, signature)
+	if !errors.Is(err, ErrInvalidPasskeyChallenge) {
+		t.Fatalf("replayed FinishLogin error = %v, want ErrInvalidPasskeyChallenge", err)
+	}
+}
+
+func TestPasskeyLoginRejectsNonIncreasingSignCount(t *testing.T) {
+	env := newWebAuthnTestEnv(&model.User{ID: 1, Username: "alice", Email: "alice@example.com"})
+	authenticator := env.register(t, 1)
+
+	authenticator.SignCount = 5
+	if _, err := env.login(t, authenticator, ""); err != nil {
+		t.Fatalf("login with sign count 5: %v", err)
+	}
+
+	for _, signCount := range []uint32{5, 3} {
+		authenticator.SignCount = signCount
+		if _, err := env.login(t, authenticator, ""); !errors.Is(err, ErrInvalidPasskey) {
+			t.Fatalf("login with sign count %d after 5: error = %v, want ErrInvalidPasskey", signCount, err)
+		}
+	}
+
+	authenticator.SignCount = 6
+	if _, err := env.login(t, authenticator, ""); err != nil {
+		t.Fatalf("login with sign count 6: %v", err)
+	}
+}
+
+func TestPasskeySecondFactorRequiresOwnCredential(t *testing.T) {
+	alice := &model.User{ID: 1, Username: "alice", Email: "alice@example.com"}
+	bob := &model.User{ID: 2, Username: "bob", Email: "bob@example.com"}
+	env := newWebAuthnTestEnv(alice, bob)
+	alicePasskey := env.register(t, alice.ID)
+	bobPasskey := env.register(t, bob.ID)
+
+	challenge, err := env.auth.createMFAChallenge(context.Background(), bob, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := env.login(t, alicePasskey, challenge); !errors.Is(err, ErrInvalidPasskey) {
+		t.Fatalf("second factor with another user's passkey: error = %v, want ErrInvalidPasskey", err)
+	}
+
+	challenge, err = env.auth.createMFAChallenge(context.Background(), bob, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	login, err := env.login(t, bobPasskey, challenge)
+	if err != nil {
+		t.Fatalf("second factor with own passkey: %v", err)
+	}
+	if login.User.ID != bob.ID || !login.MultiFactor {
+		t.Fatalf("second factor login = user %d, multi-factor %v; want user %d with multi-factor", login.User.ID, login.MultiFactor, bob.ID)
+	}
+}
+
+func TestPasskeyIsNotBothFactors(t *testing.T) {
+	bob := &model.User{ID: 1, Username: "bob", Email: "bob@example.com", TOTPEnabled: true}
+	env := newWebAuthnTestEnv(bob)
+	authenticator := env.register(t, bob.ID)
+
+	login, err := env.login(t, authenticator, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if login.MultiFactor {
+		t.Fatal("passkey without user verification counted as multi-factor")
+	}
+	result, err := env.auth.CompletePasskeyFirstFactor(context.Background(), login.User)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.MFARequired || len(result.MFAMethods) != 1 || result.MFAMethods[0] != MFAMethodTOTP {
+		t.Fatalf("first factor result = %+v, want a TOTP challenge only", result)
+	}
+	if _, err := env.service.BeginLogin(context.Background(), result.MFAChallenge); !errors.Is(err, ErrInvalidMFAChallenge) {
+		t.Fatalf("BeginLogin with a passkey login's challenge: error = %v, want ErrInvalidMFAChallenge", err)
+	}
+
+	result, err = env.auth.CompleteFirstFactor(context.Background(), bob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.MFAMethods) != 2 {
+		t.Fatalf("password login methods = %v, want TOTP and passkey", result.MFAMethods)
+	}
+	login, err = env.login(t, authenticator, result.MFAChallenge)
+	if err != nil || !login.MultiFactor {
+		t.Fatalf("passkey as second factor of a password login = %+v, %v", login, err)
+	}
+}
+
+func TestPasskeyReauthentication(t *testing.T) {
+	env := newWebAuthnTestEnv(
+		&model.User{ID: 1, Username: "alice", Email: "alice@example.com"},
+		&model.User{ID: 2, Username: "bob", Email: "bob@example.com"},
+	)
+	alicePasskey := env.register(t, 1)
+	env.register(t, 2)
+
+	alice := &utility.Principal{UserID: 1}
+	options, err := env.service.BeginReauthentication(context.Background(), alice)
+	if err != nil {
+		t.Fatalf("BeginReauthentication: %v", err)
+	}
+	clientDataJSON, authenticatorData, signature := alicePasskey.Assert(options.Challenge)
+	err = env.service.VerifyReauthentication(context.Background(), alice, PasskeyAssertion{
+		CredentialID:      alicePasskey.CredentialID,
+		ClientDataJSON:    clientDataJSON,
+		AuthenticatorData: authenticatorData,
+		Signature:         signature,
+	})
+	if err != nil {
+		t.Fatalf("VerifyReauthentication: %v", err)
+	}
+
+	// A challenge issued to bob cannot confirm a change for alice.
+	options, err = env.service.BeginReauthentication(context.Background(), &utility.Principal{UserID: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientDataJSON, authenticatorData, signature = alicePasskey.Assert(options.Challenge)
+	err = env.service.VerifyReauthentication(context.Background(), alice, PasskeyAssertion{
+		CredentialID:      alicePasskey.CredentialID,
+		ClientDataJSON:    clientDataJSON,
+		AuthenticatorData: authenticatorData,
+		Signature:         signature,
+	})
+	if !errors.Is(err, ErrInvalidPasskey) {
+		t.Fatalf("VerifyReauthentication with another user's challenge: error = %v, want ErrInvalidPasskey", err)
+	}
+}
@@ -40,9 +40,21 @@ import (
 // @tag.name posts
 // @tag.description Post management operations
 // @tag.name votes
-// @tag.description Post voting operations
+// @tag.description Post and comment voting operations
+// @tag.name communities
+// @tag.description Communities, their listings and subscriptions
+// @tag.name comments
+// @tag.description Threaded comments on posts
 func main() {
 
+	cfg := utility.LoadConfig()
+
+	keyRing, err := utility.NewKeyRingFromConfig(cfg.Signing)
+	if err != nil {
+		log.Fatalf("failed to load signing keys: %v", err)
+	}
+	keyRing.StartRotation(context.Background(), cfg.Signing.RotationInterval)
+
 	db := connetToPostgreSQL()
 	rdb := connetToRedis()
 
@@ -50,30 +62,167 @@ func main() {
 	postRepo := repository.NewPostRepository(db)
 	voteRepo := repository.NewVoteRepository(db)
 	cacheRepo := repository.NewRedisCacheRepository(rdb)
+	loginAttemptRepo := repository.NewRedisLoginAttemptRepository(rdb)
+	refreshTokenRepo := repository.NewRefreshTokenRepository(db)
+	recoveryCodeRepo := repository.NewRecoveryCodeRepository(db)
+	identityRepo := repository.NewExternalIdentityRepository(db)
+	sessionRepo := repository.NewSessionRepository(db)
+	accessTokenRepo := repository.NewPersonalAccessTokenRepository(db)
+	dataExportRepo := repository.NewDataExportRepository(db)
+	credentialRepo := repository.NewWebAuthnCredentialRepository(db)
+	inviteRepo := repository.NewInviteRepository(db)
+	commentRepo := repository.NewCommentRepository(db)
+	communityRepo := repository.NewCommunityRepository(db)
+	linkPreviewRepo := repository.NewLinkPreviewRepository(db)
+
+	passwordHasher := utility.NewPasswordHasherFromConfig(cfg.Password)
+	mailer := utility.NewMailerFromConfig(cfg.Mail)
+	actionTokenSigner := utility.NewActionTokenSigner(cfg.Token.ActionTokenSecret)
+
+	loginGuardService := service.NewLoginGuardService(&loginAttemptRepo, &userRepo, cfg.LoginProtection)
+	powService := service.NewProofOfWorkService(&cacheRepo, actionTokenSigner, cfg.ProofOfWork)
+	inviteService := service.NewInviteService(&inviteRepo, &userRepo, cfg.Invites)
+	authService := service.NewAuthService(&userRepo, &cacheRepo, &credentialRepo, passwordHasher, loginGuardService,
+		inviteService, cfg.MFA)
+	communityService := service.NewCommunityService(&communityRepo, &postRepo, &userRepo, cfg.EmailVerification)
+	linkPreviewService := service.NewLinkPreviewService(&linkPreviewRepo, &cacheRepo, utility.NewUnfurlerFromConfig(cfg.Unfurl),
+		cfg.Unfurl)
+	linkPreviewService.Start(context.Background())
+	postService := service.NewPostService(&postRepo, &userRepo, &cacheRepo, &voteRepo, communityService, linkPreviewService,
+		cfg.EmailVerification)
+	commentService := service.NewCommentService(&commentRepo, &postRepo, &userRepo, &cacheRepo, cfg.EmailVerification,
+		cfg.Comments)
+	voteService := service.NewVoteService(&voteRepo, &postRepo, &commentRepo, &userRepo, &cacheRepo, cfg.EmailVerification)
+	tokenService := service.NewTokenService(&refreshTokenRepo, &sessionRepo, &userRepo, &cacheRepo, keyRing, cfg.Token)
+	verificationService := service.NewVerificationService(&userRepo, &cacheRepo, mailer, actionTokenSigner,
+		cfg.EmailVerification, cfg.Mail.PublicBaseURL)
+	passwordResetService := service.NewPasswordResetService(&userRepo, &cacheRepo, &accessTokenRepo, tokenService,
+		passwordHasher, mailer, cfg.PasswordReset, cfg.Mail.PublicBaseURL)
+	magicLinkService := service.NewMagicLinkService(&userRepo, &cacheRepo, mailer, actionTokenSigner,
+		cfg.MagicLink, cfg.Mail.PublicBaseURL)
+	mfaService := service.NewMFAService(&userRepo, &cacheRepo, &recoveryCodeRepo, tokenService, loginGuardService,
+		cfg.MFA)
+	webAuthnService := service.NewWebAuthnService(&credentialRepo, &userRepo, &cacheRepo, cfg.WebAuthn)
+
+	var oidcProviders []*utility.OIDCClient
+	for _, providerCfg := range cfg.OIDCProviders {
+		oidcProviders = append(oidcProviders, utility.NewOIDCClient(providerCfg, nil))
+	}
+	oidcService := service.NewOIDCService(&userRepo, &identityRepo, &cacheRepo, passwordHasher, inviteService, oidcProviders)
 
-	authService := service.NewAuthService(&userRepo, &cacheRepo)
-	postService := service.NewPostService(&postRepo, &userRepo, &cacheRepo, &voteRepo)
-	voteService := service.NewVoteService(&voteRepo, &postRepo, &userRepo, &cacheRepo)
+	accessTokenService := service.NewPersonalAccessTokenService(&accessTokenRepo, &userRepo, &cacheRepo)
+	roleService := service.NewRoleService(&userRepo)
+	accountService := service.NewAccountService(&userRepo, &postRepo, &identityRepo, &recoveryCodeRepo, &accessTokenRepo,
+		&credentialRepo, &cacheRepo, tokenService, verificationService, mfaService, webAuthnService, loginGuardService,
+		passwordHasher, cfg.AccountDeletion)
+	accountService.StartPurge(context.Background(), cfg.AccountDeletion.PurgeInterval)
+	dataExportService := service.NewDataExportService(&dataExportRepo, &userRepo, &postRepo, &voteRepo, &cacheRepo,
+		actionTokenSigner, cfg.DataExport, cfg.Mail.PublicBaseURL)
+	dataExportService.StartCleanup(context.Background())
+	roleService.BootstrapAdmins(context.Background(), cfg.AdminUsernames)
 
-	util := utility.NewUtility(&cacheRepo)
+	util := utility.NewUtility(&cacheRepo, &userRepo, keyRing, &accessTokenService)
 
-	authHandler := handler.NewAuthHandler(authService)
+	authHandler := handler.NewAuthHandler(authService, tokenService, verificationService, cfg.SessionCookies)
 	postHandler := handler.NewPostHandler(postService)
 	voteHandler := handler.NewVoteHandler(voteService)
+	commentHandler := handler.NewCommentHandler(commentService)
+	communityHandler := handler.NewCommunityHandler(communityService)
+	keyHandler := handler.NewKeyHandler(keyRing)
+	passwordHandler := handler.NewPasswordHandler(passwordResetService)
+	mfaHandler := handler.NewMFAHandler(mfaService, tokenService, cfg.SessionCookies)
+	magicLinkHandler := handler.NewMagicLinkHandler(magicLinkService, authService, tokenService, cfg.SessionCookies)
+	webAuthnHandler := handler.NewWebAuthnHandler(webAuthnService, authService, tokenService, cfg.SessionCookies)
+	inviteHandler := handler.NewInviteHandler(inviteService)
+	powHandler := handler.NewProofOfWorkHandler(powService)
+	oidcHandler := handler.NewOIDCHandler(oidcService, authService, tokenService, cfg.SessionCookies)
+	sessionHandler := handler.NewSessionHandler(tokenService)
+	adminHandler := handler.NewAdminHandler(loginGuardService, roleService)
+	accessTokenHandler := handler.NewAccessTokenHandler(accessTokenService)
+	accountHandler := handler.NewAccountHandler(accountService)
+	dataExportHandler := handler.NewDataExportHandler(dataExportService)
 
 	router := gin.Default()
 	router.GET("/docs/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
-	router.POST("/signup", authHandler.SignUp)
+	router.GET("/.well-known/jwks.json", keyHandler.JWKS)
+	router.GET("/pow/challenge", powHandler.IssueChallenge)
+	router.POST("/signup", powHandler.Require("signup"), authHandler.SignUp)
 	router.POST("/login", authHandler.Login)
+	router.POST("/login/mfa", mfaHandler.CompleteLogin)
+	router.POST("/login/magic", magicLinkHandler.RequestLink)
+	router.GET("/login/magic/verify", magicLinkHandler.ConfirmLink)
+	router.POST("/login/magic/verify", magicLinkHandler.ExchangeLink)
+	router.POST("/login/passkey/begin", webAuthnHandler.BeginLogin)
+	router.POST("/login/passkey/finish", webAuthnHandler.FinishLogin)
+	router.GET("/oidc/:provider/login", oidcHandler.Login)
+	router.GET("/oidc/:provider/callback", oidcHandler.Callback)
+	router.POST("/token/refresh", authHandler.RefreshToken)
+	router.GET("/verify-email", authHandler.VerifyEmail)
+	router.POST("/password/forgot", passwordHandler.ForgotPassword)
+	router.GET("/password/reset", passwordHandler.ResetPasswordForm)
+	router.POST("/password/reset", passwordHandler.ResetPassword)
+	router.GET("/exports/:id/download", dataExportHandler.DownloadExport)
+	router.GET("/users/:username", accountHandler.GetProfile)
+	read := util.RequireScope(utility.ScopeRead)
+	postsWrite := util.RequireScope(utility.ScopePostsWrite)
+	votesWrite := util.RequireScope(utility.ScopeVotesWrite)
+	account := util.RequireScope(utility.ScopeAccount)
+
 	auth := router.Group("/")
-	auth.Use(util.JWTAuthMiddleware())
+	auth.Use(util.AuthMiddleware())
+	{
+		auth.GET("/top", read, postHandler.GetTopPosts)
+		auth.GET("/new", read, postHandler.GetNewPosts)
+		auth.POST("/communities", postsWrite, communityHandler.CreateCommunity)
+		auth.GET("/c/:name", read, communityHandler.GetCommunity)
+		auth.PATCH("/c/:name", postsWrite, communityHandler.UpdateCommunity)
+		auth.GET("/c/:name/top", read, postHandler.GetCommunityTopPosts)
+		auth.GET("/c/:name/new", read, postHandler.GetCommunityNewPosts)
+		auth.POST("/c/:name/subscription", postsWrite, communityHandler.Subscribe)
+		auth.DELETE("/c/:name/subscription", postsWrite, communityHandler.Unsubscribe)
+		auth.PUT("/c/:name/members/:username", postsWrite, communityHandler.ApproveMember)
+		auth.POST("/signout", account, authHandler.SignOut)
+		auth.POST("/verify-email/resend", account, authHandler.ResendVerification)
+		auth.POST("/posts/create", postsWrite, powHandler.Require("post"), postHandler.CreatePost)
+		auth.PUT("/posts/update", postsWrite, postHandler.EditPost)
+		auth.DELETE("/posts/remove", postsWrite, postHandler.RemovePost)
+		auth.GET("/posts/:id", read, postHandler.GetPost)
+		auth.GET("/posts/:id/comments", read, commentHandler.ListComments)
+		auth.POST("/posts/:id/comments", postsWrite, commentHandler.CreateComment)
+		auth.GET("/comments/:id", read, commentHandler.GetComment)
+		auth.PUT("/comments/:id", postsWrite, commentHandler.EditComment)
+		auth.DELETE("/comments/:id", postsWrite, commentHandler.DeleteComment)
+		auth.POST("/comments/:id/vote", votesWrite, voteHandler.VoteComment)
+		auth.POST("/vote", votesWrite, voteHandler.VotePost)
+		auth.POST("/mfa/totp/enroll", account, mfaHandler.EnrollTOTP)
+		auth.POST("/mfa/totp/confirm", account, mfaHandler.ConfirmTOTP)
+		auth.DELETE("/mfa/totp", account, mfaHandler.DisableTOTP)
+		auth.POST("/oidc/:provider/connect", account, oidcHandler.Connect)
+		auth.POST("/passkeys/register/begin", account, webAuthnHandler.BeginRegistration)
+		auth.POST("/passkeys/register/finish", account, webAuthnHandler.FinishRegistration)
+		auth.POST("/passkeys/reauth/begin", account, webAuthnHandler.BeginReauthentication)
+		auth.GET("/passkeys", account, webAuthnHandler.ListCredentials)
+		auth.DELETE("/passkeys/:id", account, webAuthnHandler.DeleteCredential)
+		auth.GET("/sessions", account, sessionHandler.ListSessions)
+		auth.DELETE("/sessions", account, sessionHandler.RevokeAllSessions)
+		auth.DELETE("/sessions/:id", account, sessionHandler.RevokeSession)
+		auth.POST("/tokens", account, accessTokenHandler.CreateToken)
+		auth.GET("/tokens", account, accessTokenHandler.ListTokens)
+		auth.DELETE("/tokens/:id", account, accessTokenHandler.RevokeToken)
+		auth.PATCH("/me", account, accountHandler.UpdateProfile)
+		auth.DELETE("/me", account, accountHandler.DeleteAccount)
+		auth.POST("/invites", account, inviteHandler.CreateInvite)
+		auth.GET("/invites", account, inviteHandler.ListInvites)
+		auth.DELETE("/invites/:id", account, inviteHandler.RevokeInvite)
+		auth.POST("/me/exports", account, dataExportHandler.RequestExport)
+		auth.GET("/me/exports/:id", account, dataExportHandler.GetExport)
+	}
+	admin := auth.Group("/admin")
+	admin.Use(account, util.RequirePermission(utility.PermissionManageUsers))
 	{
-		auth.GET("/top", postHandler.GetTopPosts)
-		auth.POST("/signout", authHandler.SignOut)
-		auth.POST("/posts/create", postHandler.CreatePost)
-		auth.PUT("/posts/update", postHandler.EditPost)
-		auth.DELETE("/posts/remove", postHandler.RemovePost)
-		auth.POST("/vote", voteHandler.VotePost)
+		admin.POST("/users/:id/mfa/reset", mfaHandler.ResetTOTP)
+		admin.POST("/users/:id/unlock", adminHandler.UnlockUser)
+		admin.PUT("/users/:id/role", adminHandler.SetRole)
 	}
 	router.Run("0.0.0.0:8080")
 }
@@ -85,14 +234,24 @@ func connetToPostgreSQL() *gorm.DB {
 		panic("Failed to connect to database")
 	}
 
-	err = db.AutoMigrate(&model.User{}, &model.Post{}, &model.Vote{})
+	err = db.AutoMigrate(&model.User{}, &model.Post{}, &model.Vote{}, &model.RefreshToken{}, &model.RecoveryCode{},
+		&model.ExternalIdentity{}, &model.Session{}, &model.PersonalAccessToken{}, &model.DataExport{},
+		&model.WebAuthnCredential{}, &model.InviteCode{}, &model.Comment{},
+		&model.CommentVote{}, &model.Community{}, &model.CommunitySubscription{},
+		&model.LinkPreview{})
 	if err != nil {
 		panic("Migration failed")
 	}
+	if err := migrateUserForeignKeys(db); err != nil {
+		log.Fatalf("failed to migrate user foreign keys: %v", err)
+	}
 
 	migrator := db.Migrator()
 
-	tables := []string{"users", "posts", "votes"}
+	tables := []string{"users", "posts", "votes", "refresh_tokens", "recovery_codes", "external_identities", "sessions",
+		"personal_access_tokens", "data_exports", "web_authn_credentials",
+		"invite_codes", "comments", "comment_votes", "communities",
+		"community_subscriptions", "link_previews"}
 	for _, table := range tables {
 		exists := migrator.HasTable(table)
 		if exists {
@@ -107,6 +266,34 @@ func connetToPostgreSQL() *gorm.DB {
 	return db
 }
 
+// migrateUserForeignKeys recreates the foreign keys from posts and votes to
+// users that older schemas created without an ON DELETE action. AutoMigrate
+// leaves existing constraints alone, and without the action purging a
+// deleted user who has posts or votes fails.
+func migrateUserForeignKeys(db *gorm.DB) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		for _, constraint := range []string{"fk_users_posts", "fk_users_votes"} {
+			var outdated int64
+			err := tx.Raw("SELECT COUNT(*) FROM pg_constraint WHERE conname = ? AND confdeltype = 'a'",
+				constraint).Scan(&outdated).Error
+			if err != nil {
+				return err
+			}
+			if outdated == 0 {
+				continue
+			}
+			if err := tx.Migrator().DropConstraint(&model.User{}, constraint); err != nil {
+				return err
+			}
+			if err := tx.Migrator().CreateConstraint(&model.User{}, constraint); err != nil {
+				return err
+			}
+			log.Printf("Recreated constraint %s", constraint)
+		}
+		return nil
+	})
+}
+
 func connetToRedis() *redis.Client {
 	rdb := redis.NewClient(&redis.Options{
 
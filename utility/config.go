@@ -0,0 +1,424 @@
+package utility
+
+import (
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type PasswordConfig struct {
+	Algorithm    string
+	BcryptCost   int
+	Argon2Time   uint32
+	Argon2Memory uint32
+	Argon2Thread uint8
+	// LegacyPlaintext accepts rows that still hold the password itself and
+	// upgrades them on login. It is off unless LEGACY_PLAINTEXT_PASSWORDS is
+	// set, and should be turned off again once every row is hashed.
+	LegacyPlaintext bool
+}
+
+type TokenConfig struct {
+	AccessTokenTTL  time.Duration
+	RefreshTokenTTL time.Duration
+	// ReauthWindow is how long after signing in a session may delete the
+	// account without confirming again. Email and password changes always
+	// need a factor.
+	ReauthWindow time.Duration
+	// ActionTokenSecret signs the links we email, e.g. for verification.
+	ActionTokenSecret string
+}
+
+// SigningConfig describes the JWT signing keys. Keys are "kid:value"
+// entries, where value is the secret for HS256 and the path of a PEM encoded
+// private key for RS256 and EdDSA. Rotated keys are generated in memory, so
+// RotationInterval should stay zero when several instances share the keys.
+type SigningConfig struct {
+	Algorithm        string
+	Keys             []string
+	RotationInterval time.Duration
+	GracePeriod      time.Duration
+}
+
+type MailConfig struct {
+	Driver        string
+	From          string
+	SMTPHost      string
+	SMTPPort      int
+	SMTPUsername  string
+	SMTPPassword  string
+	OutboxDir     string
+	PublicBaseURL string
+}
+
+// EmailVerificationConfig controls the verification links and which actions
+// are refused until the address is verified ("post", "comment", "vote").
+type EmailVerificationConfig struct {
+	TokenTTL       time.Duration
+	ResendInterval time.Duration
+	RequiredFor    []string
+}
+
+func (c EmailVerificationConfig) Requires(action string) bool {
+	for _, required := range c.RequiredFor {
+		if required == action {
+			return true
+		}
+	}
+	return false
+}
+
+// PasswordResetConfig controls reset links. LinkURL is the front-end page
+// the emailed link opens, with the token added as a query parameter; when it
+// is empty the link opens the form served at GET /password/reset.
+type PasswordResetConfig struct {
+	TokenTTL        time.Duration
+	RequestInterval time.Duration
+	LinkURL         string
+}
+
+type MFAConfig struct {
+	Issuer            string
+	ChallengeTTL      time.Duration
+	RecoveryCodeCount int
+}
+
+// LoginProtectionConfig throttles password guessing. Failures are counted
+// per username and per client IP; past the backoff thresholds each further
+// failure doubles the wait, and an account is locked at LockoutThreshold.
+type LoginProtectionConfig struct {
+	FailureWindow    time.Duration
+	UserBackoffAfter int
+	IPBackoffAfter   int
+	BackoffBase      time.Duration
+	BackoffMax       time.Duration
+	LockoutThreshold int
+	LockoutDuration  time.Duration
+}
+
+// SessionCookieConfig describes the cookies browser clients get from /login
+// instead of tokens in the response body.
+type SessionCookieConfig struct {
+	Domain   string
+	Secure   bool
+	SameSite string
+}
+
+func (c SessionCookieConfig) SameSiteMode() http.SameSite {
+	switch strings.ToLower(c.SameSite) {
+	case "lax":
+		return http.SameSiteLaxMode
+	case "none":
+		return http.SameSiteNoneMode
+	default:
+		return http.SameSiteStrictMode
+	}
+}
+
+// MagicLinkConfig controls passwordless login. Links are requested per
+// address at most once per RequestInterval. LinkURL is the front-end page the
+// emailed link opens, with the token added as a query parameter; when it is
+// empty the link opens the confirm page served at GET /login/magic/verify.
+type MagicLinkConfig struct {
+	Enabled         bool
+	TokenTTL        time.Duration
+	RequestInterval time.Duration
+	LinkURL         string
+}
+
+// WebAuthnConfig identifies us as a relying party for passkeys. Origins are
+// the full origins, e.g. "https://example.com", the browser may report.
+type WebAuthnConfig struct {
+	RPID         string
+	RPName       string
+	Origins      []string
+	ChallengeTTL time.Duration
+}
+
+// InviteConfig controls invite codes. With Required set, signing up needs a
+// valid code. Users may create UserQuota single use codes in total, valid for
+// TTL; administrators are not limited.
+type InviteConfig struct {
+	Required  bool
+	UserQuota int
+	TTL       time.Duration
+}
+
+// ProofOfWorkConfig controls the proof-of-work challenges required for the
+// actions in RequiredFor ("signup", "post"). Each BurstThreshold solved
+// challenges from one IP range within BurstWindow double the work, up to
+// MaxDifficulty leading zero bits.
+type ProofOfWorkConfig struct {
+	RequiredFor    []string
+	BaseDifficulty int
+	MaxDifficulty  int
+	ChallengeTTL   time.Duration
+	BurstWindow    time.Duration
+	BurstThreshold int
+	IPv4PrefixBits int
+	IPv6PrefixBits int
+}
+
+func (c ProofOfWorkConfig) Requires(action string) bool {
+	for _, required := range c.RequiredFor {
+		if required == action {
+			return true
+		}
+	}
+	return false
+}
+
+// CommentConfig limits comment threads. Replies nest at most MaxDepth
+// levels deep, and one request loads LoadDepth levels and PageSize comments,
+// leaving the rest to "load more" requests.
+type CommentConfig struct {
+	MaxDepth  int
+	LoadDepth int
+	PageSize  int
+}
+
+// UnfurlConfig controls the previews fetched for link posts. Fetched
+// metadata is reused for CacheTTL. AllowPrivateNetworks lifts the block on
+// non-public addresses and is only meant for local development.
+type UnfurlConfig struct {
+	Workers              int
+	QueueSize            int
+	Timeout              time.Duration
+	MaxBodyBytes         int64
+	MaxRedirects         int
+	CacheTTL             time.Duration
+	UserAgent            string
+	AllowPrivateNetworks bool
+}
+
+// AccountDeletionConfig controls how long deleted accounts are kept, already
+// anonymised, before they are removed for good.
+type AccountDeletionConfig struct {
+	RetentionPeriod time.Duration
+	PurgeInterval   time.Duration
+}
+
+// DataExportConfig controls personal data exports. Archives are written to
+// Dir and deleted, along with their download links, after Retention.
+type DataExportConfig struct {
+	Dir             string
+	Retention       time.Duration
+	RequestInterval time.Duration
+}
+
+// OIDCProviderConfig is read from OIDC_<NAME>_* variables for every name
+// listed in OIDC_PROVIDERS.
+type OIDCProviderConfig struct {
+	Name         string
+	IssuerURL    string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+}
+
+type Config struct {
+	Password          PasswordConfig
+	Token             TokenConfig
+	Signing           SigningConfig
+	Mail              MailConfig
+	EmailVerification EmailVerificationConfig
+	PasswordReset     PasswordResetConfig
+	MFA               MFAConfig
+	OIDCProviders     []OIDCProviderConfig
+	LoginProtection   LoginProtectionConfig
+	SessionCookies    SessionCookieConfig
+	MagicLink         MagicLinkConfig
+	WebAuthn          WebAuthnConfig
+	Invites           InviteConfig
+	ProofOfWork       ProofOfWorkConfig
+	Comments          CommentConfig
+	Unfurl            UnfurlConfig
+	AccountDeletion   AccountDeletionConfig
+	DataExport        DataExportConfig
+	// AdminUsernames are given the admin role at startup, so the first
+	// administrator does not have to be promoted by hand in the database.
+	AdminUsernames []string
+}
+
+// LoadConfig reads the application settings from the environment, falling
+// back to defaults that are suitable for local development.
+func LoadConfig() Config {
+	return Config{
+		Password: PasswordConfig{
+			Algorithm:       getEnv("PASSWORD_HASH_ALGORITHM", "argon2id"),
+			BcryptCost:      getEnvInt("BCRYPT_COST", 12),
+			Argon2Time:      uint32(getEnvInt("ARGON2_TIME", 3)),
+			Argon2Memory:    uint32(getEnvInt("ARGON2_MEMORY_KIB", 64*1024)),
+			Argon2Thread:    uint8(getEnvInt("ARGON2_THREADS", 2)),
+			LegacyPlaintext: getEnvBool("LEGACY_PLAINTEXT_PASSWORDS", false),
+		},
+		Token: TokenConfig{
+			AccessTokenTTL:    getEnvDuration("ACCESS_TOKEN_TTL", 15*time.Minute),
+			RefreshTokenTTL:   getEnvDuration("REFRESH_TOKEN_TTL", 30*24*time.Hour),
+			ReauthWindow:      getEnvDuration("REAUTH_WINDOW", 10*time.Minute),
+			ActionTokenSecret: getEnv("ACTION_TOKEN_SECRET", ""),
+		},
+		Signing: SigningConfig{
+			Algorithm:        getEnv("JWT_SIGNING_ALGORITHM", "HS256"),
+			Keys:             getEnvList("JWT_SIGNING_KEYS", nil),
+			RotationInterval: getEnvDuration("JWT_ROTATION_INTERVAL", 0),
+			GracePeriod:      getEnvDuration("JWT_KEY_GRACE_PERIOD", time.Hour),
+		},
+		Mail: MailConfig{
+			Driver:        getEnv("MAIL_DRIVER", "outbox"),
+			From:          getEnv("MAIL_FROM", "no-reply@localhost"),
+			SMTPHost:      getEnv("SMTP_HOST", "localhost"),
+			SMTPPort:      getEnvInt("SMTP_PORT", 587),
+			SMTPUsername:  getEnv("SMTP_USERNAME", ""),
+			SMTPPassword:  getEnv("SMTP_PASSWORD", ""),
+			OutboxDir:     getEnv("MAIL_OUTBOX_DIR", "outbox"),
+			PublicBaseURL: getEnv("PUBLIC_BASE_URL", "http://localhost:8080"),
+		},
+		EmailVerification: EmailVerificationConfig{
+			TokenTTL:       getEnvDuration("EMAIL_VERIFICATION_TTL", 24*time.Hour),
+			ResendInterval: getEnvDuration("EMAIL_VERIFICATION_RESEND_INTERVAL", time.Minute),
+			RequiredFor:    getEnvList("REQUIRE_VERIFIED_EMAIL_FOR", []string{"post", "comment", "vote"}),
+		},
+		PasswordReset: PasswordResetConfig{
+			TokenTTL:        getEnvDuration("PASSWORD_RESET_TTL", time.Hour),
+			RequestInterval: getEnvDuration("PASSWORD_RESET_REQUEST_INTERVAL", time.Minute),
+			LinkURL:         getEnv("PASSWORD_RESET_URL", ""),
+		},
+		MFA: MFAConfig{
+			Issuer:            getEnv("MFA_ISSUER", "RedditBack"),
+			ChallengeTTL:      getEnvDuration("MFA_CHALLENGE_TTL", 5*time.Minute),
+			RecoveryCodeCount: getEnvInt("MFA_RECOVERY_CODE_COUNT", 10),
+		},
+		OIDCProviders: loadOIDCProviders(),
+		LoginProtection: LoginProtectionConfig{
+			FailureWindow:    getEnvDuration("LOGIN_FAILURE_WINDOW", 15*time.Minute),
+			UserBackoffAfter: getEnvInt("LOGIN_USER_BACKOFF_AFTER", 3),
+			IPBackoffAfter:   getEnvInt("LOGIN_IP_BACKOFF_AFTER", 20),
+			BackoffBase:      getEnvDuration("LOGIN_BACKOFF_BASE", time.Second),
+			BackoffMax:       getEnvDuration("LOGIN_BACKOFF_MAX", 5*time.Minute),
+			LockoutThreshold: getEnvInt("LOGIN_LOCKOUT_THRESHOLD", 10),
+			LockoutDuration:  getEnvDuration("LOGIN_LOCKOUT_DURATION", 15*time.Minute),
+		},
+		SessionCookies: SessionCookieConfig{
+			Domain:   getEnv("SESSION_COOKIE_DOMAIN", ""),
+			Secure:   getEnvBool("SESSION_COOKIE_SECURE", true),
+			SameSite: getEnv("SESSION_COOKIE_SAMESITE", "strict"),
+		},
+		MagicLink: MagicLinkConfig{
+			Enabled:         getEnvBool("MAGIC_LINK_ENABLED", false),
+			TokenTTL:        getEnvDuration("MAGIC_LINK_TTL", 15*time.Minute),
+			RequestInterval: getEnvDuration("MAGIC_LINK_REQUEST_INTERVAL", time.Minute),
+			LinkURL:         getEnv("MAGIC_LINK_URL", ""),
+		},
+		WebAuthn: WebAuthnConfig{
+			RPID:         getEnv("WEBAUTHN_RP_ID", "localhost"),
+			RPName:       getEnv("WEBAUTHN_RP_NAME", "RedditBack"),
+			Origins:      getEnvList("WEBAUTHN_ORIGINS", []string{"http://localhost:8080"}),
+			ChallengeTTL: getEnvDuration("WEBAUTHN_CHALLENGE_TTL", 5*time.Minute),
+		},
+		Invites: InviteConfig{
+			Required:  getEnvBool("SIGNUP_REQUIRE_INVITE", false),
+			UserQuota: getEnvInt("INVITE_USER_QUOTA", 5),
+			TTL:       getEnvDuration("INVITE_TTL", 7*24*time.Hour),
+		},
+		ProofOfWork: ProofOfWorkConfig{
+			RequiredFor:    getEnvList("POW_REQUIRED_FOR", []string{"signup"}),
+			BaseDifficulty: getEnvInt("POW_BASE_DIFFICULTY", 16),
+			MaxDifficulty:  getEnvInt("POW_MAX_DIFFICULTY", 24),
+			ChallengeTTL:   getEnvDuration("POW_CHALLENGE_TTL", 10*time.Minute),
+			BurstWindow:    getEnvDuration("POW_BURST_WINDOW", time.Hour),
+			BurstThreshold: getEnvInt("POW_BURST_THRESHOLD", 5),
+			IPv4PrefixBits: getEnvInt("POW_IPV4_PREFIX_BITS", 24),
+			IPv6PrefixBits: getEnvInt("POW_IPV6_PREFIX_BITS", 56),
+		},
+		Comments: CommentConfig{
+			MaxDepth:  getEnvInt("COMMENT_MAX_DEPTH", 16),
+			LoadDepth: getEnvInt("COMMENT_LOAD_DEPTH", 6),
+			PageSize:  getEnvInt("COMMENT_PAGE_SIZE", 200),
+		},
+		Unfurl: UnfurlConfig{
+			Workers:              getEnvInt("UNFURL_WORKERS", 2),
+			QueueSize:            getEnvInt("UNFURL_QUEUE_SIZE", 100),
+			Timeout:              getEnvDuration("UNFURL_TIMEOUT", 5*time.Second),
+			MaxBodyBytes:         int64(getEnvInt("UNFURL_MAX_BODY_BYTES", 1<<20)),
+			MaxRedirects:         getEnvInt("UNFURL_MAX_REDIRECTS", 5),
+			CacheTTL:             getEnvDuration("UNFURL_CACHE_TTL", 7*24*time.Hour),
+			UserAgent:            getEnv("UNFURL_USER_AGENT", "RedditBack-Unfurler/1.0"),
+			AllowPrivateNetworks: getEnvBool("UNFURL_ALLOW_PRIVATE_NETWORKS", false),
+		},
+		AccountDeletion: AccountDeletionConfig{
+			RetentionPeriod: getEnvDuration("ACCOUNT_RETENTION_PERIOD", 30*24*time.Hour),
+			PurgeInterval:   getEnvDuration("ACCOUNT_PURGE_INTERVAL", time.Hour),
+		},
+		DataExport: DataExportConfig{
+			Dir:             getEnv("DATA_EXPORT_DIR", "exports"),
+			Retention:       getEnvDuration("DATA_EXPORT_RETENTION", 24*time.Hour),
+			RequestInterval: getEnvDuration("DATA_EXPORT_REQUEST_INTERVAL", time.Hour),
+		},
+		AdminUsernames: getEnvList("ADMIN_USERNAMES", nil),
+	}
+}
+
+func loadOIDCProviders() []OIDCProviderConfig {
+	var providers []OIDCProviderConfig
+	for _, name := range getEnvList("OIDC_PROVIDERS", nil) {
+		prefix := "OIDC_" + strings.ToUpper(name) + "_"
+		providers = append(providers, OIDCProviderConfig{
+			Name:         strings.ToLower(name),
+			IssuerURL:    getEnv(prefix+"ISSUER", ""),
+			ClientID:     getEnv(prefix+"CLIENT_ID", ""),
+			ClientSecret: getEnv(prefix+"CLIENT_SECRET", ""),
+			RedirectURL:  getEnv(prefix+"REDIRECT_URL", ""),
+			Scopes:       getEnvList(prefix+"SCOPES", []string{"openid", "email", "profile"}),
+		})
+	}
+	return providers
+}
+
+func getEnv(key, fallback string) string {
+	if value, ok := os.LookupEnv(key); ok && strings.TrimSpace(value) != "" {
+		return value
+	}
+	return fallback
+}
+
+func getEnvInt(key string, fallback int) int {
+	value, err := strconv.Atoi(getEnv(key, ""))
+	if err != nil {
+		return fallback
+	}
+	return value
+}
+
+func getEnvBool(key string, fallback bool) bool {
+	value, err := strconv.ParseBool(getEnv(key, ""))
+	if err != nil {
+		return fallback
+	}
+	return value
+}
+
+func getEnvDuration(key string, fallback time.Duration) time.Duration {
+	value, err := time.ParseDuration(getEnv(key, ""))
+	if err != nil {
+		return fallback
+	}
+	return value
+}
+
+func getEnvList(key string, fallback []string) []string {
+	value := getEnv(key, "")
+	if value == "" {
+		return fallback
+	}
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -1,79 +1,189 @@
 package utility
 
 import (
+	"context"
+	"errors"
 	"redditBack/repository"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
 )
 
-var secretKey = []byte("this-is-a-definitly-safe-key")
-
+// Claims identify the user by ID in the standard sub claim, so a token stays
+// valid across a username change.
 type Claims struct {
-	UserID string
+	SessionID     string `json:"sid"`
+	Version       int64  `json:"ver"`
+	Role          string `json:"role"`
+	EmailVerified bool   `json:"email_verified"`
+	// CSRF is the token cookie clients must echo, see AuthMiddleware.
+	CSRF string `json:"csrf,omitempty"`
 	jwt.RegisteredClaims
 }
+
+func NewClaims(userID uint) *Claims {
+	claims := &Claims{}
+	claims.Subject = strconv.FormatUint(uint64(userID), 10)
+	return claims
+}
+
+func (c *Claims) UserID() (uint, error) {
+	id, err := strconv.ParseUint(c.Subject, 10, 64)
+	if err != nil || id == 0 {
+		return 0, errors.New("invalid subject")
+	}
+	return uint(id), nil
+}
+
 type UtilityFunctions struct {
-	CacheRepo repository.CacheRepository
+	CacheRepo    repository.CacheRepository
+	Users        repository.UserRepository
+	Keys         *KeyRing
+	AccessTokens PersonalAccessTokenAuthenticator
 }
 
-func NewUtility(cacheRepo repository.CacheRepository) UtilityFunctions {
-	return UtilityFunctions{CacheRepo: cacheRepo}
+func NewUtility(cacheRepo repository.CacheRepository, users repository.UserRepository, keys *KeyRing,
+	accessTokens PersonalAccessTokenAuthenticator) UtilityFunctions {
+	return UtilityFunctions{CacheRepo: cacheRepo, Users: users, Keys: keys, AccessTokens: accessTokens}
 }
-func GenerateToken(username string) (string, error) {
-	expirationTime := time.Now().Add(1000 * time.Minute)
-
-	claims := &Claims{
-		UserID: username,
-		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(expirationTime),
-		},
+
+// GenerateToken signs claims with the active key, filling in a fresh jti and
+// the issue and expiry times.
+func GenerateToken(keys *KeyRing, claims *Claims, ttl time.Duration) (string, error) {
+	jti, err := GenerateOpaqueToken()
+	if err != nil {
+		return "", err
 	}
+	now := time.Now()
+	claims.ID = jti
+	claims.IssuedAt = jwt.NewNumericDate(now)
+	claims.ExpiresAt = jwt.NewNumericDate(now.Add(ttl))
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(secretKey)
+	key := keys.Active()
+	token := jwt.NewWithClaims(key.Method, claims)
+	token.Header["kid"] = key.ID
+	return token.SignedString(key.signKey)
 }
 
-func ParseToken(tokenString string) (*Claims, error) {
+func ParseToken(keys *KeyRing, tokenString string) (*Claims, error) {
 	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
-		return secretKey, nil
+		kid, _ := token.Header["kid"].(string)
+		key, err := keys.Lookup(kid)
+		if err != nil {
+			return nil, err
+		}
+		// The key decides the algorithm, never the token header.
+		if token.Method.Alg() != key.Method.Alg() {
+			return nil, errors.New("unexpected signing method")
+		}
+		return key.verifyKey, nil
 	})
+	if err != nil {
+		return nil, err
+	}
 
 	if claims, ok := token.Claims.(*Claims); ok && token.Valid {
 		return claims, nil
 	}
-	return nil, err
+	return nil, errors.New("invalid token")
 }
 
-func (u *UtilityFunctions) JWTAuthMiddleware() gin.HandlerFunc {
+// AuthMiddleware authenticates API clients by a Bearer token in the
+// Authorization header, either a session JWT or a personal access token, and
+// browser clients by the session cookie set at login. Cookie requests that
+// change state must echo the session's CSRF token in the X-CSRF-Token header.
+func (u *UtilityFunctions) AuthMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		tokenString := c.GetHeader("Authorization")
-		if tokenString == "" {
+		token, fromCookie, ok := requestCredentials(c)
+		if !ok {
 			c.JSON(401, gin.H{"error": "Unauthorized"})
 			c.Abort()
 			return
 		}
 
-		exist, _ := u.CacheRepo.IsTokenInvalid(c.Request.Context(), tokenString)
-		if exist {
+		if !fromCookie && strings.HasPrefix(token, PersonalAccessTokenPrefix) && u.AccessTokens != nil {
+			principal, err := u.AccessTokens.AuthenticateAccessToken(c.Request.Context(), token)
+			if err != nil {
+				c.JSON(401, gin.H{"error": "Invalid token"})
+				c.Abort()
+				return
+			}
+			SetPrincipal(c, principal)
+			c.Next()
+			return
+		}
+
+		principal, claims, err := u.authenticateSession(c.Request.Context(), token)
+		if err != nil {
 			c.JSON(401, gin.H{"error": "Invalid token"})
 			c.Abort()
 			return
 		}
 
-		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-			return []byte("this-is-a-definitly-safe-key"), nil
-		})
-
-		if err != nil || !token.Valid {
-			c.JSON(401, gin.H{"error": "Invalid token"})
+		if fromCookie && !isSafeMethod(c.Request.Method) && !ValidCSRFToken(c.GetHeader(CSRFHeader), claims.CSRF) {
+			c.JSON(403, gin.H{"error": "missing or invalid CSRF token"})
 			c.Abort()
 			return
 		}
 
-		claims := token.Claims.(jwt.MapClaims)
-		c.Set("user_id", claims["UserID"])
+		SetPrincipal(c, principal)
 		c.Next()
 	}
 }
+
+// requestCredentials returns the token of a "Bearer" Authorization header,
+// or else the session cookie. A malformed header is not silently ignored.
+func requestCredentials(c *gin.Context) (string, bool, bool) {
+	if header := c.GetHeader("Authorization"); header != "" {
+		scheme, token, found := strings.Cut(strings.TrimSpace(header), " ")
+		token = strings.TrimSpace(token)
+		if !found || !strings.EqualFold(scheme, "Bearer") || token == "" {
+			return "", false, false
+		}
+		return token, false, true
+	}
+
+	if token, err := c.Cookie(AccessTokenCookie); err == nil && token != "" {
+		return token, true, true
+	}
+	return "", false, false
+}
+
+func (u *UtilityFunctions) authenticateSession(ctx context.Context, token string) (*Principal, *Claims, error) {
+	claims, err := ParseToken(u.Keys, token)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	userID, err := claims.UserID()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	revoked, err := u.CacheRepo.IsSessionRevoked(ctx, claims.SessionID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if revoked {
+		return nil, nil, errors.New("session revoked")
+	}
+
+	version, err := u.CacheRepo.GetTokenVersion(ctx, userID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if version != claims.Version {
+		return nil, nil, errors.New("token version outdated")
+	}
+
+	return &Principal{
+		UserID:        userID,
+		SessionID:     claims.SessionID,
+		Role:          claims.Role,
+		EmailVerified: claims.EmailVerified,
+		Scopes:        sessionScopes,
+	}, claims, nil
+}
@@ -0,0 +1,288 @@
+package utility
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var (
+	ErrUnknownKeyID         = errors.New("unknown signing key id")
+	ErrUnsupportedAlgorithm = errors.New("unsupported signing algorithm")
+)
+
+type SigningKey struct {
+	ID        string
+	Method    jwt.SigningMethod
+	CreatedAt time.Time
+	// RetiresAt is set once the key is rotated out. Until then it keeps
+	// verifying tokens it signed, but no longer signs new ones.
+	RetiresAt time.Time
+	signKey   interface{}
+	verifyKey interface{}
+}
+
+// KeyRing holds the active signing key together with recently rotated keys
+// that are still accepted for verification.
+type KeyRing struct {
+	mu        sync.RWMutex
+	algorithm string
+	grace     time.Duration
+	keys      map[string]*SigningKey
+	active    string
+}
+
+func NewKeyRing(algorithm string, grace time.Duration) *KeyRing {
+	return &KeyRing{
+		algorithm: algorithm,
+		grace:     grace,
+		keys:      make(map[string]*SigningKey),
+	}
+}
+
+// NewKeyRingFromConfig loads the configured keys. The first key listed is the
+// active one. When no key is configured a random one is generated, which is
+// only suitable for a single instance since tokens do not survive a restart.
+func NewKeyRingFromConfig(cfg SigningConfig) (*KeyRing, error) {
+	ring := NewKeyRing(cfg.Algorithm, cfg.GracePeriod)
+
+	for i, entry := range cfg.Keys {
+		kid, value, found := strings.Cut(entry, ":")
+		if !found || kid == "" || value == "" {
+			return nil, fmt.Errorf("invalid signing key entry %q, expected kid:value", entry)
+		}
+
+		var key *SigningKey
+		var err error
+		if cfg.Algorithm == jwt.SigningMethodHS256.Alg() {
+			key = newHMACKey(kid, []byte(value))
+		} else {
+			key, err = loadPrivateKey(kid, value)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if key.Method.Alg() != cfg.Algorithm {
+			return nil, fmt.Errorf("key %s is not a %s key", kid, cfg.Algorithm)
+		}
+
+		ring.keys[kid] = key
+		if i == 0 {
+			ring.active = kid
+		}
+	}
+
+	if ring.active == "" {
+		log.Printf("no %s signing keys configured, generating a temporary key", cfg.Algorithm)
+		if err := ring.Rotate(); err != nil {
+			return nil, err
+		}
+	}
+	return ring, nil
+}
+
+func (k *KeyRing) Active() *SigningKey {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.keys[k.active]
+}
+
+// Lookup returns the key with the given id as long as it is still within its
+// grace period.
+func (k *KeyRing) Lookup(kid string) (*SigningKey, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	key, ok := k.keys[kid]
+	if !ok || (!key.RetiresAt.IsZero() && time.Now().After(key.RetiresAt)) {
+		return nil, ErrUnknownKeyID
+	}
+	return key, nil
+}
+
+// Rotate generates a new active key and gives the previous one the grace
+// period to keep verifying the tokens it already signed.
+func (k *KeyRing) Rotate() error {
+	key, err := generateKey(k.algorithm)
+	if err != nil {
+		return err
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	now := time.Now()
+	if previous, ok := k.keys[k.active]; ok {
+		previous.RetiresAt = now.Add(k.grace)
+	}
+	for kid, old := range k.keys {
+		if !old.RetiresAt.IsZero() && now.After(old.RetiresAt) {
+			delete(k.keys, kid)
+		}
+	}
+
+	k.keys[key.ID] = key
+	k.active = key.ID
+	log.Printf("rotated signing key, new key id %s", key.ID)
+	return nil
+}
+
+// StartRotation rotates the active key on every interval until ctx is done.
+func (k *KeyRing) StartRotation(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := k.Rotate(); err != nil {
+					log.Printf("failed to rotate signing key: %v", err)
+				}
+			}
+		}
+	}()
+}
+
+// JWK is the public part of a signing key as published on the JWKS endpoint.
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+}
+
+// JWKS lists the public keys that verify currently accepted tokens. Shared
+// HMAC secrets are never published, so the set is empty for HS256.
+func (k *KeyRing) JWKS() []JWK {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	now := time.Now()
+	keys := []JWK{}
+	for _, key := range k.keys {
+		if !key.RetiresAt.IsZero() && now.After(key.RetiresAt) {
+			continue
+		}
+		switch pub := key.verifyKey.(type) {
+		case *rsa.PublicKey:
+			keys = append(keys, JWK{
+				Kty: "RSA",
+				Kid: key.ID,
+				Alg: key.Method.Alg(),
+				Use: "sig",
+				N:   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+				E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+			})
+		case ed25519.PublicKey:
+			keys = append(keys, JWK{
+				Kty: "OKP",
+				Kid: key.ID,
+				Alg: key.Method.Alg(),
+				Use: "sig",
+				Crv: "Ed25519",
+				X:   base64.RawURLEncoding.EncodeToString(pub),
+			})
+		}
+	}
+	return keys
+}
+
+func newHMACKey(kid string, secret []byte) *SigningKey {
+	return &SigningKey{
+		ID:        kid,
+		Method:    jwt.SigningMethodHS256,
+		CreatedAt: time.Now(),
+		signKey:   secret,
+		verifyKey: secret,
+	}
+}
+
+func generateKey(algorithm string) (*SigningKey, error) {
+	kid, err := GenerateOpaqueToken()
+	if err != nil {
+		return nil, err
+	}
+	kid = kid[:16]
+
+	switch algorithm {
+	case jwt.SigningMethodHS256.Alg():
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, err
+		}
+		return newHMACKey(kid, secret), nil
+	case jwt.SigningMethodRS256.Alg():
+		private, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			return nil, err
+		}
+		return newAsymmetricKey(kid, private)
+	case jwt.SigningMethodEdDSA.Alg():
+		_, private, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		return newAsymmetricKey(kid, private)
+	default:
+		return nil, ErrUnsupportedAlgorithm
+	}
+}
+
+func loadPrivateKey(kid, path string) (*SigningKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data found in %s", path)
+	}
+
+	if private, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return newAsymmetricKey(kid, private)
+	}
+	private, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse private key %s: %w", path, err)
+	}
+	return newAsymmetricKey(kid, private)
+}
+
+func newAsymmetricKey(kid string, private interface{}) (*SigningKey, error) {
+	key := &SigningKey{ID: kid, CreatedAt: time.Now(), signKey: private}
+
+	switch p := private.(type) {
+	case *rsa.PrivateKey:
+		key.Method = jwt.SigningMethodRS256
+		key.verifyKey = &p.PublicKey
+	case ed25519.PrivateKey:
+		key.Method = jwt.SigningMethodEdDSA
+		key.verifyKey = p.Public().(ed25519.PublicKey)
+	default:
+		return nil, ErrUnsupportedAlgorithm
+	}
+	return key, nil
+}
@@ -0,0 +1,231 @@
+package utility
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var ErrMalformedHash = errors.New("malformed password hash")
+
+// PasswordHasher turns passwords into storable hashes and checks them again.
+// Verify reports needsRehash when the stored hash was produced with other
+// parameters than the hasher would use today.
+type PasswordHasher interface {
+	Hash(password string) (string, error)
+	Verify(encoded, password string) (match bool, needsRehash bool, err error)
+	Recognizes(encoded string) bool
+}
+
+type BcryptHasher struct {
+	Cost int
+}
+
+func NewBcryptHasher(cost int) BcryptHasher {
+	if cost < bcrypt.MinCost || cost > bcrypt.MaxCost {
+		cost = bcrypt.DefaultCost
+	}
+	return BcryptHasher{Cost: cost}
+}
+
+func (h BcryptHasher) Hash(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), h.Cost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+func (h BcryptHasher) Verify(encoded, password string) (bool, bool, error) {
+	err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return false, false, nil
+	}
+	if err != nil {
+		return false, false, err
+	}
+	cost, err := bcrypt.Cost([]byte(encoded))
+	if err != nil {
+		return false, false, err
+	}
+	return true, cost != h.Cost, nil
+}
+
+func (h BcryptHasher) Recognizes(encoded string) bool {
+	return strings.HasPrefix(encoded, "$2a$") ||
+		strings.HasPrefix(encoded, "$2b$") ||
+		strings.HasPrefix(encoded, "$2y$")
+}
+
+// Argon2idHasher produces PHC formatted hashes such as
+// $argon2id$v=19$m=65536,t=3,p=2$<salt>$<key>.
+type Argon2idHasher struct {
+	Time    uint32
+	Memory  uint32
+	Threads uint8
+	SaltLen uint32
+	KeyLen  uint32
+}
+
+func NewArgon2idHasher(time, memory uint32, threads uint8) Argon2idHasher {
+	if time == 0 {
+		time = 3
+	}
+	if memory == 0 {
+		memory = 64 * 1024
+	}
+	if threads == 0 {
+		threads = 2
+	}
+	return Argon2idHasher{Time: time, Memory: memory, Threads: threads, SaltLen: 16, KeyLen: 32}
+}
+
+func (h Argon2idHasher) Hash(password string) (string, error) {
+	salt := make([]byte, h.SaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, h.Time, h.Memory, h.Threads, h.KeyLen)
+
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, h.Memory, h.Time, h.Threads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+// Stored hashes with parameters outside these bounds are refused as
+// malformed: a row must not be able to stall a login with huge costs, panic
+// argon2 without threads, or match any password with an empty key. Hashes
+// the hasher itself would produce are always accepted.
+const (
+	maxArgon2Time    = 16
+	maxArgon2Memory  = 1 << 20 // KiB
+	minArgon2KeyLen  = 16
+	maxArgon2KeyLen  = 64
+	minArgon2SaltLen = 8
+)
+
+func (h Argon2idHasher) Verify(encoded, password string) (bool, bool, error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return false, false, ErrMalformedHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil {
+		return false, false, ErrMalformedHash
+	}
+	var memory, time uint32
+	var threads uint8
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &time, &threads); err != nil {
+		return false, false, ErrMalformedHash
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return false, false, ErrMalformedHash
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return false, false, ErrMalformedHash
+	}
+	if threads == 0 || time == 0 || time > max(h.Time, maxArgon2Time) ||
+		memory < 8*uint32(threads) || memory > max(h.Memory, maxArgon2Memory) ||
+		len(key) < minArgon2KeyLen || len(key) > max(int(h.KeyLen), maxArgon2KeyLen) || len(salt) < minArgon2SaltLen {
+		return false, false, ErrMalformedHash
+	}
+
+	candidate := argon2.IDKey([]byte(password), salt, time, memory, threads, uint32(len(key)))
+	if subtle.ConstantTimeCompare(candidate, key) != 1 {
+		return false, false, nil
+	}
+
+	outdated := version != argon2.Version ||
+		memory != h.Memory || time != h.Time || threads != h.Threads ||
+		uint32(len(salt)) != h.SaltLen || uint32(len(key)) != h.KeyLen
+	return true, outdated, nil
+}
+
+func (h Argon2idHasher) Recognizes(encoded string) bool {
+	return strings.HasPrefix(encoded, "$argon2id$")
+}
+
+// PasswordManager hashes new passwords with the preferred hasher and still
+// accepts hashes from the other known hashers, as well as plaintext rows that
+// predate hashing while legacyPlaintext is on. Anything not produced by the
+// preferred hasher with its current parameters is reported as needing a
+// rehash.
+type PasswordManager struct {
+	preferred       PasswordHasher
+	others          []PasswordHasher
+	legacyPlaintext bool
+}
+
+func NewPasswordManager(preferred PasswordHasher, others ...PasswordHasher) PasswordManager {
+	return PasswordManager{preferred: preferred, others: others}
+}
+
+// WithLegacyPlaintext returns a copy that also accepts plaintext rows.
+func (m PasswordManager) WithLegacyPlaintext(enabled bool) PasswordManager {
+	m.legacyPlaintext = enabled
+	return m
+}
+
+// NewPasswordHasherFromConfig builds a PasswordManager that prefers the
+// configured algorithm and understands the other one.
+func NewPasswordHasherFromConfig(cfg PasswordConfig) PasswordManager {
+	bcryptHasher := NewBcryptHasher(cfg.BcryptCost)
+	argonHasher := NewArgon2idHasher(cfg.Argon2Time, cfg.Argon2Memory, cfg.Argon2Thread)
+
+	if strings.EqualFold(cfg.Algorithm, "bcrypt") {
+		return NewPasswordManager(bcryptHasher, argonHasher).WithLegacyPlaintext(cfg.LegacyPlaintext)
+	}
+	return NewPasswordManager(argonHasher, bcryptHasher).WithLegacyPlaintext(cfg.LegacyPlaintext)
+}
+
+func (m PasswordManager) Hash(password string) (string, error) {
+	return m.preferred.Hash(password)
+}
+
+func (m PasswordManager) Verify(encoded, password string) (bool, bool, error) {
+	// Scrubbed rows of deleted accounts hold an empty hash.
+	if encoded == "" {
+		return false, false, nil
+	}
+	if m.preferred.Recognizes(encoded) {
+		return m.preferred.Verify(encoded, password)
+	}
+	for _, hasher := range m.others {
+		if hasher.Recognizes(encoded) {
+			match, _, err := hasher.Verify(encoded, password)
+			return match, match, err
+		}
+	}
+
+	// Legacy rows stored the password itself. Anything shaped like a hash of
+	// an unknown scheme is not one of them.
+	if !m.legacyPlaintext || password == "" || strings.HasPrefix(encoded, "$") {
+		return false, false, nil
+	}
+	match := subtle.ConstantTimeCompare([]byte(encoded), []byte(password)) == 1
+	return match, match, nil
+}
+
+// Recognizes reports whether one of the configured hashers produced encoded.
+func (m PasswordManager) Recognizes(encoded string) bool {
+	if m.preferred.Recognizes(encoded) {
+		return true
+	}
+	for _, hasher := range m.others {
+		if hasher.Recognizes(encoded) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,157 @@
+package utility
+
+import (
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Cheap parameters keep the tests fast; the logic does not depend on them.
+func testBcrypt() BcryptHasher {
+	return NewBcryptHasher(bcrypt.MinCost)
+}
+
+func testArgon2id() Argon2idHasher {
+	return NewArgon2idHasher(1, 1024, 1)
+}
+
+func TestHashersRoundTrip(t *testing.T) {
+	hashers := map[string]PasswordHasher{
+		"bcrypt":   testBcrypt(),
+		"argon2id": testArgon2id(),
+	}
+	for name, hasher := range hashers {
+		t.Run(name, func(t *testing.T) {
+			encoded, err := hasher.Hash("correct horse")
+			if err != nil {
+				t.Fatalf("Hash: %v", err)
+			}
+			if !hasher.Recognizes(encoded) {
+				t.Fatalf("hasher does not recognize its own hash %q", encoded)
+			}
+
+			match, needsRehash, err := hasher.Verify(encoded, "correct horse")
+			if err != nil || !match || needsRehash {
+				t.Fatalf("Verify(right password) = %v, %v, %v; want true, false, nil", match, needsRehash, err)
+			}
+			match, _, err = hasher.Verify(encoded, "wrong horse")
+			if err != nil || match {
+				t.Fatalf("Verify(wrong password) = %v, %v; want false, nil", match, err)
+			}
+		})
+	}
+}
+
+func TestHashersReportOutdatedParameters(t *testing.T) {
+	oldBcrypt := testBcrypt()
+	encoded, err := oldBcrypt.Hash("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	match, needsRehash, err := NewBcryptHasher(bcrypt.MinCost+1).Verify(encoded, "secret")
+	if err != nil || !match || !needsRehash {
+		t.Fatalf("bcrypt with a new cost = %v, %v, %v; want true, true, nil", match, needsRehash, err)
+	}
+
+	oldArgon := testArgon2id()
+	encoded, err = oldArgon.Hash("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	match, needsRehash, err = NewArgon2idHasher(2, 1024, 1).Verify(encoded, "secret")
+	if err != nil || !match || !needsRehash {
+		t.Fatalf("argon2id with a new time = %v, %v, %v; want true, true, nil", match, needsRehash, err)
+	}
+}
+
+func TestPasswordManagerVerify(t *testing.T) {
+	manager := NewPasswordManager(testArgon2id(), testBcrypt()).WithLegacyPlaintext(true)
+
+	preferred, err := testArgon2id().Hash("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := testBcrypt().Hash("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name            string
+		manager         PasswordManager
+		encoded         string
+		password        string
+		wantMatch       bool
+		wantNeedsRehash bool
+	}{
+		{"preferred hasher", manager, preferred, "secret", true, false},
+		{"preferred hasher, wrong password", manager, preferred, "guess", false, false},
+		{"other hasher is upgraded", manager, other, "secret", true, true},
+		{"other hasher, wrong password", manager, other, "guess", false, false},
+		{"plaintext is upgraded", manager, "secret", "secret", true, true},
+		{"plaintext, wrong password", manager, "secret", "guess", false, false},
+		{"plaintext switched off", manager.WithLegacyPlaintext(false), "secret", "secret", false, false},
+		{"scrubbed row", manager, "", "", false, false},
+		{"scrubbed row, any password", manager, "", "secret", false, false},
+		{"unknown hash scheme", manager, "$scrypt$abc", "$scrypt$abc", false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			match, needsRehash, err := tt.manager.Verify(tt.encoded, tt.password)
+			if err != nil {
+				t.Fatalf("Verify: %v", err)
+			}
+			if match != tt.wantMatch || needsRehash != tt.wantNeedsRehash {
+				t.Fatalf("Verify = %v, %v; want %v, %v", match, needsRehash, tt.wantMatch, tt.wantNeedsRehash)
+			}
+		})
+	}
+}
+
+func TestPasswordManagerRecognizes(t *testing.T) {
+	manager := NewPasswordManager(testArgon2id(), testBcrypt()).WithLegacyPlaintext(true)
+	bcryptHash, err := testBcrypt().Hash("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !manager.Recognizes(bcryptHash) {
+		t.Error("manager does not recognize a bcrypt hash")
+	}
+	if manager.Recognizes("secret") {
+		t.Error("manager recognizes plaintext as a hash")
+	}
+	if manager.Recognizes("") {
+		t.Error("manager recognizes an empty hash")
+	}
+	if NewPasswordManager(testArgon2id()).Recognizes(bcryptHash) {
+		t.Error("manager without bcrypt recognizes a bcrypt hash")
+	}
+}
+
+func TestArgon2idRejectsUnsafeParameters(t *testing.T) {
+	salt := "c29tZXNhbHRzb21lc2FsdA"
+	key := "a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2U"
+
+	tests := []struct {
+		name    string
+		encoded string
+	}{
+		{"no threads", "$argon2id$v=19$m=1024,t=1,p=0$" + salt + "$" + key},
+		{"no time", "$argon2id$v=19$m=1024,t=0,p=1$" + salt + "$" + key},
+		{"huge time", "$argon2id$v=19$m=1024,t=4000000000,p=1$" + salt + "$" + key},
+		{"huge memory", "$argon2id$v=19$m=4000000000,t=1,p=1$" + salt + "$" + key},
+		{"memory below threads", "$argon2id$v=19$m=8,t=1,p=4$" + salt + "$" + key},
+		{"empty key", "$argon2id$v=19$m=1024,t=1,p=1$" + salt + "$"},
+		{"short key", "$argon2id$v=19$m=1024,t=1,p=1$" + salt + "$a2V5"},
+		{"empty salt", "$argon2id$v=19$m=1024,t=1,p=1$$" + key},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			match, _, err := testArgon2id().Verify(tt.encoded, "")
+			if err != ErrMalformedHash || match {
+				t.Fatalf("Verify = %v, %v; want false, ErrMalformedHash", match, err)
+			}
+		})
+	}
+}
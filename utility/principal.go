@@ -0,0 +1,31 @@
+package utility
+
+import "github.com/gin-gonic/gin"
+
+const principalKey = "principal"
+
+// Principal is the authenticated caller of a request, as established by
+// AuthMiddleware from a session token or a personal access token. Services
+// take it instead of a username so they need not look the caller up.
+type Principal struct {
+	UserID uint
+	// SessionID is empty for personal access tokens.
+	SessionID     string
+	Role          string
+	EmailVerified bool
+	Scopes        []string
+}
+
+func (p *Principal) HasScope(scope string) bool {
+	return HasScope(p.Scopes, scope)
+}
+
+func SetPrincipal(c *gin.Context, principal *Principal) {
+	c.Set(principalKey, principal)
+}
+
+// CurrentPrincipal returns the caller put on the context by AuthMiddleware.
+func CurrentPrincipal(c *gin.Context) (*Principal, bool) {
+	principal, ok := c.Value(principalKey).(*Principal)
+	return principal, ok && principal != nil
+}
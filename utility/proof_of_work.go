@@ -0,0 +1,39 @@
+package utility
+
+import (
+	"crypto/sha256"
+	"math/bits"
+	"net"
+)
+
+// SolvesProofOfWork reports whether SHA-256 of "challenge:solution" starts
+// with at least difficulty zero bits. Finding a solution takes about
+// 2^difficulty hashes, checking one takes a single hash.
+func SolvesProofOfWork(challenge, solution string, difficulty int) bool {
+	if solution == "" || len(solution) > 64 {
+		return false
+	}
+	sum := sha256.Sum256([]byte(challenge + ":" + solution))
+	zeros := 0
+	for _, b := range sum {
+		if b != 0 {
+			zeros += bits.LeadingZeros8(b)
+			break
+		}
+		zeros += 8
+	}
+	return zeros >= difficulty
+}
+
+// IPRange returns the network of ip with the given prefix length, so that
+// clients sharing a network block are counted together.
+func IPRange(ip string, ipv4Bits, ipv6Bits int) string {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return ip
+	}
+	if v4 := parsed.To4(); v4 != nil {
+		return (&net.IPNet{IP: v4.Mask(net.CIDRMask(ipv4Bits, 32)), Mask: net.CIDRMask(ipv4Bits, 32)}).String()
+	}
+	return (&net.IPNet{IP: parsed.Mask(net.CIDRMask(ipv6Bits, 128)), Mask: net.CIDRMask(ipv6Bits, 128)}).String()
+}
@@ -0,0 +1,87 @@
+package utility
+
+import (
+	"redditBack/model"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+// Permissions beyond acting on one's own content. Owners may always edit and
+// remove their own posts and comments, so those need no permission.
+const (
+	PermissionRemoveAnyPost     = "posts:remove_any"
+	PermissionRemoveAnyComment  = "comments:remove_any"
+	PermissionManageUsers       = "users:manage"
+	PermissionManageInvites     = "invites:manage"
+	PermissionManageCommunities = "communities:manage"
+)
+
+var rolePermissions = map[string][]string{
+	RoleUser:      {},
+	RoleModerator: {PermissionRemoveAnyPost, PermissionRemoveAnyComment},
+	RoleAdmin:     {PermissionRemoveAnyPost, PermissionRemoveAnyComment, PermissionManageUsers, PermissionManageInvites, PermissionManageCommunities},
+}
+
+func IsValidRole(role string) bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
+
+// IsElevatedRole reports whether the role grants any permission at all.
+func IsElevatedRole(role string) bool {
+	return len(rolePermissions[role]) > 0
+}
+
+func RoleHasPermission(role, permission string) bool {
+	for _, granted := range rolePermissions[role] {
+		if granted == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// UserCan is the authoritative check on a user loaded from the database.
+// Elevated roles only take effect while two-factor authentication is on.
+func UserCan(user *model.User, permission string) bool {
+	return user != nil && user.TOTPEnabled && RoleHasPermission(user.Role, permission)
+}
+
+// RequirePermission guards routes by permission. The role from the token
+// rejects most callers without a database hit, and the rest are checked
+// again against the stored user, so a demotion takes effect immediately.
+// It must run after AuthMiddleware.
+func (u *UtilityFunctions) RequirePermission(permission string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		principal, ok := CurrentPrincipal(c)
+		if !ok || !RoleHasPermission(principal.Role, permission) {
+			c.JSON(403, gin.H{"error": "Forbidden"})
+			c.Abort()
+			return
+		}
+
+		user, err := u.Users.FindByID(c.Request.Context(), principal.UserID)
+		if err != nil {
+			c.JSON(500, gin.H{"error": "failed to load user"})
+			c.Abort()
+			return
+		}
+		if user != nil && RoleHasPermission(user.Role, permission) && !user.TOTPEnabled {
+			c.JSON(403, gin.H{"error": "two-factor authentication is required for this role"})
+			c.Abort()
+			return
+		}
+		if !UserCan(user, permission) {
+			c.JSON(403, gin.H{"error": "Forbidden"})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,55 @@
+package utility
+
+import (
+	"context"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Scopes a route can require. ScopeAccount covers account and credential
+// management and is never granted to personal access tokens.
+const (
+	ScopeRead       = "read"
+	ScopePostsWrite = "posts:write"
+	ScopeVotesWrite = "votes:write"
+	ScopeAccount    = "account"
+)
+
+// PersonalAccessTokenPrefix marks personal access tokens so the middleware
+// can tell them apart from JWTs without parsing.
+const PersonalAccessTokenPrefix = "rbp_"
+
+// GrantableScopes may be requested for a personal access token.
+var GrantableScopes = []string{ScopeRead, ScopePostsWrite, ScopeVotesWrite}
+
+// sessionScopes are held by every interactive login.
+var sessionScopes = []string{ScopeRead, ScopePostsWrite, ScopeVotesWrite, ScopeAccount}
+
+// PersonalAccessTokenAuthenticator resolves a personal access token to the
+// principal it acts as.
+type PersonalAccessTokenAuthenticator interface {
+	AuthenticateAccessToken(ctx context.Context, token string) (*Principal, error)
+}
+
+// RequireScope rejects credentials that were not granted scope. It must run
+// after AuthMiddleware.
+func (u *UtilityFunctions) RequireScope(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		principal, ok := CurrentPrincipal(c)
+		if !ok || !principal.HasScope(scope) {
+			c.JSON(403, gin.H{"error": "token is missing the " + scope + " scope"})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+func HasScope(scopes []string, scope string) bool {
+	for _, granted := range scopes {
+		if granted == scope {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,63 @@
+package utility
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Cookies set for browser clients. The CSRF cookie is readable by scripts
+// so the front-end can copy it into the CSRF header.
+const (
+	AccessTokenCookie  = "access_token"
+	RefreshTokenCookie = "refresh_token"
+	CSRFCookie         = "csrf_token"
+	CSRFHeader         = "X-CSRF-Token"
+	OIDCBindingCookie  = "oidc_binding"
+
+	refreshCookiePath = "/token/refresh"
+	oidcCookiePath    = "/oidc/"
+)
+
+// SetSessionCookies hands a token pair to a browser client.
+func SetSessionCookies(c *gin.Context, cfg SessionCookieConfig, accessToken, refreshToken, csrfToken string,
+	accessTTL, refreshTTL time.Duration) {
+	c.SetSameSite(cfg.SameSiteMode())
+	c.SetCookie(AccessTokenCookie, accessToken, int(accessTTL.Seconds()), "/", cfg.Domain, cfg.Secure, true)
+	c.SetCookie(RefreshTokenCookie, refreshToken, int(refreshTTL.Seconds()), refreshCookiePath, cfg.Domain, cfg.Secure, true)
+	c.SetCookie(CSRFCookie, csrfToken, int(refreshTTL.Seconds()), "/", cfg.Domain, cfg.Secure, false)
+}
+
+func ClearSessionCookies(c *gin.Context, cfg SessionCookieConfig) {
+	c.SetSameSite(cfg.SameSiteMode())
+	c.SetCookie(AccessTokenCookie, "", -1, "/", cfg.Domain, cfg.Secure, true)
+	c.SetCookie(RefreshTokenCookie, "", -1, refreshCookiePath, cfg.Domain, cfg.Secure, true)
+	c.SetCookie(CSRFCookie, "", -1, "/", cfg.Domain, cfg.Secure, false)
+}
+
+// SetOIDCBindingCookie ties an OpenID Connect flow to the browser that
+// started it. It is always SameSite=Lax: the provider sends the browser back
+// with a cross-site redirect, which a Strict cookie would not survive.
+func SetOIDCBindingCookie(c *gin.Context, cfg SessionCookieConfig, binding string) {
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(OIDCBindingCookie, binding, 0, oidcCookiePath, cfg.Domain, cfg.Secure, true)
+}
+
+func ClearOIDCBindingCookie(c *gin.Context, cfg SessionCookieConfig) {
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(OIDCBindingCookie, "", -1, oidcCookiePath, cfg.Domain, cfg.Secure, true)
+}
+
+func ValidCSRFToken(presented, expected string) bool {
+	return expected != "" && subtle.ConstantTimeCompare([]byte(presented), []byte(expected)) == 1
+}
+
+func isSafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	return false
+}
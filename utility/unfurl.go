@@ -0,0 +1,210 @@
+package utility
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/html"
+)
+
+var (
+	ErrBlockedAddress   = errors.New("link resolves to a non-public address")
+	ErrTooManyRedirects = errors.New("link redirects too often")
+)
+
+// LinkMetadata is what an unfurled page says about itself. ImageURL comes
+// from og:image and is absolute.
+type LinkMetadata struct {
+	Title       string
+	Description string
+	ImageURL    string
+}
+
+// Unfurler fetches pages for link previews. Every connection, including
+// those of redirects, is checked against the resolved IP address when it is
+// dialled, so DNS tricks cannot point it at internal services.
+type Unfurler struct {
+	client       *http.Client
+	maxBodyBytes int64
+	userAgent    string
+}
+
+func NewUnfurlerFromConfig(cfg UnfurlConfig) *Unfurler {
+	dialer := &net.Dialer{Timeout: cfg.Timeout}
+	if !cfg.AllowPrivateNetworks {
+		dialer.Control = func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if !IsPublicIP(net.ParseIP(host)) {
+				return ErrBlockedAddress
+			}
+			return nil
+		}
+	}
+
+	transport := &http.Transport{
+		// A proxy would make the connection checks look at the proxy instead
+		// of the target, so none is used.
+		Proxy:                 nil,
+		DialContext:           dialer.DialContext,
+		TLSHandshakeTimeout:   cfg.Timeout,
+		ResponseHeaderTimeout: cfg.Timeout,
+		MaxIdleConns:          10,
+		IdleConnTimeout:       30 * time.Second,
+	}
+	client := &http.Client{
+		Transport: transport,
+		Timeout:   cfg.Timeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) > cfg.MaxRedirects {
+				return ErrTooManyRedirects
+			}
+			if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+				return ErrInvalidLinkURL
+			}
+			return nil
+		},
+	}
+
+	return &Unfurler{client: client, maxBodyBytes: cfg.MaxBodyBytes, userAgent: cfg.UserAgent}
+}
+
+// Unfurl fetches rawURL and reads its title, description and OpenGraph
+// image. Pages that are not HTML unfurl to empty metadata.
+func (u *Unfurler) Unfurl(ctx context.Context, rawURL string) (*LinkMetadata, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", u.userAgent)
+	req.Header.Set("Accept", "text/html,application/xhtml+xml")
+
+	resp, err := u.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("link returned status %d", resp.StatusCode)
+	}
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType != "text/html" && mediaType != "application/xhtml+xml" {
+		return &LinkMetadata{}, nil
+	}
+
+	return parseLinkMetadata(io.LimitReader(resp.Body, u.maxBodyBytes), resp.Request.URL)
+}
+
+// parseLinkMetadata reads the head of an HTML page. OpenGraph values win
+// over the plain title and description meta tag.
+func parseLinkMetadata(body io.Reader, pageURL *url.URL) (*LinkMetadata, error) {
+	var title, ogTitle, description, ogDescription, ogImage string
+
+	tokenizer := html.NewTokenizer(body)
+	inTitle := false
+	for {
+		switch tokenizer.Next() {
+		case html.ErrorToken:
+			if err := tokenizer.Err(); err != nil && !errors.Is(err, io.EOF) {
+				return nil, err
+			}
+			return buildLinkMetadata(pageURL, firstNonEmpty(ogTitle, title),
+				firstNonEmpty(ogDescription, description), ogImage), nil
+
+		case html.StartTagToken, html.SelfClosingTagToken:
+			token := tokenizer.Token()
+			switch token.Data {
+			case "title":
+				inTitle = title == ""
+			case "meta":
+				key, content := metaAttributes(token)
+				switch key {
+				case "og:title":
+					ogTitle = content
+				case "og:description":
+					ogDescription = content
+				case "description":
+					description = content
+				case "og:image", "og:image:url", "og:image:secure_url":
+					if ogImage == "" {
+						ogImage = content
+					}
+				}
+			case "body":
+				// Everything of interest lives in the head.
+				return buildLinkMetadata(pageURL, firstNonEmpty(ogTitle, title),
+					firstNonEmpty(ogDescription, description), ogImage), nil
+			}
+
+		case html.TextToken:
+			if inTitle {
+				title += string(tokenizer.Text())
+			}
+
+		case html.EndTagToken:
+			if token := tokenizer.Token(); token.Data == "title" {
+				inTitle = false
+			}
+		}
+	}
+}
+
+func metaAttributes(token html.Token) (string, string) {
+	var key, content string
+	for _, attr := range token.Attr {
+		switch strings.ToLower(attr.Key) {
+		case "property", "name":
+			if key == "" {
+				key = strings.ToLower(strings.TrimSpace(attr.Val))
+			}
+		case "content":
+			content = attr.Val
+		}
+	}
+	return key, content
+}
+
+func buildLinkMetadata(pageURL *url.URL, title, description, image string) *LinkMetadata {
+	metadata := &LinkMetadata{
+		Title:       truncateText(title, 300),
+		Description: truncateText(description, 1000),
+	}
+	if image != "" {
+		if resolved, err := pageURL.Parse(strings.TrimSpace(image)); err == nil &&
+			(resolved.Scheme == "http" || resolved.Scheme == "https") && len(resolved.String()) <= MaxLinkURLLength {
+			metadata.ImageURL = resolved.String()
+		}
+	}
+	return metadata
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if strings.TrimSpace(value) != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// truncateText collapses whitespace and cuts text to at most limit runes.
+func truncateText(text string, limit int) string {
+	text = strings.Join(strings.Fields(text), " ")
+	runes := []rune(text)
+	if len(runes) > limit {
+		return string(runes[:limit])
+	}
+	return text
+}
@@ -0,0 +1,199 @@
+package utility
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testUnfurlConfig() UnfurlConfig {
+	return UnfurlConfig{
+		Timeout:              5 * time.Second,
+		MaxBodyBytes:         64 << 10,
+		MaxRedirects:         3,
+		UserAgent:            "test-unfurler",
+		AllowPrivateNetworks: true,
+	}
+}
+
+func servePage(t *testing.T, contentType, body string) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", contentType)
+		fmt.Fprint(w, body)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestUnfurlExtractsMetadata(t *testing.T) {
+	tests := []struct {
+		name string
+		page string
+		want LinkMetadata
+	}{
+		{
+			name: "title and description",
+			page: `<html><head><title> A  page </title><meta name="description" content="About it"></head></html>`,
+			want: LinkMetadata{Title: "A page", Description: "About it"},
+		},
+		{
+			name: "OpenGraph wins",
+			page: `<head><title>Plain</title><meta name="description" content="plain">
+				<meta property="og:title" content="Open Graph"><meta property="og:description" content="og">
+				<meta property="og:image" content="/images/a.png"></head>`,
+			want: LinkMetadata{Title: "Open Graph", Description: "og", ImageURL: "{{server}}/images/a.png"},
+		},
+		{
+			name: "absolute image",
+			page: `<meta property="og:image" content="https://cdn.example.com/a.png">`,
+			want: LinkMetadata{ImageURL: "https://cdn.example.com/a.png"},
+		},
+		{
+			name: "non-http image",
+			page: `<meta property="og:image" content="javascript:alert(1)">`,
+			want: LinkMetadata{},
+		},
+		{
+			name: "stops at body",
+			page: `<head><title>Head</title></head><body><meta property="og:title" content="Body"></body>`,
+			want: LinkMetadata{Title: "Head"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := servePage(t, "text/html; charset=utf-8", tt.page)
+			tt.want.ImageURL = strings.Replace(tt.want.ImageURL, "{{server}}", server.URL, 1)
+
+			metadata, err := NewUnfurlerFromConfig(testUnfurlConfig()).Unfurl(context.Background(), server.URL+"/page")
+			if err != nil {
+				t.Fatalf("Unfurl: %v", err)
+			}
+			if *metadata != tt.want {
+				t.Errorf("Unfurl = %+v, want %+v", *metadata, tt.want)
+			}
+		})
+	}
+}
+
+func TestUnfurlNonHTMLIsEmpty(t *testing.T) {
+	for _, contentType := range []string{"application/json", "image/png", "text/plain", ""} {
+		server := servePage(t, contentType, `<title>Not a page</title>`)
+		metadata, err := NewUnfurlerFromConfig(testUnfurlConfig()).Unfurl(context.Background(), server.URL)
+		if err != nil {
+			t.Fatalf("%q: Unfurl: %v", contentType, err)
+		}
+		if *metadata != (LinkMetadata{}) {
+			t.Errorf("%q: Unfurl = %+v, want empty metadata", contentType, *metadata)
+		}
+	}
+}
+
+func TestUnfurlErrorStatus(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+	if _, err := NewUnfurlerFromConfig(testUnfurlConfig()).Unfurl(context.Background(), server.URL); err == nil {
+		t.Fatal("Unfurl of a 404 succeeded")
+	}
+}
+
+func TestUnfurlReadsAtMostMaxBodyBytes(t *testing.T) {
+	padding := "<!--" + strings.Repeat("x", 1000) + "-->"
+	server := servePage(t, "text/html", `<head>`+padding+`<title>Late title</title></head>`)
+
+	cfg := testUnfurlConfig()
+	cfg.MaxBodyBytes = int64(len(padding))
+	metadata, err := NewUnfurlerFromConfig(cfg).Unfurl(context.Background(), server.URL)
+	if err != nil {
+		t.Fatalf("Unfurl: %v", err)
+	}
+	if metadata.Title != "" {
+		t.Errorf("title %q was read past MaxBodyBytes", metadata.Title)
+	}
+
+	cfg.MaxBodyBytes = 64 << 10
+	metadata, err = NewUnfurlerFromConfig(cfg).Unfurl(context.Background(), server.URL)
+	if err != nil {
+		t.Fatalf("Unfurl: %v", err)
+	}
+	if metadata.Title != "Late title" {
+		t.Errorf("title = %q, want %q", metadata.Title, "Late title")
+	}
+}
+
+// redirectServer redirects /hop/n to /hop/n-1 and serves a page at /hop/0.
+func redirectServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var hops int
+		if _, err := fmt.Sscanf(r.URL.Path, "/hop/%d", &hops); err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		if hops > 0 {
+			http.Redirect(w, r, fmt.Sprintf("/hop/%d", hops-1), http.StatusFound)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, `<title>Arrived</title>`)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestUnfurlRedirects(t *testing.T) {
+	server := redirectServer(t)
+	cfg := testUnfurlConfig()
+	unfurler := NewUnfurlerFromConfig(cfg)
+
+	metadata, err := unfurler.Unfurl(context.Background(), fmt.Sprintf("%s/hop/%d", server.URL, cfg.MaxRedirects))
+	if err != nil {
+		t.Fatalf("Unfurl with %d redirects: %v", cfg.MaxRedirects, err)
+	}
+	if metadata.Title != "Arrived" {
+		t.Errorf("title = %q, want %q", metadata.Title, "Arrived")
+	}
+
+	_, err = unfurler.Unfurl(context.Background(), fmt.Sprintf("%s/hop/%d", server.URL, cfg.MaxRedirects+1))
+	if !errors.Is(err, ErrTooManyRedirects) {
+		t.Errorf("Unfurl with %d redirects error = %v, want ErrTooManyRedirects", cfg.MaxRedirects+1, err)
+	}
+}
+
+func TestUnfurlRejectsRedirectToOtherScheme(t *testing.T) {
+	for _, location := range []string{"ftp://example.com/file", "file:///etc/passwd", "gopher://example.com/"} {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Location", location)
+			w.WriteHeader(http.StatusFound)
+		}))
+		_, err := NewUnfurlerFromConfig(testUnfurlConfig()).Unfurl(context.Background(), server.URL)
+		server.Close()
+		if !errors.Is(err, ErrInvalidLinkURL) {
+			t.Errorf("redirect to %s: error = %v, want ErrInvalidLinkURL", location, err)
+		}
+	}
+}
+
+func TestUnfurlBlocksPrivateNetworks(t *testing.T) {
+	server := servePage(t, "text/html", `<title>Internal</title>`)
+
+	cfg := testUnfurlConfig()
+	cfg.AllowPrivateNetworks = false
+	if _, err := NewUnfurlerFromConfig(cfg).Unfurl(context.Background(), server.URL); !errors.Is(err, ErrBlockedAddress) {
+		t.Fatalf("Unfurl of loopback server error = %v, want ErrBlockedAddress", err)
+	}
+
+	cfg.AllowPrivateNetworks = true
+	metadata, err := NewUnfurlerFromConfig(cfg).Unfurl(context.Background(), server.URL)
+	if err != nil {
+		t.Fatalf("Unfurl with private networks allowed: %v", err)
+	}
+	if metadata.Title != "Internal" {
+		t.Errorf("title = %q, want %q", metadata.Title, "Internal")
+	}
+}
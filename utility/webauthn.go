@@ -0,0 +1,377 @@
+package utility
+
+import (
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// COSE algorithm identifiers of the public keys we accept.
+const (
+	COSEAlgES256 = -7
+	COSEAlgEdDSA = -8
+	COSEAlgRS256 = -257
+)
+
+const (
+	authDataUserPresent  = 0x01
+	authDataUserVerified = 0x04
+	authDataAttested     = 0x40
+	authDataExtensions   = 0x80
+)
+
+var ErrInvalidWebAuthnResponse = errors.New("invalid webauthn response")
+
+// WebAuthnClientData is the part of clientDataJSON the relying party checks.
+type WebAuthnClientData struct {
+	Type        string `json:"type"`
+	Challenge   string `json:"challenge"`
+	Origin      string `json:"origin"`
+	CrossOrigin bool   `json:"crossOrigin"`
+}
+
+// WebAuthnCredentialDescriptor and the option types below are serialised in
+// the shape navigator.credentials.create() and .get() expect, with binary
+// values base64url encoded.
+type WebAuthnCredentialDescriptor struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+}
+
+type WebAuthnCredentialParameter struct {
+	Type string `json:"type"`
+	Alg  int    `json:"alg"`
+}
+
+type WebAuthnCreationOptions struct {
+	Challenge string `json:"challenge"`
+	RP        struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	} `json:"rp"`
+	User struct {
+		ID          string `json:"id"`
+		Name        string `json:"name"`
+		DisplayName string `json:"displayName"`
+	} `json:"user"`
+	PubKeyCredParams       []WebAuthnCredentialParameter  `json:"pubKeyCredParams"`
+	Timeout                int64                          `json:"timeout"`
+	Attestation            string                         `json:"attestation"`
+	ExcludeCredentials     []WebAuthnCredentialDescriptor `json:"excludeCredentials"`
+	AuthenticatorSelection struct {
+		ResidentKey      string `json:"residentKey"`
+		UserVerification string `json:"userVerification"`
+	} `json:"authenticatorSelection"`
+}
+
+type WebAuthnRequestOptions struct {
+	Challenge        string                         `json:"challenge"`
+	RPID             string                         `json:"rpId"`
+	Timeout          int64                          `json:"timeout"`
+	AllowCredentials []WebAuthnCredentialDescriptor `json:"allowCredentials"`
+	UserVerification string                         `json:"userVerification"`
+}
+
+// NewWebAuthnCreationOptions describes a registration ceremony for the given
+// user handle. Existing credentials are excluded so an authenticator is not
+// registered twice.
+func NewWebAuthnCreationOptions(cfg WebAuthnConfig, challenge string, userHandle []byte, username, displayName string,
+	exclude [][]byte) *WebAuthnCreationOptions {
+	options := &WebAuthnCreationOptions{
+		Challenge:   challenge,
+		Timeout:     cfg.ChallengeTTL.Milliseconds(),
+		Attestation: "none",
+	}
+	options.RP.ID = cfg.RPID
+	options.RP.Name = cfg.RPName
+	options.User.ID = base64.RawURLEncoding.EncodeToString(userHandle)
+	options.User.Name = username
+	options.User.DisplayName = displayName
+	for _, alg := range []int{COSEAlgES256, COSEAlgEdDSA, COSEAlgRS256} {
+		options.PubKeyCredParams = append(options.PubKeyCredParams, WebAuthnCredentialParameter{Type: "public-key", Alg: alg})
+	}
+	options.ExcludeCredentials = credentialDescriptors(exclude)
+	options.AuthenticatorSelection.ResidentKey = "preferred"
+	options.AuthenticatorSelection.UserVerification = "preferred"
+	return options
+}
+
+// NewWebAuthnRequestOptions describes an assertion ceremony. Without allowed
+// credentials the browser offers every discoverable passkey for the site.
+func NewWebAuthnRequestOptions(cfg WebAuthnConfig, challenge string, allow [][]byte) *WebAuthnRequestOptions {
+	return &WebAuthnRequestOptions{
+		Challenge:        challenge,
+		RPID:             cfg.RPID,
+		Timeout:          cfg.ChallengeTTL.Milliseconds(),
+		AllowCredentials: credentialDescriptors(allow),
+		UserVerification: "preferred",
+	}
+}
+
+func credentialDescriptors(ids [][]byte) []WebAuthnCredentialDescriptor {
+	descriptors := make([]WebAuthnCredentialDescriptor, 0, len(ids))
+	for _, id := range ids {
+		descriptors = append(descriptors, WebAuthnCredentialDescriptor{
+			Type: "public-key",
+			ID:   base64.RawURLEncoding.EncodeToString(id),
+		})
+	}
+	return descriptors
+}
+
+// DecodeWebAuthnBinary decodes the base64url values browsers hand out,
+// with or without padding.
+func DecodeWebAuthnBinary(value string) ([]byte, error) {
+	decoded, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(value, "="))
+	if err != nil {
+		return nil, ErrInvalidWebAuthnResponse
+	}
+	return decoded, nil
+}
+
+func ParseWebAuthnClientData(raw []byte) (*WebAuthnClientData, error) {
+	var clientData WebAuthnClientData
+	if err := json.Unmarshal(raw, &clientData); err != nil {
+		return nil, ErrInvalidWebAuthnResponse
+	}
+	return &clientData, nil
+}
+
+// WebAuthnRegistration is a verified new credential. PublicKey stays in its
+// COSE encoding, which is what VerifyWebAuthnAssertion expects back.
+type WebAuthnRegistration struct {
+	CredentialID []byte
+	PublicKey    []byte
+	SignCount    uint32
+	UserVerified bool
+}
+
+// VerifyWebAuthnRegistration checks the response of
+// navigator.credentials.create(). We ask for "none" attestation, so the
+// authenticator's make and model are not verified, only that the credential
+// was created for this site and this challenge.
+func VerifyWebAuthnRegistration(cfg WebAuthnConfig, challenge string, clientDataJSON, attestationObject []byte) (*WebAuthnRegistration, error) {
+	if err := checkWebAuthnClientData(cfg, clientDataJSON, "webauthn.create", challenge); err != nil {
+		return nil, err
+	}
+
+	decoded, rest, err := decodeCBOR(attestationObject)
+	if err != nil || len(rest) != 0 {
+		return nil, ErrInvalidWebAuthnResponse
+	}
+	attestation, ok := decoded.(map[interface{}]interface{})
+	if !ok {
+		return nil, ErrInvalidWebAuthnResponse
+	}
+	if format, _ := attestation["fmt"].(string); format != "none" {
+		return nil, fmt.Errorf("%w: unsupported attestation format %q", ErrInvalidWebAuthnResponse, format)
+	}
+	rawAuthData, ok := attestation["authData"].([]byte)
+	if !ok {
+		return nil, ErrInvalidWebAuthnResponse
+	}
+
+	authData, err := parseAuthenticatorData(cfg, rawAuthData)
+	if err != nil {
+		return nil, err
+	}
+	if authData.credentialID == nil {
+		return nil, ErrInvalidWebAuthnResponse
+	}
+	if _, err := parseCOSEKey(authData.publicKey); err != nil {
+		return nil, err
+	}
+
+	return &WebAuthnRegistration{
+		CredentialID: authData.credentialID,
+		PublicKey:    authData.publicKey,
+		SignCount:    authData.signCount,
+		UserVerified: authData.flags&authDataUserVerified != 0,
+	}, nil
+}
+
+// WebAuthnAssertion is the outcome of a verified login. Callers compare
+// SignCount with the stored counter to spot cloned authenticators.
+type WebAuthnAssertion struct {
+	SignCount    uint32
+	UserVerified bool
+}
+
+// VerifyWebAuthnAssertion checks the response of navigator.credentials.get()
+// against the stored COSE public key of the credential.
+func VerifyWebAuthnAssertion(cfg WebAuthnConfig, challenge string, publicKey, clientDataJSON, authenticatorData,
+	signature []byte) (*WebAuthnAssertion, error) {
+	if err := checkWebAuthnClientData(cfg, clientDataJSON, "webauthn.get", challenge); err != nil {
+		return nil, err
+	}
+	authData, err := parseAuthenticatorData(cfg, authenticatorData)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := parseCOSEKey(publicKey)
+	if err != nil {
+		return nil, err
+	}
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	signed := append(append([]byte{}, authenticatorData...), clientDataHash[:]...)
+	if !verifyCOSESignature(key, signed, signature) {
+		return nil, ErrInvalidWebAuthnResponse
+	}
+
+	return &WebAuthnAssertion{
+		SignCount:    authData.signCount,
+		UserVerified: authData.flags&authDataUserVerified != 0,
+	}, nil
+}
+
+func checkWebAuthnClientData(cfg WebAuthnConfig, raw []byte, ceremony, challenge string) error {
+	clientData, err := ParseWebAuthnClientData(raw)
+	if err != nil {
+		return err
+	}
+	if clientData.Type != ceremony || clientData.CrossOrigin {
+		return ErrInvalidWebAuthnResponse
+	}
+	if challenge == "" || subtle.ConstantTimeCompare([]byte(clientData.Challenge), []byte(challenge)) != 1 {
+		return ErrInvalidWebAuthnResponse
+	}
+	for _, origin := range cfg.Origins {
+		if clientData.Origin == origin {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: unexpected origin %q", ErrInvalidWebAuthnResponse, clientData.Origin)
+}
+
+type authenticatorData struct {
+	flags        byte
+	signCount    uint32
+	credentialID []byte
+	publicKey    []byte
+}
+
+// parseAuthenticatorData reads the binary authenticator data and checks it
+// was produced for our relying party with the user present.
+func parseAuthenticatorData(cfg WebAuthnConfig, data []byte) (*authenticatorData, error) {
+	if len(data) < 37 {
+		return nil, ErrInvalidWebAuthnResponse
+	}
+	rpIDHash := sha256.Sum256([]byte(cfg.RPID))
+	if subtle.ConstantTimeCompare(data[:32], rpIDHash[:]) != 1 {
+		return nil, ErrInvalidWebAuthnResponse
+	}
+
+	authData := &authenticatorData{
+		flags:     data[32],
+		signCount: binary.BigEndian.Uint32(data[33:37]),
+	}
+	if authData.flags&authDataUserPresent == 0 {
+		return nil, ErrInvalidWebAuthnResponse
+	}
+
+	rest := data[37:]
+	if authData.flags&authDataAttested != 0 {
+		// AAGUID, then the length prefixed credential ID and its COSE key.
+		if len(rest) < 18 {
+			return nil, ErrInvalidWebAuthnResponse
+		}
+		idLength := int(binary.BigEndian.Uint16(rest[16:18]))
+		rest = rest[18:]
+		if idLength == 0 || idLength > 1023 || len(rest) < idLength {
+			return nil, ErrInvalidWebAuthnResponse
+		}
+		authData.credentialID = rest[:idLength]
+		rest = rest[idLength:]
+
+		_, after, err := decodeCBOR(rest)
+		if err != nil {
+			return nil, ErrInvalidWebAuthnResponse
+		}
+		authData.publicKey = rest[:len(rest)-len(after)]
+		rest = after
+	}
+	if authData.flags&authDataExtensions != 0 {
+		var err error
+		if _, rest, err = decodeCBOR(rest); err != nil {
+			return nil, ErrInvalidWebAuthnResponse
+		}
+	}
+	if len(rest) != 0 {
+		return nil, ErrInvalidWebAuthnResponse
+	}
+	return authData, nil
+}
+
+// parseCOSEKey turns a COSE_Key into the matching crypto public key. Only
+// ES256 on P-256, EdDSA on Ed25519 and RS256 are accepted.
+func parseCOSEKey(raw []byte) (crypto.PublicKey, error) {
+	decoded, rest, err := decodeCBOR(raw)
+	if err != nil || len(rest) != 0 {
+		return nil, ErrInvalidWebAuthnResponse
+	}
+	key, ok := decoded.(map[interface{}]interface{})
+	if !ok {
+		return nil, ErrInvalidWebAuthnResponse
+	}
+	keyType, _ := key[int64(1)].(int64)
+	alg, _ := key[int64(3)].(int64)
+	curve, _ := key[int64(-1)].(int64)
+
+	switch {
+	case keyType == 2 && alg == COSEAlgES256 && curve == 1:
+		x, _ := key[int64(-2)].([]byte)
+		y, _ := key[int64(-3)].([]byte)
+		if len(x) != 32 || len(y) != 32 {
+			return nil, ErrInvalidWebAuthnResponse
+		}
+		// crypto/ecdh rejects points that are not on the curve.
+		if _, err := ecdh.P256().NewPublicKey(append(append([]byte{4}, x...), y...)); err != nil {
+			return nil, ErrInvalidWebAuthnResponse
+		}
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	case keyType == 1 && alg == COSEAlgEdDSA && curve == 6:
+		x, _ := key[int64(-2)].([]byte)
+		if len(x) != ed25519.PublicKeySize {
+			return nil, ErrInvalidWebAuthnResponse
+		}
+		return ed25519.PublicKey(x), nil
+	case keyType == 3 && alg == COSEAlgRS256:
+		n, _ := key[int64(-1)].([]byte)
+		e, _ := key[int64(-2)].([]byte)
+		if len(e) == 0 || len(e) > 4 {
+			return nil, ErrInvalidWebAuthnResponse
+		}
+		modulus := new(big.Int).SetBytes(n)
+		if modulus.BitLen() < 2048 {
+			return nil, ErrInvalidWebAuthnResponse
+		}
+		return &rsa.PublicKey{N: modulus, E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	}
+	return nil, fmt.Errorf("%w: unsupported public key", ErrInvalidWebAuthnResponse)
+}
+
+func verifyCOSESignature(key crypto.PublicKey, data, signature []byte) bool {
+	digest := sha256.Sum256(data)
+	switch key := key.(type) {
+	case *ecdsa.PublicKey:
+		return ecdsa.VerifyASN1(key, digest[:], signature)
+	case ed25519.PublicKey:
+		return ed25519.Verify(key, data, signature)
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature) == nil
+	}
+	return false
+}
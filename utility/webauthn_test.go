@@ -0,0 +1,215 @@
+package utility
+
+import (
+	"bytes"
+	"errors"
+	"redditBack/utility/webauthntest"
+	"testing"
+	"time"
+)
+
+func testWebAuthnConfig() WebAuthnConfig {
+	return WebAuthnConfig{
+		RPID:         "example.com",
+		RPName:       "Example",
+		Origins:      []string{"https://example.com"},
+		ChallengeTTL: time.Minute,
+	}
+}
+
+func newTestAuthenticator(t *testing.T) *webauthntest.Authenticator {
+	t.Helper()
+	authenticator, err := webauthntest.NewAuthenticator("example.com", "https://example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return authenticator
+}
+
+func TestWebAuthnRegisterThenAssert(t *testing.T) {
+	cfg := testWebAuthnConfig()
+	authenticator := newTestAuthenticator(t)
+
+	clientDataJSON, attestationObject := authenticator.Register("register-challenge")
+	registration, err := VerifyWebAuthnRegistration(cfg, "register-challenge", clientDataJSON, attestationObject)
+	if err != nil {
+		t.Fatalf("VerifyWebAuthnRegistration: %v", err)
+	}
+	if !bytes.Equal(registration.CredentialID, authenticator.CredentialID) {
+		t.Fatalf("credential ID = %x, want %x", registration.CredentialID, authenticator.CredentialID)
+	}
+	if !bytes.Equal(registration.PublicKey, authenticator.COSEKey()) {
+		t.Fatal("registration did not keep the COSE key")
+	}
+
+	authenticator.UserVerified = true
+	clientDataJSON, authenticatorData, signature := authenticator.Assert("login-challenge")
+	assertion, err := VerifyWebAuthnAssertion(cfg, "login-challenge", registration.PublicKey,
+		clientDataJSON, authenticatorData, signature)
+	if err != nil {
+		t.Fatalf("VerifyWebAuthnAssertion: %v", err)
+	}
+	if assertion.SignCount != 1 || !assertion.UserVerified {
+		t.Fatalf("assertion = %+v, want sign count 1 with user verification", assertion)
+	}
+}
+
+func TestWebAuthnRejectsInvalidResponses(t *testing.T) {
+	cfg := testWebAuthnConfig()
+	registered := newTestAuthenticator(t)
+	clientDataJSON, attestationObject := registered.Register("register-challenge")
+	registration, err := VerifyWebAuthnRegistration(cfg, "register-challenge", clientDataJSON, attestationObject)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := newTestAuthenticator(t)
+
+	tests := []struct {
+		name   string
+		assert func() (clientDataJSON, authenticatorData, signature []byte)
+	}{
+		{"wrong origin", func() ([]byte, []byte, []byte) {
+			registered.Origin = "https://evil.example.com"
+			defer func() { registered.Origin = "https://example.com" }()
+			return registered.Assert("login-challenge")
+		}},
+		{"wrong RP ID hash", func() ([]byte, []byte, []byte) {
+			registered.RPID = "evil.example.com"
+			defer func() { registered.RPID = "example.com" }()
+			return registered.Assert("login-challenge")
+		}},
+		{"wrong challenge", func() ([]byte, []byte, []byte) {
+			return registered.Assert("another-challenge")
+		}},
+		{"registration response", func() ([]byte, []byte, []byte) {
+			clientDataJSON, _ := registered.Register("login-challenge")
+			_, authenticatorData, signature := registered.Assert("login-challenge")
+			return clientDataJSON, authenticatorData, signature
+		}},
+		{"tampered signature", func() ([]byte, []byte, []byte) {
+			clientDataJSON, authenticatorData, signature := registered.Assert("login-challenge")
+			signature[len(signature)-1] ^= 0x01
+			return clientDataJSON, authenticatorData, signature
+		}},
+		{"tampered authenticator data", func() ([]byte, []byte, []byte) {
+			clientDataJSON, authenticatorData, signature := registered.Assert("login-challenge")
+			authenticatorData[36]++
+			return clientDataJSON, authenticatorData, signature
+		}},
+		{"signed by another key", func() ([]byte, []byte, []byte) {
+			return other.Assert("login-challenge")
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clientDataJSON, authenticatorData, signature := tt.assert()
+			_, err := VerifyWebAuthnAssertion(cfg, "login-challenge", registration.PublicKey,
+				clientDataJSON, authenticatorData, signature)
+			if !errors.Is(err, ErrInvalidWebAuthnResponse) {
+				t.Fatalf("VerifyWebAuthnAssertion error = %v, want ErrInvalidWebAuthnResponse", err)
+			}
+		})
+	}
+}
+
+func TestWebAuthnRegistrationRejectsWrongOriginAndRPID(t *testing.T) {
+	cfg := testWebAuthnConfig()
+
+	wrongOrigin := newTestAuthenticator(t)
+	wrongOrigin.Origin = "https://evil.example.com"
+	clientDataJSON, attestationObject := wrongOrigin.Register("challenge")
+	if _, err := VerifyWebAuthnRegistration(cfg, "challenge", clientDataJSON, attestationObject); !errors.Is(err, ErrInvalidWebAuthnResponse) {
+		t.Errorf("wrong origin: error = %v, want ErrInvalidWebAuthnResponse", err)
+	}
+
+	wrongRPID := newTestAuthenticator(t)
+	wrongRPID.RPID = "evil.example.com"
+	clientDataJSON, attestationObject = wrongRPID.Register("challenge")
+	if _, err := VerifyWebAuthnRegistration(cfg, "challenge", clientDataJSON, attestationObject); !errors.Is(err, ErrInvalidWebAuthnResponse) {
+		t.Errorf("wrong RP ID: error = %v, want ErrInvalidWebAuthnResponse", err)
+	}
+}
+
+func TestWebAuthnRegistrationRejectsTruncatedAttestation(t *testing.T) {
+	cfg := testWebAuthnConfig()
+	clientDataJSON, attestationObject := newTestAuthenticator(t).Register("challenge")
+
+	for i := 0; i < len(attestationObject); i++ {
+		if _, err := VerifyWebAuthnRegistration(cfg, "challenge", clientDataJSON, attestationObject[:i]); err == nil {
+			t.Fatalf("attestation truncated to %d of %d bytes was accepted", i, len(attestationObject))
+		}
+	}
+}
+
+func TestDecodeCBORRejectsMalformedInput(t *testing.T) {
+	deeplyNested := bytes.Repeat([]byte{0x81}, maxCBORDepth+2)
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"missing one byte argument", []byte{0x18}},
+		{"missing eight byte argument", []byte{0x1b, 0x00, 0x00}},
+		{"byte string longer than input", []byte{0x45, 0x01, 0x02}},
+		{"huge byte string length", []byte{0x5b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}},
+		{"huge array length", []byte{0x9b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}},
+		{"huge map length", []byte{0xbb, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}},
+		{"integer beyond int64", []byte{0x1b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}},
+		{"negative beyond int64", []byte{0x3b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}},
+		{"indefinite length byte string", []byte{0x5f, 0x41, 0x00, 0xff}},
+		{"indefinite length map", []byte{0xbf, 0x01, 0x02, 0xff}},
+		{"tag", []byte{0xc0, 0x01}},
+		{"half float", []byte{0xf9, 0x3c, 0x00}},
+		{"break outside indefinite item", []byte{0xff}},
+		{"reserved additional info", []byte{0x1c}},
+		{"array missing items", []byte{0x83, 0x01}},
+		{"map missing value", []byte{0xa1, 0x01}},
+		{"map with array key", []byte{0xa1, 0x80, 0x01}},
+		{"map with byte string key", []byte{0xa1, 0x41, 0x00, 0x01}},
+		{"nested too deeply", append(deeplyNested, 0x01)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := decodeCBOR(tt.data); err == nil {
+				t.Fatalf("decodeCBOR(%x) succeeded", tt.data)
+			}
+		})
+	}
+}
+
+func TestDecodeCBORTruncatedInputNeverPanics(t *testing.T) {
+	authenticator := newTestAuthenticator(t)
+	_, attestationObject := authenticator.Register("challenge")
+	for _, valid := range [][]byte{attestationObject, authenticator.COSEKey()} {
+		if _, rest, err := decodeCBOR(valid); err != nil || len(rest) != 0 {
+			t.Fatalf("decodeCBOR(valid) = %v with %d bytes left", err, len(rest))
+		}
+		for i := 0; i < len(valid); i++ {
+			if _, _, err := decodeCBOR(valid[:i]); err == nil {
+				t.Fatalf("decodeCBOR accepted input truncated to %d of %d bytes", i, len(valid))
+			}
+		}
+	}
+}
+
+// FuzzDecodeCBOR checks the decoder never panics and never reports more
+// bytes left over than it was given. The seeds run as part of go test.
+func FuzzDecodeCBOR(f *testing.F) {
+	authenticator, err := webauthntest.NewAuthenticator("example.com", "https://example.com")
+	if err != nil {
+		f.Fatal(err)
+	}
+	_, attestationObject := authenticator.Register("challenge")
+	f.Add(attestationObject)
+	f.Add(authenticator.COSEKey())
+	f.Add([]byte{0xa1, 0x80, 0x01})
+	f.Add(bytes.Repeat([]byte{0x81}, 64))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		_, rest, err := decodeCBOR(data)
+		if err == nil && len(rest) >= len(data) {
+			t.Fatalf("decodeCBOR consumed nothing from %x", data)
+		}
+		parseCOSEKey(data)
+	})
+}
@@ -0,0 +1,149 @@
+// Package webauthntest provides a software authenticator for testing the
+// WebAuthn relying party code without a browser.
+package webauthntest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+)
+
+const (
+	flagUserPresent  = 0x01
+	flagUserVerified = 0x04
+	flagAttested     = 0x40
+)
+
+// Authenticator holds one ES256 credential and answers registration and
+// assertion ceremonies the way a browser would hand them to the server.
+// Tests change the exported fields to produce invalid responses.
+type Authenticator struct {
+	RPID         string
+	Origin       string
+	CredentialID []byte
+	// SignCount is reported by the next assertion, after which it is
+	// incremented.
+	SignCount    uint32
+	UserVerified bool
+
+	key *ecdsa.PrivateKey
+}
+
+func NewAuthenticator(rpID, origin string) (*Authenticator, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	credentialID := make([]byte, 16)
+	if _, err := rand.Read(credentialID); err != nil {
+		return nil, err
+	}
+	return &Authenticator{RPID: rpID, Origin: origin, CredentialID: credentialID, SignCount: 1, key: key}, nil
+}
+
+// Register answers navigator.credentials.create() with a "none" attestation.
+func (a *Authenticator) Register(challenge string) (clientDataJSON, attestationObject []byte) {
+	clientDataJSON = a.clientData("webauthn.create", challenge)
+
+	authData := a.authData(flagAttested, 0)
+	authData = append(authData, make([]byte, 16)...) // AAGUID
+	authData = binary.BigEndian.AppendUint16(authData, uint16(len(a.CredentialID)))
+	authData = append(authData, a.CredentialID...)
+	authData = append(authData, a.COSEKey()...)
+
+	attestationObject = appendHead(nil, 5, 3)
+	attestationObject = appendText(attestationObject, "fmt")
+	attestationObject = appendText(attestationObject, "none")
+	attestationObject = appendText(attestationObject, "attStmt")
+	attestationObject = appendHead(attestationObject, 5, 0)
+	attestationObject = appendText(attestationObject, "authData")
+	attestationObject = appendBytes(attestationObject, authData)
+	return clientDataJSON, attestationObject
+}
+
+// Assert answers navigator.credentials.get() and signs the result.
+func (a *Authenticator) Assert(challenge string) (clientDataJSON, authenticatorData, signature []byte) {
+	clientDataJSON = a.clientData("webauthn.get", challenge)
+	authenticatorData = a.authData(0, a.SignCount)
+	a.SignCount++
+
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	digest := sha256.Sum256(append(append([]byte{}, authenticatorData...), clientDataHash[:]...))
+	signature, err := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+	if err != nil {
+		panic(err)
+	}
+	return clientDataJSON, authenticatorData, signature
+}
+
+// COSEKey returns the credential's public key as a COSE_Key.
+func (a *Authenticator) COSEKey() []byte {
+	key := appendHead(nil, 5, 5)
+	key = appendInt(key, 1)
+	key = appendInt(key, 2) // kty: EC2
+	key = appendInt(key, 3)
+	key = appendInt(key, -7) // alg: ES256
+	key = appendInt(key, -1)
+	key = appendInt(key, 1) // crv: P-256
+	key = appendInt(key, -2)
+	key = appendBytes(key, a.key.PublicKey.X.FillBytes(make([]byte, 32)))
+	key = appendInt(key, -3)
+	key = appendBytes(key, a.key.PublicKey.Y.FillBytes(make([]byte, 32)))
+	return key
+}
+
+func (a *Authenticator) clientData(ceremony, challenge string) []byte {
+	clientDataJSON, err := json.Marshal(map[string]interface{}{
+		"type":        ceremony,
+		"challenge":   challenge,
+		"origin":      a.Origin,
+		"crossOrigin": false,
+	})
+	if err != nil {
+		panic(err)
+	}
+	return clientDataJSON
+}
+
+func (a *Authenticator) authData(flags byte, signCount uint32) []byte {
+	flags |= flagUserPresent
+	if a.UserVerified {
+		flags |= flagUserVerified
+	}
+	rpIDHash := sha256.Sum256([]byte(a.RPID))
+	data := append(rpIDHash[:], flags)
+	return binary.BigEndian.AppendUint32(data, signCount)
+}
+
+// appendHead encodes a CBOR major type with its argument.
+func appendHead(data []byte, major byte, n uint64) []byte {
+	switch {
+	case n < 24:
+		return append(data, major<<5|byte(n))
+	case n <= 0xff:
+		return append(data, major<<5|24, byte(n))
+	case n <= 0xffff:
+		return binary.BigEndian.AppendUint16(append(data, major<<5|25), uint16(n))
+	case n <= 0xffffffff:
+		return binary.BigEndian.AppendUint32(append(data, major<<5|26), uint32(n))
+	}
+	return binary.BigEndian.AppendUint64(append(data, major<<5|27), n)
+}
+
+func appendInt(data []byte, v int64) []byte {
+	if v < 0 {
+		return appendHead(data, 1, uint64(-1-v))
+	}
+	return appendHead(data, 0, uint64(v))
+}
+
+func appendBytes(data []byte, b []byte) []byte {
+	return append(appendHead(data, 2, uint64(len(b))), b...)
+}
+
+func appendText(data []byte, s string) []byte {
+	return append(appendHead(data, 3, uint64(len(s))), s...)
+}